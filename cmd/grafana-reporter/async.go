@@ -0,0 +1,264 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+// Status of an asynchronous report job
+const (
+	jobPending = "pending"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// reportJob is a report generated in the background. Its temporary directory is kept until
+// the result is fetched or the job expires.
+type reportJob struct {
+	id     string
+	status string
+	err    error
+	rep    report.Report
+	format report.OutputFormat
+	file   io.ReadCloser
+	timer  *time.Timer // Expires the finished job
+}
+
+// jobStore holds the asynchronous report jobs. Finished jobs are dropped, and their
+// temporary files removed, ttl after they finish unless their result is fetched first.
+type jobStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	jobs    map[string]*reportJob
+	running sync.WaitGroup
+	ctx     context.Context // Cancelled by close, aborting jobs still running
+	cancel  context.CancelFunc
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	if ttl <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobStore{ttl: ttl, jobs: map[string]*reportJob{}, ctx: ctx, cancel: cancel}
+}
+
+// start generates the report in the background and returns the id of its job. release is
+// called when generation ends.
+func (s *jobStore) start(rep report.Report, format report.OutputFormat, release func()) string {
+	job := &reportJob{id: uuid.New(), status: jobPending, rep: rep, format: format}
+	s.mu.Lock()
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer release()
+		file, err := rep.Generate(s.ctx)
+		if err != nil {
+			slog.Error("Error generating report", "job", job.id, "error", err)
+		}
+		s.finish(job, file, err)
+	}()
+	return job.id
+}
+
+func (s *jobStore) finish(job *reportJob, file io.ReadCloser, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		job.status, job.err = jobFailed, err
+	} else {
+		job.status, job.file = jobDone, file
+	}
+	job.timer = time.AfterFunc(s.ttl, func() { s.expire(job.id) })
+}
+
+// expire drops the finished job and removes its files
+func (s *jobStore) expire(id string) {
+	if job, ok := s.remove(id); ok {
+		slog.Debug("Report job expired", "job", id)
+		job.discard()
+	}
+}
+
+// get returns a copy of the job's state. A nil store, with asynchronous reports disabled,
+// has no jobs.
+func (s *jobStore) get(id string) (reportJob, bool) {
+	if s == nil {
+		return reportJob{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return reportJob{}, false
+	}
+	return *job, true
+}
+
+// remove takes the finished job out of the store. The caller then owns its files.
+func (s *jobStore) remove(id string) (*reportJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.status == jobPending {
+		return nil, false
+	}
+	delete(s.jobs, id)
+	job.timer.Stop()
+	return job, true
+}
+
+// discard closes the result of the job and removes its temporary files
+func (job *reportJob) discard() {
+	if job.file != nil {
+		job.file.Close()
+	}
+	job.rep.Clean()
+}
+
+// close lets running jobs finish for up to timeout before cancelling them, then removes the
+// files of all jobs
+func (s *jobStore) close(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Report jobs did not finish in time, cancelling them", "timeout", timeout)
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.jobs))
+	for id := range s.jobs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		if job, ok := s.remove(id); ok {
+			job.discard()
+		}
+	}
+}
+
+// jobResponse is the JSON describing a job
+type jobResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Result string `json:"result,omitempty"` // URL of the finished report
+}
+
+func writeJob(w http.ResponseWriter, code int, job reportJob) {
+	resp := jobResponse{ID: job.id, Status: job.status}
+	switch job.status {
+	case jobFailed:
+		resp.Error = job.err.Error()
+	case jobDone:
+		resp.Result = "/api/report/result/" + job.id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// asyncHandler starts generating the report in the background and answers 202 Accepted with
+// the id of its job, to be polled at /api/report/status/{jobId}
+func (h ServeReportHandler) asyncHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slog.Info("Reporter called to generate a report asynchronously")
+		if h.jobs == nil {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "asynchronous reports are disabled")
+			return
+		}
+		s, err := requestSettings(req)
+		if err != nil {
+			requestError(w, req, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		release, ok := h.acquireReportSlot(w, req)
+		if !ok {
+			return
+		}
+		rep, _ := h.requestReport(req, s)
+		id := h.jobs.start(rep, s.opts.Format, release)
+		slog.Info("Started report job", "job", id)
+		w.Header().Set("Location", "/api/report/status/"+id)
+		writeJob(w, http.StatusAccepted, reportJob{id: id, status: jobPending})
+	})
+}
+
+// jobStatusHandler answers whether the job is pending, done or failed
+func (h ServeReportHandler) jobStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["jobId"]
+		job, ok := h.jobs.get(id)
+		if !ok {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "unknown or expired report job: "+id)
+			return
+		}
+		writeJob(w, http.StatusOK, job)
+	})
+}
+
+// jobResultHandler sends the finished report. A result can be fetched once; its temporary
+// files are removed afterwards.
+func (h ServeReportHandler) jobResultHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["jobId"]
+		job, ok := h.jobs.get(id)
+		if !ok {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "unknown or expired report job: "+id)
+			return
+		}
+		switch job.status {
+		case jobPending:
+			writeJob(w, http.StatusConflict, job)
+			return
+		case jobFailed:
+			status, _ := reportError(job.err)
+			writeJob(w, status, job)
+			return
+		}
+		taken, ok := h.jobs.remove(id)
+		if !ok {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "unknown or expired report job: "+id)
+			return
+		}
+		defer taken.discard()
+		writeReport(w, taken.rep.Title(), taken.format, taken.file)
+	})
+}
@@ -0,0 +1,170 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// jobReport is a report whose generation waits until release is closed and that counts how
+// often it is cleaned
+type jobReport struct {
+	release chan struct{}
+	err     error
+	cleaned *int32
+}
+
+func (r jobReport) Generate(ctx context.Context) (io.ReadCloser, error) {
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ioutil.NopCloser(strings.NewReader("report")), nil
+}
+
+func (r jobReport) Clean() { atomic.AddInt32(r.cleaned, 1) }
+
+func (r jobReport) Title() string { return "title" }
+
+func TestAsyncReports(t *testing.T) {
+	Convey("When generating reports asynchronously", t, func() {
+		rep := jobReport{release: make(chan struct{}), cleaned: new(int32)}
+		newReport := func(g grafana.Client, _ string, _ grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
+			return rep
+		}
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			return grafana.NewV5Client(url, apiToken, variables, true, false, grafana.ClientOptions{})
+		}
+		jobs := newJobStore(time.Minute)
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport, jobs: jobs}, ServeReportHandler{})
+		request := func(method, target string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(method, target, nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+		status := func(id string) (int, jobResponse) {
+			rec := request("GET", "/api/report/status/"+id)
+			var resp jobResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			return rec.Code, resp
+		}
+		waitFor := func(id string) jobResponse {
+			for i := 0; i < 200; i++ {
+				if _, resp := status(id); resp.Status != jobPending {
+					return resp
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			return jobResponse{}
+		}
+
+		rec := request("POST", "/api/v5/report/async/testDash")
+		var started jobResponse
+		json.Unmarshal(rec.Body.Bytes(), &started)
+
+		Convey("The request should be accepted with the id of a pending job", func() {
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+			So(started.ID, ShouldNotBeEmpty)
+			So(rec.Header().Get("Location"), ShouldEqual, "/api/report/status/"+started.ID)
+			code, resp := status(started.ID)
+			So(code, ShouldEqual, http.StatusOK)
+			So(resp.Status, ShouldEqual, jobPending)
+			So(request("GET", "/api/report/result/"+started.ID).Code, ShouldEqual, http.StatusConflict)
+			close(rep.release)
+		})
+
+		Convey("The finished report should be fetched once, then cleaned", func() {
+			close(rep.release)
+			resp := waitFor(started.ID)
+			So(resp.Status, ShouldEqual, jobDone)
+			So(resp.Result, ShouldEqual, "/api/report/result/"+started.ID)
+			So(atomic.LoadInt32(rep.cleaned), ShouldEqual, 0)
+
+			result := request("GET", resp.Result)
+			So(result.Code, ShouldEqual, http.StatusOK)
+			So(result.Body.String(), ShouldEqual, "report")
+			So(atomic.LoadInt32(rep.cleaned), ShouldEqual, 1)
+			So(request("GET", resp.Result).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Closing the store should cancel running jobs and clean them", func() {
+			jobs.close(10 * time.Millisecond)
+			So(atomic.LoadInt32(rep.cleaned), ShouldBeGreaterThan, 0)
+			code, _ := status(started.ID)
+			So(code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+
+	Convey("When an asynchronous report fails", t, func() {
+		rep := jobReport{release: make(chan struct{}), err: errors.New("renderer down"), cleaned: new(int32)}
+		close(rep.release)
+		jobs := newJobStore(20 * time.Millisecond)
+		id := jobs.start(rep, report.FormatPDF, func() {})
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{jobs: jobs}, ServeReportHandler{})
+		get := func(target string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", target, nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+		jobs.running.Wait()
+
+		Convey("Its status and result should carry the error", func() {
+			rec := get("/api/report/status/" + id)
+			So(rec.Body.String(), ShouldContainSubstring, `"status":"failed"`)
+			So(rec.Body.String(), ShouldContainSubstring, "renderer down")
+			So(get("/api/report/result/"+id).Code, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("It should expire after the ttl", func() {
+			time.Sleep(100 * time.Millisecond)
+			So(get("/api/report/status/"+id).Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+
+	Convey("When asynchronous reports are disabled", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{}, ServeReportHandler{})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v5/report/async/testDash", nil)
+		router.ServeHTTP(rec, req)
+		So(rec.Code, ShouldEqual, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,58 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// reporterKeyHeader carries the key of the reporter's own API, set by -reporter-api-key
+const reporterKeyHeader = "X-Reporter-Key"
+
+// requireAPIKey is middleware that answers 401 Unauthorized to requests that do not carry key,
+// either in the X-Reporter-Key header or, if bearer is set, as an "Authorization: Bearer" token
+func requireAPIKey(key string, bearer bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			given := req.Header.Get(reporterKeyHeader)
+			if given == "" && bearer {
+				given, _ = bearerToken(req)
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+				slog.Warn("Refusing request without a valid reporter API key", "path", req.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="grafana-reporter"`)
+				requestError(w, req, http.StatusUnauthorized, codeUnauthorized, "a valid reporter API key is required in the "+reporterKeyHeader+" header")
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// bearerToken is the token of the "Authorization: Bearer" header of the request, if it has one
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(auth, "Bearer "), true
+}
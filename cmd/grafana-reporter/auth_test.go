@@ -0,0 +1,85 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	Convey("When the reporter's API requires a key", t, func() {
+		router := mux.NewRouter()
+		RegisterHealthHandlers(router, nil)
+		api := router.NewRoute().Subrouter()
+		api.Use(requireAPIKey("s3cret", true))
+		api.HandleFunc("/api/v5/report/{dashId}", func(w http.ResponseWriter, r *http.Request) {})
+		get := func(target string, header http.Header) int {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", target, nil)
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Convey("Requests with the key in the X-Reporter-Key header should be served", func() {
+			So(get("/api/v5/report/testDash", http.Header{"X-Reporter-Key": {"s3cret"}}), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Requests with the key as a bearer token should be served", func() {
+			So(get("/api/v5/report/testDash", http.Header{"Authorization": {"Bearer s3cret"}}), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Requests without the key or with a wrong one should get 401", func() {
+			So(get("/api/v5/report/testDash", nil), ShouldEqual, http.StatusUnauthorized)
+			So(get("/api/v5/report/testDash", http.Header{"X-Reporter-Key": {"guess"}}), ShouldEqual, http.StatusUnauthorized)
+			So(get("/api/v5/report/testDash", http.Header{"Authorization": {"Basic s3cret"}}), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("The 401 should be a JSON error to clients that accept JSON", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			req.Header.Set("Accept", "application/json")
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(rec.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+			var body errorResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Code, ShouldEqual, codeUnauthorized)
+		})
+
+		Convey("Bearer tokens should not count when they are forwarded to Grafana", func() {
+			api := router.NewRoute().Subrouter()
+			api.Use(requireAPIKey("s3cret", false))
+			api.HandleFunc("/api/v9/report/{dashId}", func(w http.ResponseWriter, r *http.Request) {})
+			So(get("/api/v9/report/testDash", http.Header{"Authorization": {"Bearer s3cret"}}), ShouldEqual, http.StatusUnauthorized)
+			So(get("/api/v9/report/testDash", http.Header{"X-Reporter-Key": {"s3cret"}}), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Health probes should not need the key", func() {
+			So(get("/healthz", nil), ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -1,38 +1,67 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/gorilla/mux"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
 )
 
-type responseWriter struct {
-	buf bytes.Buffer
-}
+// Exit codes of command line mode
+const (
+	exitFailure         = 1 // any failure not listed below
+	exitDashboardFailed = 2 // the dashboard could not be fetched from Grafana, or access was refused
+	exitRenderFailed    = 3 // panel images could not be rendered, any panel with -strict
+	exitLaTeXFailed     = 4 // the tex file could not be created or compiled
+	exitOutputFailed    = 5 // the report could not be written to the output file
+)
 
-func (responseWriter) Header() http.Header {
-	return http.Header{}
-}
+var errOutput = errors.New("writing output failed")
 
-func (responseWriter) WriteHeader(statusCode int) {}
+// stdoutFile is the output file that writes the report to stdout, e.g. to pipe it into other tools
+const stdoutFile = "-"
 
-func (rw *responseWriter) Write(b []byte) (int, error) {
-	return rw.buf.Write(b)
+// logOutput is where the log goes: stdout, unless command line mode writes the report there
+func logOutput() io.Writer {
+	if *cmdMode && *outputFile == stdoutFile {
+		return os.Stderr
+	}
+	return os.Stdout
 }
 
-func cmdHandler(router *mux.Router) error {
-	fp, err := os.Create(*outputFile)
-	if err != nil {
-		return err
+// exitCode maps an error returned by cmdHandler to the exit code of command line mode
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, report.ErrDashboard), errors.Is(err, grafana.ErrDashboardNotFound), errors.Is(err, grafana.ErrUnauthorized):
+		return exitDashboardFailed
+	case errors.Is(err, report.ErrRender):
+		return exitRenderFailed
+	case errors.Is(err, report.ErrLaTeX):
+		return exitLaTeXFailed
+	case errors.Is(err, errOutput):
+		return exitOutputFailed
 	}
-	defer fp.Close()
+	return exitFailure
+}
 
+func cmdHandler(h ServeReportHandler) error {
 	rqStr := "/api/v5/report/%s?apitoken=%s&%s"
-	if *apiVersion == "v4" {
+	switch *apiVersion {
+	case "v4":
 		rqStr = "/api/report/%s?apitoken=%s&%s"
+	case "v9":
+		rqStr = "/api/v9/report/%s?apitoken=%s&%s"
 	}
 
 	if template != nil && *template != "" {
@@ -43,9 +72,108 @@ func cmdHandler(router *mux.Router) error {
 	if err != nil {
 		return err
 	}
-	rw := responseWriter{}
-	router.ServeHTTP(&rw, rq)
+	vars, err := cmdVariables(*cmdVars)
+	if err != nil {
+		return err
+	}
+	query := rq.URL.Query()
+	for name, values := range vars {
+		query[name] = values
+	}
+	rq.URL.RawQuery = query.Encode()
+	s, err := requestSettings(rq)
+	if err != nil {
+		return err
+	}
+	// Several comma separated dashboards are combined into one report
+	var reports []report.Report
+	for _, dash := range strings.Split(*dashboard, ",") {
+		rep, _ := h.requestReport(mux.SetURLVars(rq, map[string]string{"dashId": strings.TrimSpace(dash)}), s)
+		reports = append(reports, rep)
+	}
+	rep := reports[0]
+	if len(reports) > 1 {
+		rep = report.NewMulti(reports, s.opts)
+	}
+	file, err := rep.Generate(context.Background())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	_, err = io.Copy(fp, &rw.buf)
-	return err
+	if *outputFile == stdoutFile {
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			return fmt.Errorf("%w: %w", errOutput, err)
+		}
+		return nil
+	}
+	name, err := outputFileName(*outputFile, rep.Title(), time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %w", errOutput, err)
+	}
+	fp, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errOutput, err)
+	}
+	defer fp.Close()
+
+	_, err = io.Copy(fp, file)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errOutput, err)
+	}
+	return nil
+}
+
+// cmdVariables parses the dashboard variables of -cmd_vars, given as a query string such as
+// "var-server=web01&var-env=prod". The var- prefix may be left out.
+func cmdVariables(s string) (url.Values, error) {
+	parsed, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("-cmd_vars must be a query string such as var-server=web01&var-env=prod: %v", err)
+	}
+	vars := url.Values{}
+	for name, values := range parsed {
+		if !strings.HasPrefix(name, "var-") {
+			name = "var-" + name
+		}
+		vars[name] = append(vars[name], values...)
+	}
+	return vars, nil
+}
+
+// outputFileName expands the Go template of the output file, e.g. "{{.Title}}-{{.Date}}.pdf", with
+// the dashboard title and the date. The values are made safe for file names, so that they cannot
+// add directories. File names without a template are kept as they are.
+func outputFileName(pattern, title string, now time.Time) (string, error) {
+	if !strings.Contains(pattern, "{{") {
+		return pattern, nil
+	}
+	tmpl, err := texttemplate.New("cmd_o").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid output file template: %v", err)
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, struct {
+		Title string // The dashboard title, or the titles of combined dashboards
+		Date  string // e.g. 2006-01-02
+		Time  string // e.g. 150405
+	}{safeFileName(title), now.Format("2006-01-02"), now.Format("150405")})
+	if err != nil {
+		return "", fmt.Errorf("invalid output file template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// safeFileName replaces path separators and control characters of a value in a file name
+func safeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
 }
@@ -0,0 +1,138 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExitCode(t *testing.T) {
+	Convey("When command line mode fails", t, func() {
+		cause := errors.New("cause")
+
+		Convey("Each failure stage should map to its own exit code", func() {
+			So(exitCode(fmt.Errorf("%w: %w", report.ErrDashboard, cause)), ShouldEqual, exitDashboardFailed)
+			So(exitCode(fmt.Errorf("%w: %w", report.ErrRender, cause)), ShouldEqual, exitRenderFailed)
+			So(exitCode(fmt.Errorf("%w: %w", report.ErrLaTeX, cause)), ShouldEqual, exitLaTeXFailed)
+			So(exitCode(fmt.Errorf("%w: %w", errOutput, cause)), ShouldEqual, exitOutputFailed)
+		})
+
+		Convey("Missing dashboards and refused access should exit like dashboard failures", func() {
+			So(exitCode(fmt.Errorf("%w: %w", report.ErrRender, grafana.ErrDashboardNotFound)), ShouldEqual, exitDashboardFailed)
+			So(exitCode(fmt.Errorf("%w: %w", report.ErrRender, grafana.ErrUnauthorized)), ShouldEqual, exitDashboardFailed)
+		})
+
+		Convey("Other errors should exit with 1", func() {
+			So(exitCode(cause), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestOutputFileFormat(t *testing.T) {
+	Convey("When choosing the format of the output file", t, func() {
+		Convey("It should follow the file extension", func() {
+			So(outputFileFormat("panels.zip"), ShouldEqual, "zip")
+			So(outputFileFormat("report.HTML"), ShouldEqual, "html")
+		})
+
+		Convey("Other extensions should give a PDF", func() {
+			So(outputFileFormat("out.pdf"), ShouldEqual, "pdf")
+			So(outputFileFormat("report.docx"), ShouldEqual, "pdf")
+			So(outputFileFormat("report"), ShouldEqual, "pdf")
+		})
+	})
+}
+
+func TestOutputFileName(t *testing.T) {
+	Convey("When naming the output file", t, func() {
+		now := time.Date(2024, 3, 9, 6, 30, 15, 0, time.UTC)
+
+		Convey("A plain file name should be kept", func() {
+			name, err := outputFileName("reports/out.pdf", "Ops", now)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "reports/out.pdf")
+		})
+
+		Convey("A template should get the title, date and time", func() {
+			name, err := outputFileName("reports/{{.Title}}-{{.Date}}T{{.Time}}.pdf", "Ops overview", now)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "reports/Ops overview-2024-03-09T063015.pdf")
+		})
+
+		Convey("The title should not add directories", func() {
+			name, err := outputFileName("{{.Title}}.pdf", "CPU/Memory\\IO", now)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "CPU_Memory_IO.pdf")
+			name, err = outputFileName("{{.Title}}", "..", now)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "_")
+		})
+
+		Convey("Invalid templates should fail", func() {
+			_, err := outputFileName("{{.Title}.pdf", "Ops", now)
+			So(err, ShouldNotBeNil)
+			_, err = outputFileName("{{.Dashboard}}.pdf", "Ops", now)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCmdVariables(t *testing.T) {
+	Convey("When parsing the variables of command line mode", t, func() {
+		Convey("They should be query parameters with the var- prefix", func() {
+			vars, err := cmdVariables("var-server=web01&var-server=web02&env=prod")
+			So(err, ShouldBeNil)
+			So(vars, ShouldResemble, url.Values{"var-server": {"web01", "web02"}, "var-env": {"prod"}})
+		})
+
+		Convey("None should give no variables", func() {
+			vars, err := cmdVariables("")
+			So(err, ShouldBeNil)
+			So(vars, ShouldBeEmpty)
+		})
+
+		Convey("An invalid query string should fail", func() {
+			_, err := cmdVariables("var-server=%zz")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLogOutput(t *testing.T) {
+	Convey("When choosing the output of the log", t, func() {
+		defer func(cmd bool, out string) { *cmdMode, *outputFile = cmd, out }(*cmdMode, *outputFile)
+
+		Convey("It should be stdout by default", func() {
+			*cmdMode, *outputFile = true, "out.pdf"
+			So(logOutput(), ShouldEqual, os.Stdout)
+		})
+
+		Convey("It should be stderr if command line mode writes the report to stdout", func() {
+			*cmdMode, *outputFile = true, stdoutFile
+			So(logOutput(), ShouldEqual, os.Stderr)
+		})
+	})
+}
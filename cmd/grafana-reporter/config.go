@@ -0,0 +1,97 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig sets the flags of fs from a YAML or JSON config file that maps flag names,
+// without the leading dash, to values, e.g. "render-width: 1200". Lists are joined by commas,
+// so "panels: [2, 5]" reads like -panels 2,5, except that repeatable flags such as -header
+// are set once per item. Flags given on the command line keep their values, so the
+// precedence is: command line, config file, then the flag's default.
+func loadConfig(fs *flag.FlagSet, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("error parsing %s: %v", file, err)
+	}
+
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", file, name)
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		for _, value := range configValues(fs.Lookup(name).Value, values[name]) {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid value for %s: %v", file, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues are the command line values a config file value sets the flag of value f to:
+// one per item of a list for repeatable flags, else a single one
+func configValues(f flag.Value, v interface{}) []string {
+	list, ok := v.([]interface{})
+	if _, repeatable := f.(repeatableFlag); !ok || !repeatable {
+		return []string{configValue(v)}
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		values[i] = configValue(item)
+	}
+	return values
+}
+
+// configValue is the command line form of a config file value
+func configValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = configValue(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
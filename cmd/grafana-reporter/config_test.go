@@ -0,0 +1,95 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadConfig(t *testing.T) {
+	Convey("When loading a config file", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		port := fs.String("port", ":8686", "")
+		width := fs.Int("render-width", 1000, "")
+		sslCheck := fs.Bool("ssl-check", true, "")
+		retryDelay := fs.Duration("retry-base-delay", 2*time.Second, "")
+		panels := fs.String("panels", "", "")
+		sizes := panelSizesFlag{}
+		fs.Var(sizes, "panel-size", "")
+		headers := headersFlag{}
+		fs.Var(headers, "header", "")
+
+		write := func(name, content string) string {
+			file := filepath.Join(dir, name)
+			So(ioutil.WriteFile(file, []byte(content), 0644), ShouldBeNil)
+			return file
+		}
+
+		Convey("YAML values should set the flags", func() {
+			file := write("config.yaml", "port: ':9000'\nrender-width: 1200\nssl-check: false\nretry-base-delay: 5s\npanels: [2, 5]\npanel-size: ['12=1600x900', '3=300x150']\n")
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*port, ShouldEqual, ":9000")
+			So(*width, ShouldEqual, 1200)
+			So(*sslCheck, ShouldBeFalse)
+			So(*retryDelay, ShouldEqual, 5*time.Second)
+			So(*panels, ShouldEqual, "2,5")
+			So(sizes, ShouldHaveLength, 2)
+		})
+
+		Convey("Lists should set repeatable flags once per item", func() {
+			file := write("config.yaml", "header: ['X-WEBAUTH-USER: svc', 'X-Org: 1, 2']\n")
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(headers, ShouldHaveLength, 2)
+			So(http.Header(headers).Get("X-Webauth-User"), ShouldEqual, "svc")
+			So(http.Header(headers).Get("X-Org"), ShouldEqual, "1, 2")
+		})
+
+		Convey("JSON values should set the flags", func() {
+			file := write("config.json", `{"port": ":9000", "render-width": 1200}`)
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*port, ShouldEqual, ":9000")
+			So(*width, ShouldEqual, 1200)
+		})
+
+		Convey("Flags on the command line should override the file", func() {
+			So(fs.Parse([]string{"-render-width", "800"}), ShouldBeNil)
+			file := write("config.yaml", "port: ':9000'\nrender-width: 1200\n")
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*port, ShouldEqual, ":9000")
+			So(*width, ShouldEqual, 800)
+		})
+
+		Convey("Unknown settings and invalid values should be rejected", func() {
+			So(loadConfig(fs, write("unknown.yaml", "prot: https://\n")), ShouldNotBeNil)
+			So(loadConfig(fs, write("invalid.yaml", "render-width: wide\n")), ShouldNotBeNil)
+			So(loadConfig(fs, write("broken.yaml", "port: [\n")), ShouldNotBeNil)
+			So(loadConfig(fs, filepath.Join(dir, "missing.yaml")), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// Codes of the JSON error responses, telling clients why a request failed
+const (
+	codeBadRequest        = "bad_request"
+	codeNotFound          = "not_found"
+	codeTooManyReports    = "too_many_reports"
+	codeDashboardNotFound = "dashboard_not_found"
+	codeUnauthorized      = "unauthorized"
+	codeRenderTimeout     = "render_timeout"
+	codeRendererFailed    = "renderer_failed"
+	codeDashboardFailed   = "dashboard_failed"
+	codeRenderFailed      = "render_failed"
+	codeLaTeXFailed       = "latex_failed"
+	codeInternal          = "internal_error"
+)
+
+// reportErrors are the causes of failed reports with the status and code of their response, by
+// precedence: the errors of the Grafana client tell more than the stage that failed. Refused
+// requests are answered with Grafana's own status where it is known.
+var reportErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{grafana.ErrDashboardNotFound, http.StatusNotFound, codeDashboardNotFound},
+	{grafana.ErrUnauthorized, http.StatusForbidden, codeUnauthorized},
+	{grafana.ErrRenderTimeout, http.StatusGatewayTimeout, codeRenderTimeout},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, codeRenderTimeout},
+	{grafana.ErrRenderServer, http.StatusBadGateway, codeRendererFailed},
+	{report.ErrDashboard, http.StatusInternalServerError, codeDashboardFailed},
+	{report.ErrRender, http.StatusInternalServerError, codeRenderFailed},
+	{report.ErrLaTeX, http.StatusInternalServerError, codeLaTeXFailed},
+}
+
+// errorResponse is the JSON body of a failed request
+type errorResponse struct {
+	Error   string `json:"error"`             // What failed
+	Code    string `json:"code"`              // Why, e.g. dashboard_not_found
+	Details string `json:"details,omitempty"` // The full error, for failed reports
+}
+
+// reportError is the HTTP status and JSON body answering a failed report
+func reportError(err error) (status int, resp errorResponse) {
+	for _, e := range reportErrors {
+		if errors.Is(err, e.err) {
+			status = e.status
+			var authErr *grafana.AuthError
+			if errors.As(err, &authErr) {
+				status = authErr.StatusCode
+			}
+			return status, errorResponse{Error: e.err.Error(), Code: e.code, Details: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, errorResponse{Error: "report generation failed", Code: codeInternal, Details: err.Error()}
+}
+
+// writeReportError answers a failed report with its error as JSON
+func writeReportError(w http.ResponseWriter, err error) {
+	status, resp := reportError(err)
+	writeError(w, status, resp)
+}
+
+// requestError answers a request that cannot be served with the message, as JSON if the client
+// accepts it and as plain text otherwise
+func requestError(w http.ResponseWriter, req *http.Request, status int, code, msg string) {
+	if !acceptsJSON(req) {
+		http.Error(w, msg, status)
+		return
+	}
+	writeError(w, status, errorResponse{Error: msg, Code: code})
+}
+
+// acceptsJSON tells whether the Accept header of the request lists application/json
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, resp errorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
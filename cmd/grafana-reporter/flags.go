@@ -0,0 +1,108 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// repeatableFlag is a flag that adds to its value each time it is set, rather than replacing it
+type repeatableFlag interface {
+	flag.Value
+	repeatable()
+}
+
+// panelSizesFlag is a repeatable flag of per-panel render sizes, e.g. -panel-size 12=1600x900
+type panelSizesFlag map[int]grafana.RenderSize
+
+func (f panelSizesFlag) String() string {
+	var sizes []string
+	for id, size := range f {
+		sizes = append(sizes, fmt.Sprintf("%d=%dx%d", id, size.Width, size.Height))
+	}
+	sort.Strings(sizes)
+	return strings.Join(sizes, ",")
+}
+
+func (f panelSizesFlag) repeatable() {}
+
+func (f panelSizesFlag) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected panelId=WIDTHxHEIGHT, got %q", entry)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return fmt.Errorf("invalid panel id in %q", entry)
+		}
+		size, err := parseRenderSize(parts[1])
+		if err != nil {
+			return err
+		}
+		f[id] = size
+	}
+	return nil
+}
+
+// headersFlag is a repeatable flag of headers sent with every request to Grafana, e.g.
+// -header "X-WEBAUTH-USER: svc"
+type headersFlag http.Header
+
+func (f headersFlag) String() string {
+	var headers []string
+	for name, values := range f {
+		for _, v := range values {
+			headers = append(headers, name+": "+v)
+		}
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+func (f headersFlag) repeatable() {}
+
+func (f headersFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") || strings.ContainsAny(v, "\r\n") {
+		return fmt.Errorf("expected \"Name: value\", got %q", value)
+	}
+	http.Header(f).Add(name, strings.TrimSpace(v))
+	return nil
+}
+
+// parseRenderSize parses WIDTHxHEIGHT into a size of positive dimensions
+func parseRenderSize(s string) (grafana.RenderSize, error) {
+	dims := strings.SplitN(strings.TrimSpace(s), "x", 2)
+	if len(dims) != 2 {
+		return grafana.RenderSize{}, fmt.Errorf("expected WIDTHxHEIGHT, got %q", s)
+	}
+	width, errW := strconv.Atoi(dims[0])
+	height, errH := strconv.Atoi(dims[1])
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return grafana.RenderSize{}, fmt.Errorf("render size %q must have positive integer dimensions", s)
+	}
+	return grafana.RenderSize{Width: width, Height: height}, nil
+}
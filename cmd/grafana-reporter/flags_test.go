@@ -0,0 +1,114 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/report"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPanelSizesFlag(t *testing.T) {
+	Convey("When parsing -panel-size flags", t, func() {
+		f := panelSizesFlag{}
+
+		Convey("Comma separated and repeated entries should be collected", func() {
+			So(f.Set("12=1600x900,3=300x150"), ShouldBeNil)
+			So(f.Set("4=1000x100"), ShouldBeNil)
+			So(f, ShouldHaveLength, 3)
+			So(f[12].Width, ShouldEqual, 1600)
+			So(f[12].Height, ShouldEqual, 900)
+			So(f.String(), ShouldEqual, "12=1600x900,3=300x150,4=1000x100")
+		})
+
+		Convey("Sizes that are not positive integers should be rejected", func() {
+			So(f.Set("12=0x900"), ShouldNotBeNil)
+			So(f.Set("12=-5x900"), ShouldNotBeNil)
+			So(f.Set("12=widex900"), ShouldNotBeNil)
+			So(f.Set("12"), ShouldNotBeNil)
+			So(f.Set("a=10x10"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHeadersFlag(t *testing.T) {
+	Convey("When parsing -header flags", t, func() {
+		f := headersFlag{}
+
+		Convey("Repeated headers should be collected", func() {
+			So(f.Set("X-WEBAUTH-USER: svc"), ShouldBeNil)
+			So(f.Set("X-Team:ops"), ShouldBeNil)
+			So(f.Set("X-Team: dev"), ShouldBeNil)
+			So(http.Header(f).Get("X-Webauth-User"), ShouldEqual, "svc")
+			So(http.Header(f).Values("X-Team"), ShouldResemble, []string{"ops", "dev"})
+			So(f.String(), ShouldEqual, "X-Team: dev, X-Team: ops, X-Webauth-User: svc")
+		})
+
+		Convey("Headers without a name or with line breaks should be rejected", func() {
+			So(f.Set("X-WEBAUTH-USER"), ShouldNotBeNil)
+			So(f.Set(": svc"), ShouldNotBeNil)
+			So(f.Set("X WEBAUTH: svc"), ShouldNotBeNil)
+			So(f.Set("X-WEBAUTH-USER: svc\r\nX-Admin: 1"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCheckWritableDir(t *testing.T) {
+	Convey("When checking the -tmp-dir directory", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		Convey("A writable directory should be accepted, without leaving files behind", func() {
+			So(checkWritableDir(dir), ShouldBeNil)
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldBeEmpty)
+		})
+
+		Convey("Missing directories and files should be rejected", func() {
+			So(checkWritableDir(filepath.Join(dir, "missing")), ShouldNotBeNil)
+			file := filepath.Join(dir, "file")
+			So(ioutil.WriteFile(file, nil, 0666), ShouldBeNil)
+			So(checkWritableDir(file), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCheckLaTeXEngine(t *testing.T) {
+	Convey("When checking for the LaTeX engine at startup", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		t.Setenv("PATH", dir)
+
+		Convey("A missing engine should be reported with a hint", func() {
+			err := checkLaTeXEngine(report.EngineXeLaTeX)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "xelatex not found; install texlive or set -latex-engine")
+		})
+
+		Convey("An engine in the PATH should be accepted", func() {
+			So(ioutil.WriteFile(filepath.Join(dir, "pdflatex"), []byte("#!/bin/sh\n"), 0755), ShouldBeNil)
+			So(checkLaTeXEngine(""), ShouldBeNil)
+		})
+	})
+}
@@ -17,10 +17,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -34,15 +35,61 @@ import (
 
 // ServeReportHandler interface facilitates testing the reportServing http handler
 type ServeReportHandler struct {
-	newGrafanaClient func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) grafana.Client
-	newReport        func(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, gridLayout bool) report.Report
+	newGrafanaClient func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, opts grafana.ClientOptions) grafana.Client
+	newReport        func(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, useRowLayout bool, opts report.Options) report.Report
+	cache            *reportCache // nil disables re-rendering
+	// reportSlots is a semaphore of the reports that may be generated at once, shared by the
+	// handlers of all API versions. nil does not limit them.
+	reportSlots chan struct{}
+	// jobs holds the reports generated asynchronously, shared by the handlers of all API
+	// versions. nil disables asynchronous reports.
+	jobs *jobStore
+}
+
+// newReportSlots is the semaphore of at most max concurrent reports, or nil if max is 0
+func newReportSlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// reportRetryAfter is the Retry-After, in seconds, of requests refused at the report limit
+const reportRetryAfter = "30"
+
+// acquireReportSlot takes a slot of the concurrent report limit. If none is free it answers
+// 429 Too Many Requests and returns false; otherwise release must be called when done.
+func (h ServeReportHandler) acquireReportSlot(w http.ResponseWriter, req *http.Request) (release func(), ok bool) {
+	if h.reportSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case h.reportSlots <- struct{}{}:
+		return func() { <-h.reportSlots }, true
+	default:
+		slog.Warn("Refusing report request, the maximum number of reports are being generated", "reports", cap(h.reportSlots))
+		w.Header().Set("Retry-After", reportRetryAfter)
+		requestError(w, req, http.StatusTooManyRequests, codeTooManyReports, "too many reports are being generated, try again later")
+		return nil, false
+	}
 }
 
 // RegisterHandlers registers all http.Handler's with their associated routes to the router
 // Two different serve report handlers are used to provide support for both Grafana v4 (and older) and v5 APIs
-func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5 ServeReportHandler) {
+func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5, reportServerV9 ServeReportHandler) {
 	router.Handle("/api/report/{dashId}", reportServerV4)
 	router.Handle("/api/v5/report/{dashId}", reportServerV5)
+	router.Handle("/api/v5/report", reportServerV5.postedDashboardHandler()).Methods("POST")
+	router.Handle("/api/v9/report/{dashId}", reportServerV9)
+	router.Handle("/api/v9/report", reportServerV9.postedDashboardHandler()).Methods("POST")
+	router.Handle("/api/report/async/{dashId}", reportServerV4.asyncHandler()).Methods("POST")
+	router.Handle("/api/v5/report/async/{dashId}", reportServerV5.asyncHandler()).Methods("POST")
+	router.Handle("/api/v9/report/async/{dashId}", reportServerV9.asyncHandler()).Methods("POST")
+	router.Handle("/api/report/status/{jobId}", reportServerV5.jobStatusHandler()).Methods("GET")
+	router.Handle("/api/report/result/{jobId}", reportServerV5.jobResultHandler()).Methods("GET")
+	router.Handle("/api/rerender/{reportId}", reportServerV4.rerenderHandler())
+	router.Handle("/api/v5/rerender/{reportId}", reportServerV5.rerenderHandler())
+	router.Handle("/api/v9/rerender/{reportId}", reportServerV9.rerenderHandler())
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "This is grafana-reporter. \nThe API endpoints are documented here: https://github.com/IzakMarais/reporter#endpoint.")
 	})
@@ -50,36 +97,212 @@ func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5 ServeRe
 }
 
 func (h ServeReportHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	log.Print("Reporter called")
-	g := h.newGrafanaClient(*proto+*ip, apiToken(req), dashVariables(req), *sslCheck, *gridLayout)
-	rep := h.newReport(g, dashID(req), time(req), texTemplate(req), *gridLayout)
-
-	file, err := rep.Generate()
+	slog.Info("Reporter called")
+	s, err := requestSettings(req)
 	if err != nil {
-		log.Println("Error generating report:", err)
-		http.Error(w, err.Error(), 500)
+		requestError(w, req, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	release, ok := h.acquireReportSlot(w, req)
+	if !ok {
+		return
+	}
+	defer release()
+	rep, cc := h.requestReport(req, s)
+
+	file, ok := generateReport(req.Context(), w, rep)
+	if !ok {
 		return
 	}
-//	defer rep.Clean()
 	defer file.Close()
-	addFilenameHeader(w, rep.Title())
+	if cc != nil && cc.dash != nil {
+		id := h.cache.add(cachedReport{
+			dash:      *cc.dash,
+			dashName:  dashID(req),
+			variables: dashVariables(req),
+			settings:  s,
+		})
+		w.Header().Set("X-Report-Id", id)
+	}
+	writeReport(w, rep.Title(), s.opts.Format, file)
+}
+
+// maxDashboardSize limits the size of dashboard JSON posted to the reporter
+const maxDashboardSize = 10 << 20
+
+// postedDashboardHandler generates a report for dashboard JSON posted in the request body, e.g.
+// an unsaved dashboard, or for a JSON report request naming a saved dashboard. Grafana renders
+// panels from the saved dashboard, so the panels are rendered from the dashboard with the uid
+// given in the query or the JSON, by panel id.
+func (h ServeReportHandler) postedDashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slog.Info("Reporter called with posted dashboard")
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxDashboardSize))
+		if err != nil {
+			requestError(w, req, http.StatusBadRequest, codeBadRequest, "error reading dashboard JSON: "+err.Error())
+			return
+		}
+		if rr, ok, err := parseReportRequest(body); ok {
+			if err != nil {
+				requestError(w, req, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
+			slog.Debug("Called with a JSON report request")
+			h.ServeHTTP(w, rr.apply(req))
+			return
+		}
+		dash, err := grafana.ParseDashboard(body)
+		if err != nil {
+			requestError(w, req, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if uid := req.URL.Query().Get("uid"); uid != "" {
+			dash.Uid = uid
+		}
+		if dash.Uid == "" {
+			requestError(w, req, http.StatusBadRequest, codeBadRequest, "a dashboard uid is required to render panels: set it in the JSON or the uid query parameter")
+			return
+		}
+		slog.Debug("Called with posted dashboard", "title", dash.Title, "uid", dash.Uid)
+		s, err := requestSettings(req)
+		if err != nil {
+			requestError(w, req, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		s.opts.ImageCacheTTL = 0 // Posted dashboards carry no requested variables to key cached images by
+		release, ok := h.acquireReportSlot(w, req)
+		if !ok {
+			return
+		}
+		defer release()
+
+		g := h.newGrafanaClient(grafanaURL(), apiToken(req), dashVariables(req), *sslCheck, s.layout.gridSizing(), dashboardClientOptions(dash))
+		g = &cachingClient{Client: g, dash: &dash}
+		rep := h.newReport(g, dash.Uid, timeRange(req), s.template, s.layout == layoutRow, s.opts)
+
+		file, ok := generateReport(req.Context(), w, rep)
+		if !ok {
+			return
+		}
+		defer file.Close()
+		writeReport(w, rep.Title(), s.opts.Format, file)
+	})
+}
+
+// dashboardClientOptions are the client settings for a dashboard that is not fetched by the
+// client, so settings that depend on the dashboard are resolved here
+func dashboardClientOptions(dash grafana.Dashboard) grafana.ClientOptions {
+	opts := clientOptions()
+	if opts.Timezone == grafana.DashboardTimezone {
+		opts.Timezone = dash.RenderTimezone()
+	}
+	return opts
+}
 
-	_, err = io.Copy(w, file)
+// requestReport creates the report described by the request and its settings. The returned
+// cachingClient records the fetched dashboard for re-rendering, and is nil when re-rendering
+// is disabled.
+func (h ServeReportHandler) requestReport(req *http.Request, s reportSettings) (report.Report, *cachingClient) {
+	var cc *cachingClient
+	g := h.newGrafanaClient(grafanaURL(), apiToken(req), dashVariables(req), *sslCheck, s.layout.gridSizing(), clientOptions())
+	if h.cache != nil {
+		cc = &cachingClient{Client: g}
+		g = cc
+	}
+	return h.newReport(g, dashID(req), timeRange(req), s.template, s.layout == layoutRow, s.opts), cc
+}
+
+// reportSettings are how a request asks for its report to be made
+type reportSettings struct {
+	opts     report.Options
+	template string // Path of the custom template, or "" for the default template
+	layout   reportLayout
+}
+
+// requestSettings reads the report settings of the request, failing on invalid ones
+func requestSettings(req *http.Request) (reportSettings, error) {
+	opts, err := requestOptions(req)
+	if err != nil {
+		return reportSettings{}, err
+	}
+	tmpl, err := texTemplate(req)
+	if err != nil {
+		return reportSettings{}, err
+	}
+	layout, err := requestLayout(req)
 	if err != nil {
-		log.Println("Error copying data to response:", err)
+		return reportSettings{}, err
+	}
+	if layout == layoutDashboard {
+		if opts.Format.Extension() != string(report.FormatPDF) {
+			return reportSettings{}, fmt.Errorf("dashboard layout only supports pdf reports, got format %s", opts.Format)
+		}
+		opts.DashboardImage = true
+	}
+	return reportSettings{opts: opts, template: tmpl, layout: layout}, nil
+}
+
+// requestOptions are the report settings given on the command line, with those the request overrides
+func requestOptions(req *http.Request) (report.Options, error) {
+	opts := reportOptions()
+	params := req.URL.Query()
+	if _, ok := params["panels"]; ok {
+		ids, err := report.ParsePanelIDs(params.Get("panels"))
+		if err != nil {
+			return opts, fmt.Errorf("panels: %w", err)
+		}
+		opts.Panels.Include = ids
+	}
+	if _, ok := params["exclude-panels"]; ok {
+		ids, err := report.ParsePanelIDs(params.Get("exclude-panels"))
+		if err != nil {
+			return opts, fmt.Errorf("exclude-panels: %w", err)
+		}
+		opts.Panels.Exclude = ids
+	}
+	if _, ok := params["panel-tags"]; ok {
+		opts.Panels.Tags = report.ParseTags(params.Get("panel-tags"))
+	}
+	if format := report.OutputFormat(params.Get("format")); format != "" {
+		if !format.Valid() {
+			return opts, fmt.Errorf("format must be pdf, html or zip, got %q", format)
+		}
+		opts.Format = format
+	}
+	return opts, nil
+}
+
+// generateReport writes an error response and returns false if the report could not be generated
+func generateReport(ctx context.Context, w http.ResponseWriter, rep report.Report) (io.ReadCloser, bool) {
+	file, err := rep.Generate(ctx)
+	if err != nil {
+		slog.Error("Error generating report", "error", err)
+		writeReportError(w, err)
+		return nil, false
+	}
+	return file, true
+}
+
+func writeReport(w http.ResponseWriter, title string, format report.OutputFormat, file io.Reader) {
+	addFilenameHeader(w, title, format.Extension())
+	w.Header().Set("Content-Type", format.ContentType())
+
+	_, err := io.Copy(w, file)
+	if err != nil {
+		slog.Error("Error copying data to response", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	log.Println("Report generated correctly")
+	slog.Info("Report generated correctly")
 }
 
-func addFilenameHeader(w http.ResponseWriter, title string) {
+func addFilenameHeader(w http.ResponseWriter, title string, ext string) {
 	//sanitize title. Http headers should be ASCII
 	filename := strconv.QuoteToASCII(title)
 	filename = strings.TrimLeft(filename, "\"")
 	filename = strings.TrimRight(filename, "\"")
-	filename += ".pdf"
-	log.Println("Extracted filename from dashboard title: ", filename)
+	filename += "." + ext
+	slog.Debug("Extracted filename from dashboard title", "filename", filename)
 	header := fmt.Sprintf("inline; filename=\"%s\"", filename)
 	w.Header().Add("Content-Disposition", header)
 }
@@ -87,20 +310,26 @@ func addFilenameHeader(w http.ResponseWriter, title string) {
 func dashID(r *http.Request) string {
 	vars := mux.Vars(r)
 	d := vars["dashId"]
-	log.Println("Called with dashboard:", d)
+	slog.Debug("Called with dashboard", "dashboard", d)
 	return d
 }
 
-func time(r *http.Request) grafana.TimeRange {
+func timeRange(r *http.Request) grafana.TimeRange {
 	params := r.URL.Query()
 	t := grafana.NewTimeRange(params.Get("from"), params.Get("to"))
-	log.Println("Called with time range:", t)
+	slog.Debug("Called with time range", "timeRange", t)
 	return t
 }
 
 func apiToken(r *http.Request) string {
+	if *forwardAuth {
+		if token, ok := bearerToken(r); ok {
+			slog.Debug("Forwarding the bearer token of the request to Grafana")
+			return token
+		}
+	}
 	apiToken := r.URL.Query().Get("apitoken")
-	log.Println("Called with api Token:", apiToken)
+	slog.Debug("Called with api token", "apiToken", apiToken)
 	return apiToken
 }
 
@@ -108,31 +337,37 @@ func dashVariables(r *http.Request) url.Values {
 	output := url.Values{}
 	for k, v := range r.URL.Query() {
 		if strings.HasPrefix(k, "var-") {
-			log.Println("Called with variable:", k, v)
+			slog.Debug("Called with variable", "name", k, "values", v)
 			for _, singleV := range v {
 				output.Add(k, singleV)
 			}
 		}
 	}
 	if len(output) == 0 {
-		log.Println("Called without variable")
+		slog.Debug("Called without variable")
 	}
 	return output
 }
 
-func texTemplate(r *http.Request) string {
-	fName := r.URL.Query().Get("template")
-	if fName == "" {
-		return ""
+// texTemplate is the path of the custom template named by the template query parameter, or
+// "" to use the default template. The name must be that of a .tex file in the -templates
+// directory, so requests cannot read files elsewhere on the server.
+func texTemplate(r *http.Request) (string, error) {
+	name := r.URL.Query().Get("template")
+	if name == "" {
+		return "", nil
 	}
-	file := filepath.Join(*templateDir, fName+".tex")
-	log.Println("Called with template:", file)
-
-	customTemplate, err := ioutil.ReadFile(file)
+	entries, err := ioutil.ReadDir(*templateDir)
 	if err != nil {
-		log.Printf("Error reading template file: %q", err)
-		return ""
+		slog.Error("Error reading template directory", "error", err)
+		return "", fmt.Errorf("unknown template %q", name)
 	}
-
-	return string(customTemplate)
+	for _, e := range entries {
+		if e.Mode().IsRegular() && e.Name() == name+".tex" {
+			file := filepath.Join(*templateDir, e.Name())
+			slog.Debug("Called with template", "template", file)
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("unknown template %q: no %s.tex in the templates directory", name, name)
 }
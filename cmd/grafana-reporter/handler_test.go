@@ -18,12 +18,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/IzakMarais/reporter/report"
@@ -34,7 +39,7 @@ import (
 type mockReport struct {
 }
 
-func (m mockReport) Generate() (pdf io.ReadCloser, err error) {
+func (m mockReport) Generate(ctx context.Context) (pdf io.ReadCloser, err error) {
 	return ioutil.NopCloser(bytes.NewReader(nil)), nil
 }
 
@@ -47,20 +52,20 @@ func TestV4ServeReportHandler(t *testing.T) {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
 		var clVars url.Values
-		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) grafana.Client {
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
 			clAPIToken = apiToken
 			clVars = variables
-			return grafana.NewV4Client(url, apiToken, variables, true, false)
+			return grafana.NewV4Client(url, apiToken, variables, true, false, grafana.ClientOptions{})
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
-		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ bool) report.Report {
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
 			repDashName = dashName
 			return &mockReport{}
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport}, ServeReportHandler{nil, nil})
+		RegisterHandlers(router, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{}, ServeReportHandler{})
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -98,20 +103,22 @@ func TestV5ServeReportHandler(t *testing.T) {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
 		var clVars url.Values
-		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) grafana.Client {
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
 			clAPIToken = apiToken
 			clVars = variables
-			return grafana.NewV4Client(url, apiToken, variables, true, false)
+			return grafana.NewV4Client(url, apiToken, variables, true, false, grafana.ClientOptions{})
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
-		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ bool) report.Report {
+		var repOpts report.Options
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ bool, opts report.Options) report.Report {
 			repDashName = dashName
+			repOpts = opts
 			return &mockReport{}
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{nil, nil}, ServeReportHandler{newGrafanaClient, newReport})
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{})
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -126,6 +133,22 @@ func TestV5ServeReportHandler(t *testing.T) {
 			So(clAPIToken, ShouldEqual, "1234")
 		})
 
+		Convey("With -forward-auth it should forward the bearer token of the request instead", func() {
+			*forwardAuth = true
+			defer func() { *forwardAuth = false }()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
+			req.Header.Set("Authorization", "Bearer user-token")
+			router.ServeHTTP(rec, req)
+			So(clAPIToken, ShouldEqual, "user-token")
+		})
+
+		Convey("Without -forward-auth it should ignore the Authorization header", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
+			req.Header.Set("Authorization", "Bearer user-token")
+			router.ServeHTTP(rec, req)
+			So(clAPIToken, ShouldEqual, "1234")
+		})
+
 		Convey("It should extract the grafana variables and forward them to the new Grafana Client ", func() {
 			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?var-test=testValue", nil)
 			router.ServeHTTP(rec, req)
@@ -141,5 +164,506 @@ func TestV5ServeReportHandler(t *testing.T) {
 				So(clVars, ShouldResemble, expected)
 			})
 		})
+
+		Convey("It should forward the panel filter of the query to the new reporter", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?panels=2,5,8&exclude-panels=5&panel-tags=exec-summary", nil)
+			router.ServeHTTP(rec, req)
+			So(repOpts.Panels, ShouldResemble, report.PanelFilter{Include: []int{2, 5, 8}, Exclude: []int{5}, Tags: []string{"exec-summary"}})
+		})
+
+		Convey("It should reject invalid panel ids", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?panels=2,five", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("It should serve the output format of the query", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?format=html", nil)
+			router.ServeHTTP(rec, req)
+			So(repOpts.Format, ShouldEqual, report.FormatHTML)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+			So(rec.Header().Get("Content-Disposition"), ShouldEndWith, `.html"`)
+		})
+
+		Convey("It should reject unknown output formats", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?format=docx", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+type mockClient struct {
+	grafana.Client
+	getDashboardCallCount int
+	err                   error // Returned by GetDashboard, if set
+}
+
+func (m *mockClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	m.getDashboardCallCount++
+	if m.err != nil {
+		return grafana.Dashboard{}, m.err
+	}
+	return grafana.Dashboard{Title: dashName}, nil
+}
+
+// mockFetchingReport fetches its dashboard like the real report does
+type mockFetchingReport struct {
+	mockReport
+	g        grafana.Client
+	dashName string
+}
+
+func (m mockFetchingReport) Generate(ctx context.Context) (pdf io.ReadCloser, err error) {
+	if _, err := m.g.GetDashboard(ctx, m.dashName); err != nil {
+		return nil, err
+	}
+	return m.mockReport.Generate(ctx)
+}
+
+func TestRerenderHandler(t *testing.T) {
+	Convey("When a report is re-rendered for a new time range", t, func() {
+		cl := &mockClient{}
+		var clAPIToken string
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			clAPIToken = apiToken
+			return cl
+		}
+		var repDashName string
+		var repTime grafana.TimeRange
+		newReport := func(g grafana.Client, dashName string, tr grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
+			repDashName = dashName
+			repTime = tr
+			return mockFetchingReport{g: g, dashName: dashName}
+		}
+
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport, cache: newReportCache(time.Minute)}, ServeReportHandler{})
+
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
+		router.ServeHTTP(rec, req)
+		id := rec.Header().Get("X-Report-Id")
+
+		Convey("The original response should carry a report id", func() {
+			So(id, ShouldNotBeEmpty)
+		})
+
+		Convey("It should reuse the cached dashboard with the new time range and the caller's token", func() {
+			clAPIToken = ""
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/rerender/"+id+"?from=now-7d&to=now-1d&apitoken=5678", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(cl.getDashboardCallCount, ShouldEqual, 1)
+			So(repDashName, ShouldEqual, "testDash")
+			So(repTime, ShouldResemble, grafana.TimeRange{From: "now-7d", To: "now-1d"})
+			So(clAPIToken, ShouldEqual, "5678")
+		})
+
+		Convey("It should refuse callers without an api token", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/rerender/"+id+"?from=now-7d&to=now-1d", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(cl.getDashboardCallCount, ShouldEqual, 1)
+		})
+
+		Convey("With forwarded auth", func() {
+			*forwardAuth = true
+			defer func() { *forwardAuth = false }()
+			rerender := func() *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v5/rerender/"+id+"?from=now-7d&to=now-1d", nil)
+				req.Header.Set("Authorization", "Bearer 5678")
+				router.ServeHTTP(rec, req)
+				return rec
+			}
+
+			Convey("It should check that the caller may still read the dashboard", func() {
+				So(rerender().Code, ShouldEqual, http.StatusOK)
+				So(cl.getDashboardCallCount, ShouldEqual, 2)
+				So(clAPIToken, ShouldEqual, "5678")
+			})
+
+			Convey("It should not serve the cached dashboard to callers that may not read it", func() {
+				cl.err = grafana.ErrUnauthorized
+				So(rerender().Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("It should return 404 for an unknown report id", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/rerender/unknown", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestPostedDashboardHandler(t *testing.T) {
+	Convey("When a dashboard JSON is posted to the v5 report endpoint", t, func() {
+		cl := &mockClient{}
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			return cl
+		}
+		var repDashName string
+		var repClient grafana.Client
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
+			repDashName = dashName
+			repClient = g
+			return &mockReport{}
+		}
+
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{})
+		post := func(target, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", target, bytes.NewBufferString(body))
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("It should render the posted dashboard by its uid without fetching it", func() {
+			rec := post("/api/v5/report", `{"dashboard":{"title":"Unsaved","uid":"abc123","panels":[{"type":"graph","id":1}]}}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repDashName, ShouldEqual, "abc123")
+			dash, err := repClient.GetDashboard(context.Background(), repDashName)
+			So(err, ShouldBeNil)
+			So(dash.Title, ShouldEqual, "Unsaved")
+			So(dash.GetGridPanels(), ShouldHaveLength, 1)
+			So(cl.getDashboardCallCount, ShouldEqual, 0)
+		})
+
+		Convey("The uid query parameter should override the uid in the JSON", func() {
+			post("/api/v5/report?uid=override", `{"title":"Unsaved","uid":"abc123"}`)
+			So(repDashName, ShouldEqual, "override")
+		})
+
+		Convey("It should reject dashboards without a uid", func() {
+			rec := post("/api/v5/report", `{"title":"Unsaved"}`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("It should reject invalid JSON", func() {
+			rec := post("/api/v5/report", `{"title":`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+// blockingReport is a report whose generation waits until release is closed
+type blockingReport struct {
+	mockReport
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b blockingReport) Generate(ctx context.Context) (io.ReadCloser, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.mockReport.Generate(ctx)
+}
+
+func TestConcurrentReportLimit(t *testing.T) {
+	Convey("When the number of concurrent reports is limited", t, func() {
+		rep := blockingReport{started: make(chan struct{}, 2), release: make(chan struct{})}
+		newReport := func(g grafana.Client, _ string, _ grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
+			return rep
+		}
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			return grafana.NewV5Client(url, apiToken, variables, true, false, grafana.ClientOptions{})
+		}
+		slots := newReportSlots(1)
+		router := mux.NewRouter()
+		RegisterHandlers(router,
+			ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport, reportSlots: slots},
+			ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport, reportSlots: slots},
+			ServeReportHandler{})
+		get := func(target string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", target, nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		first := make(chan *httptest.ResponseRecorder, 1)
+		go func() { first <- get("/api/v5/report/testDash") }()
+		<-rep.started
+
+		Convey("Requests beyond the limit should be refused with 429 and a Retry-After, across API versions", func() {
+			rec := get("/api/report/testDash")
+			So(rec.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(rec.Header().Get("Retry-After"), ShouldEqual, reportRetryAfter)
+			close(rep.release)
+			So((<-first).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("A slot should be free again once a report is done", func() {
+			close(rep.release)
+			So((<-first).Code, ShouldEqual, http.StatusOK)
+			So(get("/api/v5/report/testDash").Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+// failingReport fails to generate with err
+type failingReport struct {
+	mockReport
+	err error
+}
+
+func (f failingReport) Generate(ctx context.Context) (io.ReadCloser, error) {
+	return nil, f.err
+}
+
+func TestReportErrorStatus(t *testing.T) {
+	Convey("When generating a report fails", t, func() {
+		var genErr error
+		newReport := func(g grafana.Client, _ string, _ grafana.TimeRange, _ string, _ bool, _ report.Options) report.Report {
+			return failingReport{err: genErr}
+		}
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			return grafana.NewV5Client(url, apiToken, variables, true, false, grafana.ClientOptions{})
+		}
+		router := mux.NewRouter()
+		h := ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}
+		RegisterHandlers(router, h, h, h)
+		get := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("A missing dashboard should answer 404 with the error as JSON", func() {
+			genErr = fmt.Errorf("%w: error getting dashboard: %w", report.ErrDashboard, grafana.ErrDashboardNotFound)
+			rec := get()
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var body errorResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body, ShouldResemble, errorResponse{Error: "dashboard not found", Code: codeDashboardNotFound, Details: genErr.Error()})
+		})
+
+		Convey("Other failures should carry the failed stage as code", func() {
+			genErr = fmt.Errorf("%w: pdflatex exited with 1", report.ErrLaTeX)
+			rec := get()
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Body.String(), ShouldContainSubstring, `"code":"latex_failed"`)
+		})
+
+		Convey("Each error of the Grafana client should map to its status", func() {
+			for err, status := range map[error]int{
+				grafana.ErrUnauthorized:   http.StatusForbidden,
+				grafana.ErrRenderTimeout:  http.StatusGatewayTimeout,
+				context.DeadlineExceeded:  http.StatusGatewayTimeout,
+				grafana.ErrRenderServer:   http.StatusBadGateway,
+				errors.New("other error"): http.StatusInternalServerError,
+			} {
+				genErr = fmt.Errorf("%w: %w", report.ErrRender, err)
+				So(get().Code, ShouldEqual, status)
+			}
+		})
+
+		Convey("Refused requests should be answered with Grafana's status", func() {
+			for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+				genErr = fmt.Errorf("%w: %w", report.ErrDashboard, &grafana.AuthError{StatusCode: status, Err: errors.New("refused")})
+				rec := get()
+				So(rec.Code, ShouldEqual, status)
+				So(rec.Body.String(), ShouldContainSubstring, `"code":"unauthorized"`)
+			}
+		})
+	})
+}
+
+func TestRequestErrors(t *testing.T) {
+	Convey("When a report request is invalid", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{}, ServeReportHandler{})
+		get := func(accept string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?layout=diagonal", nil)
+			req.Header.Set("Accept", accept)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("It should answer with plain text by default", func() {
+			rec := get("")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+		})
+
+		Convey("It should answer with JSON if the client accepts it", func() {
+			rec := get("text/html, application/json;q=0.9")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var body errorResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Code, ShouldEqual, codeBadRequest)
+			So(body.Error, ShouldContainSubstring, "layout")
+		})
+	})
+}
+
+func TestJSONReportRequest(t *testing.T) {
+	Convey("When a JSON report request is posted to the v5 report endpoint", t, func() {
+		var clAPIToken string
+		var clVars url.Values
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			clAPIToken = apiToken
+			clVars = variables
+			return &mockClient{}
+		}
+		var repDashName string
+		var repTime grafana.TimeRange
+		var repOpts report.Options
+		newReport := func(g grafana.Client, dashName string, t grafana.TimeRange, _ string, _ bool, opts report.Options) report.Report {
+			repDashName = dashName
+			repTime = t
+			repOpts = opts
+			return &mockReport{}
+		}
+
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{})
+		post := func(target, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", target, bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("Its settings should configure the report like query parameters", func() {
+			rec := post("/api/v5/report", `{
+				"dashboard": "abc123",
+				"from": "now-7d", "to": "now-1d",
+				"apitoken": "1234",
+				"variables": {"host": ["web 1", "web&2"], "env": "prod"},
+				"format": "html",
+				"panels": [2, 5], "excludePanels": [5], "panelTags": ["exec-summary"]
+			}`)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repDashName, ShouldEqual, "abc123")
+			So(repTime.From, ShouldEqual, "now-7d")
+			So(repTime.To, ShouldEqual, "now-1d")
+			So(clAPIToken, ShouldEqual, "1234")
+			So(clVars["var-host"], ShouldResemble, []string{"web 1", "web&2"})
+			So(clVars["var-env"], ShouldResemble, []string{"prod"})
+			So(repOpts.Format, ShouldEqual, report.FormatHTML)
+			So(repOpts.Panels.Include, ShouldResemble, []int{2, 5})
+			So(repOpts.Panels.Exclude, ShouldResemble, []int{5})
+			So(repOpts.Panels.Tags, ShouldResemble, []string{"exec-summary"})
+		})
+
+		Convey("Invalid requests should be rejected", func() {
+			So(post("/api/v5/report", `{"dashboard": ""}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(post("/api/v5/report", `{"dashboard": "abc123", "form": "now-7d"}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(post("/api/v5/report", `{"dashboard": "abc123", "variables": {"host": 1}}`).Code, ShouldEqual, http.StatusBadRequest)
+			So(post("/api/v5/report", `{"dashboard": "abc123", "format": "docx"}`).Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestTemplateParameter(t *testing.T) {
+	Convey("When a report request names a template", t, func() {
+		dir := t.TempDir()
+		So(ioutil.WriteFile(dir+"/exec-summary.tex", []byte("custom"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(dir+"/../secret.tex", []byte("secret"), 0644), ShouldBeNil)
+		defer func(d string) { *templateDir = d }(*templateDir)
+		*templateDir = dir
+
+		var repTemplate string
+		newReport := func(g grafana.Client, dashName string, t grafana.TimeRange, texTemplate string, _ bool, opts report.Options) report.Report {
+			repTemplate = texTemplate
+			return &mockReport{}
+		}
+		newGrafanaClient := func(string, string, url.Values, bool, bool, grafana.ClientOptions) grafana.Client {
+			return &mockClient{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{})
+		get := func(template string) int {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?template="+url.QueryEscape(template), nil)
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Convey("A template in the templates directory should be passed to the report by path", func() {
+			So(get("exec-summary"), ShouldEqual, http.StatusOK)
+			So(repTemplate, ShouldEqual, dir+"/exec-summary.tex")
+		})
+
+		Convey("Omitting it should use the default template", func() {
+			So(get(""), ShouldEqual, http.StatusOK)
+			So(repTemplate, ShouldEqual, "")
+		})
+
+		Convey("Unknown templates and paths outside the templates directory should be rejected", func() {
+			So(get("missing"), ShouldEqual, http.StatusBadRequest)
+			So(get("../secret"), ShouldEqual, http.StatusBadRequest)
+			So(get("/etc/passwd"), ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestLayoutParameter(t *testing.T) {
+	Convey("When a report request selects a layout", t, func() {
+		defer func(row, grid bool) { *rowLayout, *gridLayout = row, grid }(*rowLayout, *gridLayout)
+		*rowLayout, *gridLayout = false, true
+
+		var clGridLayout, repRowLayout, repDashboardImage bool
+		newGrafanaClient := func(_ string, _ string, _ url.Values, _ bool, gridLayout bool, _ grafana.ClientOptions) grafana.Client {
+			clGridLayout = gridLayout
+			return &mockClient{}
+		}
+		newReport := func(g grafana.Client, dashName string, t grafana.TimeRange, _ string, useRowLayout bool, opts report.Options) report.Report {
+			repRowLayout = useRowLayout
+			repDashboardImage = opts.DashboardImage
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient: newGrafanaClient, newReport: newReport}, ServeReportHandler{})
+		get := func(query string) int {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash"+query, nil)
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Convey("Without the parameter the layout of the flags should be used", func() {
+			So(get(""), ShouldEqual, http.StatusOK)
+			So(clGridLayout, ShouldBeTrue)
+			So(repRowLayout, ShouldBeFalse)
+		})
+
+		Convey("Row layout should be passed to the report", func() {
+			So(get("?layout=row"), ShouldEqual, http.StatusOK)
+			So(repRowLayout, ShouldBeTrue)
+		})
+
+		Convey("Sequential layout should override the grid layout flag", func() {
+			So(get("?layout=sequential"), ShouldEqual, http.StatusOK)
+			So(clGridLayout, ShouldBeFalse)
+			So(repRowLayout, ShouldBeFalse)
+		})
+
+		Convey("Dashboard layout should render the dashboard as one image", func() {
+			So(get("?layout=dashboard"), ShouldEqual, http.StatusOK)
+			So(repDashboardImage, ShouldBeTrue)
+			So(repRowLayout, ShouldBeFalse)
+			So(clGridLayout, ShouldBeFalse)
+		})
+
+		Convey("Dashboard layout should be rejected for other formats than pdf", func() {
+			So(get("?layout=dashboard&format=html"), ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Other layouts should be rejected", func() {
+			So(get("?layout=masonry"), ShouldEqual, http.StatusBadRequest)
+		})
 	})
 }
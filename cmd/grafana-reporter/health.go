@@ -0,0 +1,48 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// readyTimeout bounds the check of Grafana by /readyz, so probes get an answer in time
+const readyTimeout = 5 * time.Second
+
+// RegisterHealthHandlers registers the liveness and readiness probes. /healthz answers as long
+// as the reporter serves requests; /readyz also checks that ping reaches Grafana.
+func RegisterHealthHandlers(router *mux.Router, ping func(ctx context.Context) error) {
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := ping(ctx); err != nil {
+			slog.Warn("Not ready", "error", err)
+			http.Error(w, "grafana is unreachable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}).Methods("GET", "HEAD")
+}
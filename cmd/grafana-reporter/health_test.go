@@ -0,0 +1,64 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	Convey("When probing the reporter", t, func() {
+		var pingErr error
+		pinged := false
+		router := mux.NewRouter()
+		RegisterHealthHandlers(router, func(ctx context.Context) error {
+			pinged = true
+			return pingErr
+		})
+		probe := func(path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("/healthz should answer without contacting Grafana", func() {
+			pingErr = errors.New("connection refused")
+			So(probe("/healthz").Code, ShouldEqual, http.StatusOK)
+			So(pinged, ShouldBeFalse)
+		})
+
+		Convey("/readyz should answer 200 when Grafana is reachable", func() {
+			So(probe("/readyz").Code, ShouldEqual, http.StatusOK)
+			So(pinged, ShouldBeTrue)
+		})
+
+		Convey("/readyz should answer 503 when Grafana is unreachable", func() {
+			pingErr = errors.New("connection refused")
+			rec := probe("/readyz")
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rec.Body.String(), ShouldContainSubstring, "connection refused")
+		})
+	})
+}
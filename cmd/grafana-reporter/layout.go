@@ -0,0 +1,69 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// reportLayout is how the panels of a dashboard are arranged in the report
+type reportLayout string
+
+const (
+	layoutSequential reportLayout = "sequential" // One panel after the other, at the render size
+	layoutGrid       reportLayout = "grid"       // Panels sized and placed by their grid position
+	layoutRow        reportLayout = "row"        // Whole dashboard rows, in landscape
+	layoutDashboard  reportLayout = "dashboard"  // The whole dashboard as one image
+)
+
+// defaultLayout is the layout selected by the -dashboard-layout, -row-layout and -grid-layout flags
+func defaultLayout() reportLayout {
+	switch {
+	case *dashboardLayout:
+		return layoutDashboard
+	case *rowLayout:
+		return layoutRow
+	case *gridLayout:
+		return layoutGrid
+	}
+	return layoutSequential
+}
+
+// gridSizing is whether panels are rendered at the size of their grid position. Row layout
+// keeps doing so when -grid-layout is set as well.
+func (l reportLayout) gridSizing() bool {
+	switch l {
+	case layoutGrid:
+		return true
+	case layoutRow:
+		return *gridLayout
+	}
+	return false
+}
+
+// requestLayout is the layout given by the layout query parameter, or the default layout
+func requestLayout(req *http.Request) (reportLayout, error) {
+	switch l := reportLayout(req.URL.Query().Get("layout")); l {
+	case "":
+		return defaultLayout(), nil
+	case layoutSequential, layoutGrid, layoutRow, layoutDashboard:
+		return l, nil
+	default:
+		return "", fmt.Errorf("layout must be grid, row, sequential or dashboard, got %q", l)
+	}
+}
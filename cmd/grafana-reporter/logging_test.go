@@ -0,0 +1,66 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogHandler(t *testing.T) {
+	Convey("When creating the log handler", t, func() {
+		var buf bytes.Buffer
+
+		Convey("Messages below the level should be left out", func() {
+			h, err := newLogHandler(&buf, "info", "text")
+			So(err, ShouldBeNil)
+			logger := slog.New(h)
+			logger.Debug("Downloading panel image", "panel", 3)
+			logger.Info("Created PDF file", "path", "report.pdf")
+			So(buf.String(), ShouldNotContainSubstring, "Downloading")
+			So(buf.String(), ShouldContainSubstring, `level=INFO msg="Created PDF file" path=report.pdf`)
+		})
+
+		Convey("The debug level should show everything", func() {
+			h, err := newLogHandler(&buf, "DEBUG", "text")
+			So(err, ShouldBeNil)
+			slog.New(h).Debug("Downloading panel image", "panel", 3)
+			So(buf.String(), ShouldContainSubstring, "panel=3")
+		})
+
+		Convey("The json format should log one object per line", func() {
+			h, err := newLogHandler(&buf, "warn", "json")
+			So(err, ShouldBeNil)
+			slog.New(h).Warn("Failed to download panel image", "panel", 3)
+			var entry map[string]interface{}
+			So(json.Unmarshal(buf.Bytes(), &entry), ShouldBeNil)
+			So(entry["level"], ShouldEqual, "WARN")
+			So(entry["panel"], ShouldEqual, 3)
+		})
+
+		Convey("Unknown levels and formats should be refused", func() {
+			_, err := newLogHandler(&buf, "verbose", "text")
+			So(err, ShouldNotBeNil)
+			_, err = newLogHandler(&buf, "info", "xml")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
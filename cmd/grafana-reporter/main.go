@@ -17,91 +17,605 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/IzakMarais/reporter/report"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var configFile = flag.String("config", "", "YAML or JSON file of settings, mapping flag names to values, e.g. 'render-width: 1200'. Flags on the command line override its values.")
 var proto = flag.String("proto", "http://", "Grafana Protocol. Change to 'https://' if Grafana is using https. Reporter will still serve http.")
 var ip = flag.String("ip", "localhost:3000", "Grafana IP and port.")
+var userAgent = flag.String("user-agent", "grafana-reporter/"+version, "User-Agent of the requests to Grafana, e.g. for a firewall in front of it that only lets known agents through.")
+var rootURL = flag.String("root-url", "", "Grafana URL including its sub-path, e.g. https://host/grafana/ if Grafana is served below the root of its host. Replaces -proto and -ip.")
+var publicURL = flag.String("public-url", "", "Grafana URL that report readers can reach, e.g. https://grafana.example.com, used for links in reports. Defaults to the -root-url, or -proto and -ip, URL used for API calls.")
+var basicAuth = flag.String("basic-auth", "", "Basic auth credentials as user:pass, sent with every request to Grafana, e.g. for a reverse proxy in front of it. They are sent in the -basic-auth-header, alongside the api token.")
+var basicAuthHeader = flag.String("basic-auth-header", "Proxy-Authorization", "Header the -basic-auth credentials are sent in. Api tokens are sent as bearer tokens in the Authorization header.")
+var orgID = flag.Int("org-id", 0, "Grafana organisation id to send as X-Grafana-Org-Id with every request, for multi-org instances. 0 omits the header.")
+var clientCert = flag.String("client-cert", "", "PEM encoded client certificate for Grafana, or a gateway in front of it, that requires mutual TLS. Requires -client-key.")
+var clientKey = flag.String("client-key", "", "PEM encoded key of the -client-cert certificate.")
+var caCert = flag.String("ca-cert", "", "PEM encoded CA certificates to validate Grafana's certificate against, instead of the system's. Validation then happens even with -ssl-check=false.")
+var proxy = flag.String("proxy", "", "Proxy URL for requests to Grafana, e.g. http://proxy.example.com:3128. Defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.")
 var port = flag.String("port", ":8686", "Port to serve on.")
+var reporterAPIKey = flag.String("reporter-api-key", "", "Key that requests to the reporter's API must carry in the X-Reporter-Key header or as a bearer token. Empty serves requests without a key.")
+var forwardAuth = flag.Bool("forward-auth", false, "Forward the bearer token of the Authorization header of report requests to Grafana, so that reports are generated with the caller's permissions. It takes the place of the apitoken query parameter; -reporter-api-key must then be given in the X-Reporter-Key header.")
+var maxConcurrentReports = flag.Int("max-concurrent-reports", 0, "How many reports may be generated at once. Further requests are refused with 429 Too Many Requests. 0 does not limit them.")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 5*time.Minute, "How long in-flight reports may take to finish on SIGINT or SIGTERM. Reports still running after it are cancelled.")
 var templateDir = flag.String("templates", "templates/", "Directory for custom TeX templates.")
 var sslCheck = flag.Bool("ssl-check", true, "Check the SSL issuer and validity. Set this to false if your Grafana serves https using an unverified, self-signed certificate.")
 var gridLayout = flag.Bool("grid-layout", false, "Enable grid layout (-grid-layout=1). Panel width and height will be calculated based off Grafana gridPos width and height.")
 var rowLayout = flag.Bool("row-layout", false, "Enable row-based layout (-row-layout=1). Report will capture entire dashboard rows instead of individual panels.")
+var dashboardLayout = flag.Bool("dashboard-layout", false, "Render the entire dashboard as one image instead of its individual panels (-dashboard-layout=1). Only PDF reports support it.")
+var trimImages = flag.Bool("trim-images", false, "Crop the uniform border around rendered panel images (-trim-images=1), so they stack in the report without uneven gaps. Costs some CPU per panel.")
+var rowIntro = flag.Bool("row-intro", true, "Include the explanatory paragraph at the start of row-based reports. Set to false (-row-intro=0) for reports without it.")
+var imageFormat = flag.String("image-format", "png", "Format of the panel images embedded in html reports: png, webp or avif. webp needs cwebp and avif needs avifenc installed. Images stay png without them or where the format gives no smaller image.")
+var toc = flag.Bool("toc", false, "Add a table of contents, linking to the rows, to row-based reports (-toc=1).")
+var placeholderImages = flag.Bool("placeholder-images", true, "Show a placeholder with the error in place of panels that fail to render. Set to false (-placeholder-images=0) to leave them out instead.")
+var strict = flag.Bool("strict", false, "Fail the whole report if any panel fails to render (-strict=1), instead of generating it without the panel.")
+var variablesAppendix = flag.Bool("variables-appendix", false, "Add a page listing the dashboard variables with their full selected values (-variables-appendix=1).")
+var statAsText = flag.Bool("stat-as-text", false, "Show singlestat, stat and gauge panels as their current value in large text (-stat-as-text=1) instead of a rendered image.")
+var format = flag.String("format", "pdf", "Output format of reports: pdf, html for a single web page with the panel images inlined, or zip for an archive of the panel images. html and zip do not need LaTeX. The format query parameter overrides this. In command line mode, defaults to the extension of -cmd_o.")
+var tmpDir = flag.String("tmp-dir", "", "Base directory for the temporary files of reports, which get a reporter/<uuid> directory in it. Defaults to the system's temporary directory.")
+var cacheTTL = flag.Duration("cache-ttl", 0, "How long rendered panel images are cached on disk, e.g. 5m, for reports of the same dashboard, time range and variables to reuse. 0 disables the cache.")
+var keepTemp = flag.Bool("keep-temp", false, "Keep the temporary directory of each report, with its tex file, images and LaTeX log, for debugging (-keep-temp=1). Its path is logged.")
+var latexEngine = flag.String("latex-engine", "pdflatex", "LaTeX engine that compiles PDF reports: pdflatex, xelatex or lualatex. Use xelatex or lualatex for titles with CJK characters or emoji.")
+var latexRecoveries = flag.Int("latex-recoveries", 0, "How many panel images may be replaced by a placeholder when they make LaTeX fail, recompiling after each. 0 fails the report on the first LaTeX error.")
+var latexPasses = flag.Int("latex-passes", 2, "How many times LaTeX compiles PDF reports. 1 suffices for reports without a table of contents. Further passes, up to 5, follow while LaTeX asks for a rerun to get cross-references right.")
+var renderTimeout = flag.Duration("render-timeout", 0, "How long Grafana's image renderer may take per panel, e.g. 2m. Passed to the renderer as its timeout parameter, rounded up to whole seconds. 0 keeps the renderer's default, other values must be at least 1s.")
+var renderWidth = flag.Int("render-width", 1000, "Width in pixels that panels are rendered at.")
+var renderHeight = flag.Int("render-height", 500, "Height in pixels that panels are rendered at.")
+var renderScale = flag.Float64("render-scale", 1, "Device scale factor of rendered panels, from 1 to 4. Use 2 for crisp images in printed reports.")
+var retryBaseDelay = flag.Duration("retry-base-delay", 2*time.Second, "Delay before the first retry of a failed panel render. It doubles on every further retry, plus random jitter.")
+var retryMaxDelay = flag.Duration("retry-max-delay", 30*time.Second, "Longest delay between retries of a failed panel render.")
+var maxRenders = flag.Int("max-renders", 5, "How many panels may be rendered at once. Lower it if Grafana's image renderer is overwhelmed by large dashboards. 0 renders all panels at once.")
+var renderRetries = flag.Int("render-retries", 3, "How often a failed panel render is retried. 0 fails on the first error.")
+var renderRequestTimeout = flag.Duration("render-request-timeout", 180*time.Second, "How long to wait for each panel render request. Raised if needed to outlast -render-timeout.")
+var panelSizes = panelSizesFlag{}
+var headers = headersFlag{}
+var timeDensity = flag.Float64("time-density", 0, "Pixels per minute of the time range. When set, the render width of panels with a time axis follows from the time range, so reports over different ranges are equally readable. 0 disables this.")
+var gridUnitSize = flag.Int("grid-unit-size", 40, "With grid layout, the pixel size of one Grafana grid unit. Panels are rendered at their grid width and height times this size.")
+var timezone = flag.String("tz", "UTC", "IANA timezone that panels are rendered in, e.g. Europe/Berlin. Set to '"+grafana.DashboardTimezone+"' to use each dashboard's own timezone.")
+var accentColor = flag.String("accent-color", "#000000", "Color of the title, section headers and rules of the built-in templates, as #RRGGBB.")
+var themeDir = flag.String("theme-dir", "", "Theme pack directory, e.g. themes/acme. Its files (logo, fonts, ...) are copied next to each report's tex file and its template.tex, if present, replaces the built-in template.")
+var paperSize = flag.String("paper-size", "letter", "Paper of PDF reports: letter or a4. Row layout reports are printed in landscape.")
+var margin = flag.String("margin", "", "Page margin of PDF reports as a TeX length, e.g. 2cm or 0.75in. Defaults to 1in for the grid layout and 0.5in for the row layout.")
+var captionSource = flag.String("caption-source", "title", "Text under each panel image: title, description, both (title in bold with the description beneath) or none.")
+var annotationTimeline = flag.Bool("annotation-timeline", false, "Add a figure marking the dashboard's annotations, such as deploys and incidents, on the report's time range.")
+var panelIDs = flag.String("panels", "", "Comma separated ids of the panels to include in reports, e.g. 2,5,8. Empty includes all panels. The panels query parameter overrides this.")
+var excludePanelIDs = flag.String("exclude-panels", "", "Comma separated ids of panels to leave out of reports, applied after -panels. The exclude-panels query parameter overrides this.")
+var panelTags = flag.String("panel-tags", "", "Comma separated panel tags, e.g. exec-summary. When set, reports only include panels carrying any of them. The panel-tags query parameter overrides this.")
+var pdfAuthor = flag.String("pdf-author", "Grafana Reporter", "Author in the metadata of PDF reports.")
+var footerText = flag.String("footer-text", "Generated by Grafana Reporter", "Text in the center of the page footer of reports, e.g. your company name.")
+var coverPage = flag.Bool("cover-page", false, "Open PDF reports with a cover page of the dashboard title, the time range and the generation date (-cover-page=1).")
+var qrCode = flag.Bool("qr-code", false, "Add a QR code of the link to the dashboard to PDF reports (-qr-code=1), on the cover page with -cover-page.")
+var coverLogo = flag.String("cover-logo", "", "Logo shown above the title on the cover page. Empty shows no logo.")
+var headerImage = flag.String("header-image", "", "Image shown in the page header of row layout reports, e.g. a company banner. Empty shows no header.")
+var watermark = flag.String("watermark", "", "Text printed diagonally across every page of reports, e.g. CONFIDENTIAL. Empty prints none.")
+var legendFile = flag.String("legend", "", "File explaining what colors mean, one '#RRGGBB: meaning' per line. When set, reports get a legend page.")
+var jobTTL = flag.Duration("job-ttl", time.Hour, "How long the result of an asynchronous report is kept after it is generated, if it is not fetched. Set to 0 to disable asynchronous reports.")
+var rerenderTTL = flag.Duration("rerender-ttl", 15*time.Minute, "How long a generated report can be re-rendered for a new time range without fetching its dashboard again. Set to 0 to disable.")
+
+var logLevel = flag.String("log-level", "info", "Level of the log: debug, info, warn or error. Debug also logs every panel that is downloaded.")
+var logFormat = flag.String("log-format", "text", "Format of the log: text, or json for one JSON object per line.")
+
+var validateTemplate = flag.String("validate-template", "", "Validate a custom TeX template file against sample report data and exit, without contacting Grafana or running LaTeX.")
 
 //cmd line mode params
 var cmdMode = flag.Bool("cmd_enable", false, "Enable command line mode. Generate report from command line without starting webserver (-cmd_enable=1).")
-var dashboard = flag.String("cmd_dashboard", "", "Dashboard identifier. Required (and only used) in command line mode.")
+var dashboard = flag.String("cmd_dashboard", "", "Dashboard identifier: uid, slug or folder/title. Several comma separated dashboards, e.g. uid1,uid2,uid3, are combined into one PDF. Required (and only used) in command line mode.")
 var apiKey = flag.String("cmd_apiKey", "", "Grafana api key. Required (and only used) in command line mode.")
-var apiVersion = flag.String("cmd_apiVersion", "v5", "Api version: [v4, v5]. Required (and only used) in command line mode, example: -apiVersion v5.")
-var outputFile = flag.String("cmd_o", "out.pdf", "Output file. Required (and only used) in command line mode.")
+var apiVersion = flag.String("cmd_apiVersion", "v5", "Api version: [v4, v5, v9]. Required (and only used) in command line mode, example: -apiVersion v5.")
+var outputFile = flag.String("cmd_o", "out.pdf", "Output file, or - for stdout. Required (and only used) in command line mode. May be a Go template of the dashboard {{.Title}} and the {{.Date}} or {{.Time}} of the report, e.g. \"{{.Title}}-{{.Date}}.pdf\".")
 var timeSpan = flag.String("cmd_ts", "from=now-3h&to=now", "Time span. Required (and only used) in command line mode.")
+var cmdVars = flag.String("cmd_vars", "", "Dashboard variables as a query string, e.g. \"var-server=web01&var-env=prod\". Repeat a variable for several values. Only used in command line mode; the dashboard's saved values are used for variables not given.")
 var template = flag.String("cmd_template", "", "Specify a custom TeX template file. Only used in command line mode, but is optional even there.")
 
+// clientOptions collects the Grafana client settings given on the command line
+func clientOptions() grafana.ClientOptions {
+	retries := *renderRetries
+	if retries == 0 {
+		retries = -1 // the client treats 0 as its default
+	}
+	user, password, _ := strings.Cut(*basicAuth, ":")
+	return grafana.ClientOptions{
+		RenderTimeout:     *renderTimeout,
+		RenderWidth:       *renderWidth,
+		RenderHeight:      *renderHeight,
+		PanelSizes:        panelSizes,
+		GridUnitSize:      *gridUnitSize,
+		TimeDensity:       *timeDensity,
+		Timezone:          *timezone,
+		RenderScale:       *renderScale,
+		RetryBaseDelay:    *retryBaseDelay,
+		RetryMaxDelay:     *retryMaxDelay,
+		MaxRetries:        retries,
+		RequestTimeout:    *renderRequestTimeout,
+		BasicAuthUser:     user,
+		BasicAuthPassword: password,
+		BasicAuthHeader:   *basicAuthHeader,
+		OrgID:             *orgID,
+		Headers:           http.Header(headers),
+		UserAgent:         *userAgent,
+		ClientCertificate: clientCertificate,
+		RootCAs:           rootCAs,
+		Proxy:             proxyURL,
+	}
+}
+
+// grafanaURL is the Grafana URL for API calls and renders
+func grafanaURL() string {
+	if *rootURL != "" {
+		return *rootURL
+	}
+	return *proto + *ip
+}
+
+// linkURL is the Grafana URL for links in reports
+func linkURL() string {
+	if *publicURL != "" {
+		return *publicURL
+	}
+	return grafanaURL()
+}
+
+// reportOptions collects the report settings given on the command line
+func reportOptions() report.Options {
+	return report.Options{
+		HideRowIntro:        !*rowIntro,
+		ImageFormat:         report.ImageFormat(*imageFormat),
+		TableOfContents:     *toc,
+		MaxLaTeXRecoveries:  *latexRecoveries,
+		LaTeXPasses:         *latexPasses,
+		LaTeXEngine:         report.LaTeXEngine(*latexEngine),
+		PaperSize:           report.PaperSize(*paperSize),
+		Margin:              *margin,
+		AccentColor:         *accentColor,
+		Watermark:           *watermark,
+		HeaderImage:         headerImagePath,
+		Timezone:            *timezone,
+		CoverPage:           *coverPage,
+		CoverLogo:           coverLogoPath,
+		QRCode:              *qrCode,
+		FooterText:          *footerText,
+		Author:              *pdfAuthor,
+		Legend:              legend,
+		AssetDir:            *themeDir,
+		CaptionSource:       report.CaptionSource(*captionSource),
+		AnnotationTimeline:  *annotationTimeline,
+		PublicURL:           linkURL(),
+		Panels:              panelFilter,
+		NoPlaceholderImages: !*placeholderImages,
+		Strict:              *strict,
+		StatAsText:          *statAsText,
+		TrimImages:          *trimImages,
+		VariablesAppendix:   *variablesAppendix,
+		Format:              report.OutputFormat(*format),
+		MaxRenders:          *maxRenders,
+		TempDir:             *tmpDir,
+		KeepTemp:            *keepTemp,
+		ImageCacheTTL:       *cacheTTL,
+	}
+}
+
+func init() {
+	flag.Var(headers, "header", "Header sent with every request to Grafana as \"Name: value\", e.g. for an auth proxy in front of it: -header \"X-WEBAUTH-USER: svc\". Repeat the flag for several headers.")
+	flag.Var(panelSizes, "panel-size", "Render size of a single panel as panelId=WIDTHxHEIGHT, overriding -render-width and -render-height. Repeat the flag or separate entries by commas.")
+}
+
 func main() {
 	flag.Parse()
-	log.SetOutput(os.Stdout)
+	log.SetOutput(logOutput())
+	if *configFile != "" {
+		if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+			log.Fatalf("-config: %v", err)
+		}
+	}
+	if err := setupLogging(logOutput()); err != nil {
+		log.Fatalln(err)
+	}
+	if *renderWidth <= 0 || *renderHeight <= 0 {
+		log.Fatalf("-render-width and -render-height must be positive, got %dx%d", *renderWidth, *renderHeight)
+	}
+	if *renderScale < 1 || *renderScale > 4 {
+		log.Fatalf("-render-scale must be between 1 and 4, got %v", *renderScale)
+	}
+	if *renderTimeout != 0 && *renderTimeout < time.Second {
+		log.Fatalf("-render-timeout must be 0 or at least 1s, got %v", *renderTimeout)
+	}
+	if *cacheTTL < 0 {
+		log.Fatalf("-cache-ttl must not be negative, got %v", *cacheTTL)
+	}
+	if *maxConcurrentReports < 0 {
+		log.Fatalf("-max-concurrent-reports must not be negative, got %d", *maxConcurrentReports)
+	}
+	if *shutdownTimeout < 0 {
+		log.Fatalf("-shutdown-timeout must not be negative, got %v", *shutdownTimeout)
+	}
+	if *maxRenders < 0 {
+		log.Fatalf("-max-renders must not be negative, got %d", *maxRenders)
+	}
+	if *renderRetries < 0 {
+		log.Fatalf("-render-retries must not be negative, got %d", *renderRetries)
+	}
+	if *latexPasses <= 0 {
+		log.Fatalf("-latex-passes must be positive, got %d", *latexPasses)
+	}
+	if *gridUnitSize <= 0 {
+		log.Fatalf("-grid-unit-size must be positive, got %d", *gridUnitSize)
+	}
+	if *timeDensity < 0 {
+		log.Fatalf("-time-density must not be negative, got %v", *timeDensity)
+	}
+	if _, err := report.LaTeXColor(*accentColor); err != nil {
+		log.Fatalf("-accent-color: %v", err)
+	}
+	if !report.PaperSize(*paperSize).Valid() {
+		log.Fatalf("-paper-size must be letter or a4, got %q", *paperSize)
+	}
+	if !report.ValidMargin(*margin) {
+		log.Fatalf("-margin must be a length such as 2cm or 0.75in, got %q", *margin)
+	}
+	if !report.CaptionSource(*captionSource).Valid() {
+		log.Fatalf("-caption-source must be title, description, both or none, got %q", *captionSource)
+	}
+	if _, err := outputFileName(*outputFile, "", time.Now()); *cmdMode && err != nil {
+		log.Fatalf("-cmd_o: %v", err)
+	}
+	if _, err := cmdVariables(*cmdVars); *cmdMode && err != nil {
+		log.Fatalln(err)
+	}
+	if *cmdMode && !flagSet("format") {
+		*format = outputFileFormat(*outputFile)
+	}
+	if !report.LaTeXEngine(*latexEngine).Valid() {
+		log.Fatalf("-latex-engine must be pdflatex, xelatex or lualatex, got %q", *latexEngine)
+	}
+	if !report.OutputFormat(*format).Valid() {
+		log.Fatalf("-format must be pdf, html or zip, got %q", *format)
+	}
+	if *basicAuth != "" && !strings.Contains(*basicAuth, ":") {
+		log.Fatalln("-basic-auth must be given as user:pass")
+	}
+	if *clientCert != "" || *clientKey != "" {
+		if *clientCert == "" || *clientKey == "" {
+			log.Fatalln("-client-cert and -client-key must be given together")
+		}
+		cert, err := grafana.LoadClientCertificate(*clientCert, *clientKey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		clientCertificate = cert
+	}
+	if *caCert != "" {
+		pool, err := grafana.LoadCACertificates(*caCert)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		rootCAs = pool
+	}
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("-proxy must be an absolute URL such as http://proxy.example.com:3128, got %q", *proxy)
+		}
+		proxyURL = u
+	}
+	if *rootURL != "" {
+		if u, err := url.Parse(*rootURL); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("-root-url must be an absolute URL such as https://host/grafana/, got %q", *rootURL)
+		}
+	}
+	if *publicURL != "" {
+		if u, err := url.Parse(*publicURL); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Fatalf("-public-url must be an absolute URL such as https://grafana.example.com, got %q", *publicURL)
+		}
+	}
+	if *tmpDir != "" {
+		if err := checkWritableDir(*tmpDir); err != nil {
+			log.Fatalf("-tmp-dir %s is not usable for temporary files: %v", *tmpDir, err)
+		}
+	}
+	if *themeDir != "" {
+		themeTemplate = readThemeDir(*themeDir)
+	}
+	if *legendFile != "" {
+		legend = readLegend(*legendFile)
+	}
+	if *headerImage != "" {
+		headerImagePath = readImage("header image", *headerImage)
+	}
+	if *coverLogo != "" {
+		coverLogoPath = readImage("cover logo", *coverLogo)
+	}
+	var err error
+	if panelFilter.Include, err = report.ParsePanelIDs(*panelIDs); err != nil {
+		log.Fatalf("-panels: %v", err)
+	}
+	if panelFilter.Exclude, err = report.ParsePanelIDs(*excludePanelIDs); err != nil {
+		log.Fatalf("-exclude-panels: %v", err)
+	}
+	panelFilter.Tags = report.ParseTags(*panelTags)
+	if *timezone != grafana.DashboardTimezone {
+		if _, err := time.LoadLocation(*timezone); err != nil {
+			log.Fatalf("-tz must be an IANA timezone such as Europe/Berlin or '%s': %v", grafana.DashboardTimezone, err)
+		}
+	}
+	if *configFile != "" {
+		slog.Info("Using config file. Settings are taken from the command line, then the config file, then the defaults.", "file", *configFile)
+	}
+
+	if *validateTemplate != "" {
+		validateTemplateFile(*validateTemplate)
+		return
+	}
+
+	if err := checkLaTeXEngine(report.LaTeXEngine(*latexEngine)); err != nil {
+		if *cmdMode && *format == string(report.FormatPDF) {
+			log.Fatalln(err)
+		} else if !*cmdMode {
+			slog.Warn("PDF reports will fail until the LaTeX engine is installed", "error", err)
+		}
+	}
+
+	if !report.ImageFormat(*imageFormat).Valid() {
+		log.Fatalf("-image-format must be png, webp or avif, got %q", *imageFormat)
+	}
+	if encoder := report.ImageFormat(*imageFormat).Encoder(); encoder != "" {
+		if _, err := exec.LookPath(encoder); err != nil {
+			slog.Warn("HTML reports will embed PNG images until the image encoder is installed", "encoder", encoder, "error", err)
+		}
+	}
 
 	//'generated*'' variables injected from build.gradle: task 'injectGoVersion()'
-	log.Printf("grafana reporter, version: %s.%s-%s hash: %s", generatedMajor, generatedMinor, generatedRelease, generatedGitHash)
-	log.Printf("serving at '%s' and using grafana at '%s'", *port, *proto+*ip)
+	slog.Info("grafana reporter", "version", version, "hash", generatedGitHash)
+	slog.Info("Serving", "address", *port, "grafana", grafanaURL())
 	if !*sslCheck {
-		log.Printf("SSL check disabled")
+		slog.Info("SSL check disabled")
 	} else {
-		log.Printf("SSL check enforced")
+		slog.Info("SSL check enforced")
 	}
 	
 	// Check layout flags and provide appropriate logs
-	if *rowLayout {
-		log.Printf("Using row-based layout. Will capture entire rows in landscape orientation.")
+	if *dashboardLayout {
+		slog.Info("Using dashboard layout. Will capture the entire dashboard as one image.")
+	} else if *rowLayout {
+		slog.Info("Using row-based layout. Will capture entire rows in landscape orientation.")
 	} else if *gridLayout {
-		log.Printf("Using grid layout. Panel dimensions will be based on their grid positions.")
+		slog.Info("Using grid layout. Panel dimensions will be based on their grid positions.")
 	} else {
-		log.Printf("Using sequential report layout. Consider enabling 'grid-layout' or 'row-layout' so that your report more closely follows the dashboard layout.")
+		slog.Info("Using sequential report layout. Consider enabling 'grid-layout' or 'row-layout' so that your report more closely follows the dashboard layout.")
 	}
 	
 	router := mux.NewRouter()
-	// Create custom serve report handlers that pass the layout flags
+	reportSlots := newReportSlots(*maxConcurrentReports)
+	jobs := newJobStore(*jobTTL)
+	// Create custom serve report handlers that fall back to the theme template
 	v4Handler := ServeReportHandler{
 		newGrafanaClient: grafana.NewV4Client,
-		newReport: func(g grafana.Client, dashName string, t grafana.TimeRange, texTemplate string, gridLayout bool) report.Report {
-			return report.New(g, dashName, t, texTemplate, *rowLayout)
+		newReport: func(g grafana.Client, dashName string, t grafana.TimeRange, texTemplate string, useRowLayout bool, opts report.Options) report.Report {
+			if texTemplate == "" {
+				texTemplate = themeTemplate
+			}
+			return report.New(g, dashName, t, texTemplate, useRowLayout, opts)
 		},
+		cache:       newReportCache(*rerenderTTL),
+		reportSlots: reportSlots,
+		jobs:        jobs,
 	}
 	
 	v5Handler := ServeReportHandler{
 		newGrafanaClient: grafana.NewV5Client,
-		newReport: func(g grafana.Client, dashName string, t grafana.TimeRange, texTemplate string, gridLayout bool) report.Report {
-			return report.New(g, dashName, t, texTemplate, *rowLayout)
+		newReport: func(g grafana.Client, dashName string, t grafana.TimeRange, texTemplate string, useRowLayout bool, opts report.Options) report.Report {
+			if texTemplate == "" {
+				texTemplate = themeTemplate
+			}
+			return report.New(g, dashName, t, texTemplate, useRowLayout, opts)
 		},
+		cache:       newReportCache(*rerenderTTL),
+		reportSlots: reportSlots,
+		jobs:        jobs,
 	}
 	
-	RegisterHandlers(router, v4Handler, v5Handler)
+	v9Handler := ServeReportHandler{
+		newGrafanaClient: grafana.NewV9Client,
+		newReport:        v5Handler.newReport,
+		cache:            newReportCache(*rerenderTTL),
+		reportSlots:      reportSlots,
+		jobs:             jobs,
+	}
+
+	// Probes and metrics are registered first, so they are served without the reporter API key
+	RegisterHealthHandlers(router, func(ctx context.Context) error {
+		return grafana.Ping(ctx, grafanaURL(), *sslCheck, clientOptions())
+	})
+	router.Handle("/metrics", promhttp.Handler())
+	api := router.NewRoute().Subrouter()
+	if *reporterAPIKey != "" {
+		api.Use(requireAPIKey(*reporterAPIKey, !*forwardAuth))
+	}
+	RegisterHandlers(api, v4Handler, v5Handler, v9Handler)
 
 	if *cmdMode {
-		log.Printf("Called with command line mode enabled, will save report to file and exit.")
-		log.Printf("Called with command line mode 'dashboard' '%s'", *dashboard)
-		log.Printf("Called with command line mode 'apiKey' '%s'", *apiKey)
-		log.Printf("Called with command line mode 'apiVersion' '%s'", *apiVersion)
-		log.Printf("Called with command line mode 'outputFile' '%s'", *outputFile)
-		log.Printf("Called with command line mode 'timeSpan' '%s'", *timeSpan)
+		slog.Info("Called with command line mode enabled, will save report to file and exit")
+		slog.Debug("Called with command line mode", "dashboard", *dashboard)
+		slog.Debug("Called with command line mode", "apiKey", *apiKey)
+		slog.Debug("Called with command line mode", "apiVersion", *apiVersion)
+		slog.Debug("Called with command line mode", "outputFile", *outputFile)
+		slog.Debug("Called with command line mode", "timeSpan", *timeSpan)
+		slog.Debug("Called with command line mode", "vars", *cmdVars)
 		if template != nil && *template != "" {
-			log.Printf("Called with command line mode 'template' '%s'", *template)
+			slog.Debug("Called with command line mode", "template", *template)
 		}
 		if *rowLayout {
-			log.Printf("Using row-based layout in command line mode")
+			slog.Debug("Using row-based layout in command line mode")
 		}
 
-		if err := cmdHandler(router); err != nil {
-			log.Fatalln(err)
+		cmdReportHandler := v5Handler
+		switch *apiVersion {
+		case "v4":
+			cmdReportHandler = v4Handler
+		case "v9":
+			cmdReportHandler = v9Handler
+		}
+		if err := cmdHandler(cmdReportHandler); err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
 		}
 	} else {
-		log.Fatal(http.ListenAndServe(*port, router))
+		ln, err := net.Listen("tcp", *port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		if err := serve(&http.Server{Handler: router}, ln, stop, *shutdownTimeout); err != nil {
+			log.Fatal(err)
+		}
+		if jobs != nil {
+			jobs.close(*shutdownTimeout)
+		}
+	}
+}
+
+// checkLaTeXEngine checks that the executable of the LaTeX engine can be found
+func checkLaTeXEngine(engine report.LaTeXEngine) error {
+	if _, err := exec.LookPath(engine.Command()); err != nil {
+		return fmt.Errorf("%s not found; install texlive or set -latex-engine", engine.Command())
+	}
+	return nil
+}
+
+// flagSet is true if the flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// outputFileFormat is the output format matching the extension of the file, or pdf if none does
+func outputFileFormat(file string) string {
+	ext := report.OutputFormat(strings.ToLower(strings.TrimPrefix(filepath.Ext(file), ".")))
+	if ext == "" || !ext.Valid() {
+		return string(report.FormatPDF)
+	}
+	return string(ext)
+}
+
+// clientCertificate is loaded from -client-cert and -client-key at startup
+var clientCertificate *tls.Certificate
+
+// rootCAs are loaded from -ca-cert at startup
+var rootCAs *x509.CertPool
+
+// proxyURL is parsed from -proxy at startup
+var proxyURL *url.URL
+
+// panelFilter is parsed from -panels, -exclude-panels and -panel-tags at startup
+var panelFilter report.PanelFilter
+
+// themeTemplate is the template of the -theme-dir theme pack, if it has one
+var themeTemplate string
+
+// checkWritableDir checks that files can be created in the directory
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	f, err := ioutil.TempFile(dir, ".reporter-write-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// headerImagePath and coverLogoPath are the absolute paths of -header-image and -cover-logo,
+// read at startup
+var headerImagePath, coverLogoPath string
+
+// readImage checks an image given on the command line and returns its absolute path, as LaTeX
+// runs in the report's temporary directory
+func readImage(what, file string) string {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", what, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		log.Fatalf("Error reading %s: %v", what, err)
+	}
+	return path
+}
+
+// readThemeDir checks the theme pack directory and returns the path of its template, or ""
+// if it has none
+func readThemeDir(dir string) string {
+	info, err := os.Stat(dir)
+	if err != nil {
+		log.Fatalln("Error reading theme directory:", err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("Theme %s is not a directory", dir)
+	}
+	tmpl := filepath.Join(dir, "template.tex")
+	if _, err := os.Stat(tmpl); err != nil {
+		slog.Info("Theme has no template.tex, using the built-in templates with its assets", "theme", dir)
+		return ""
+	}
+	slog.Info("Using theme", "theme", dir)
+	return tmpl
+}
+
+// legend is read from -legend at startup
+var legend []report.LegendEntry
+
+func readLegend(file string) []report.LegendEntry {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalln("Error reading legend file:", err)
+	}
+	defer f.Close()
+	entries, err := report.ParseLegend(f)
+	if err != nil {
+		log.Fatalf("Legend %s is invalid: %v", file, err)
+	}
+	return entries
+}
+
+// validateTemplateFile reports whether the template file is valid and exits non-zero if not
+func validateTemplateFile(file string) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalln("Error reading template file:", err)
+	}
+	if err := report.ValidateTemplate(string(content)); err != nil {
+		log.Fatalf("Template %s is invalid: %v", file, err)
 	}
+	slog.Info("Template is valid", "template", file)
 }
@@ -0,0 +1,124 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// reportRequest configures a report in a JSON request body, as an alternative to the query
+// parameters of a GET request. Each field has the meaning of the query parameter of the
+// same name.
+type reportRequest struct {
+	Dashboard     string                    `json:"dashboard"`
+	From          string                    `json:"from"`
+	To            string                    `json:"to"`
+	APIToken      string                    `json:"apitoken"`
+	Variables     map[string]variableValues `json:"variables"` // By name, without the var- prefix
+	Template      string                    `json:"template"`
+	Layout        string                    `json:"layout"`
+	Format        string                    `json:"format"`
+	Panels        []int                     `json:"panels"`
+	ExcludePanels []int                     `json:"excludePanels"`
+	PanelTags     []string                  `json:"panelTags"`
+}
+
+// variableValues are the values of a variable, given as a single string or a list of strings
+type variableValues []string
+
+func (v *variableValues) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*v = variableValues{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("variable values must be a string or a list of strings")
+	}
+	*v = list
+	return nil
+}
+
+// parseReportRequest parses a JSON report request. Posted dashboard JSON, whose dashboard
+// field is not a string, is not a report request: ok is then false.
+func parseReportRequest(body []byte) (rr reportRequest, ok bool, err error) {
+	var probe struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+	}
+	if json.Unmarshal(body, &probe) != nil || !bytes.HasPrefix(bytes.TrimSpace(probe.Dashboard), []byte(`"`)) {
+		return rr, false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rr); err != nil {
+		return rr, true, fmt.Errorf("invalid report request: %v", err)
+	}
+	if rr.Dashboard == "" {
+		return rr, true, errors.New("invalid report request: dashboard is required")
+	}
+	return rr, true, nil
+}
+
+// apply returns a copy of req with the settings of the report request as its query parameters
+// and dashboard, so it can be served like a GET request. They override the query of req.
+func (rr reportRequest) apply(req *http.Request) *http.Request {
+	params := req.URL.Query()
+	set := func(key, value string) {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	set("from", rr.From)
+	set("to", rr.To)
+	set("apitoken", rr.APIToken)
+	set("template", rr.Template)
+	set("layout", rr.Layout)
+	set("format", rr.Format)
+	if rr.Panels != nil {
+		params.Set("panels", joinInts(rr.Panels))
+	}
+	if rr.ExcludePanels != nil {
+		params.Set("exclude-panels", joinInts(rr.ExcludePanels))
+	}
+	if rr.PanelTags != nil {
+		params.Set("panel-tags", strings.Join(rr.PanelTags, ","))
+	}
+	for name, values := range rr.Variables {
+		params["var-"+name] = values
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.RawQuery = params.Encode()
+	return mux.SetURLVars(r, map[string]string{"dashId": rr.Dashboard})
+}
+
+func joinInts(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
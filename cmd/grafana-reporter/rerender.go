@@ -0,0 +1,153 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+// reportCache remembers the dashboard and request parameters of recently generated reports,
+// so that a report can be re-rendered for a new time range without fetching the dashboard again.
+// It does not keep api tokens: re-render requests bring their own.
+type reportCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedReport
+}
+
+type cachedReport struct {
+	dash      grafana.Dashboard
+	dashName  string
+	variables url.Values
+	settings  reportSettings
+	expires   time.Time
+}
+
+func newReportCache(ttl time.Duration) *reportCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &reportCache{ttl: ttl, entries: map[string]cachedReport{}}
+}
+
+// add stores the entry and returns the id under which it can be re-rendered
+func (c *reportCache) add(entry cachedReport) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+
+	id := uuid.New()
+	entry.expires = now.Add(c.ttl)
+	c.entries[id] = entry
+	return id
+}
+
+func (c *reportCache) get(id string) (cachedReport, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, id)
+		return cachedReport{}, false
+	}
+	return entry, true
+}
+
+// cachingClient serves GetDashboard from dash when it is set. Otherwise the dashboard is
+// fetched from the wrapped client and remembered. The variables of a served dashboard are
+// given to the wrapped client for its render requests. All other calls go to the wrapped client.
+type cachingClient struct {
+	grafana.Client
+	dash *grafana.Dashboard
+}
+
+func (c *cachingClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	if c.dash != nil {
+		slog.Debug("Using cached dashboard definition", "dashboard", dashName)
+		grafana.SetTemplating(c.Client, c.dash.Templating.List)
+		return *c.dash, nil
+	}
+	dash, err := c.Client.GetDashboard(ctx, dashName)
+	if err == nil {
+		c.dash = &dash
+	}
+	return dash, err
+}
+
+// rerenderHandler re-renders a previously generated report, identified by the X-Report-Id
+// header of the original response, for the time range given in the query parameters.
+func (h ServeReportHandler) rerenderHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slog.Info("Reporter called to re-render")
+		if h.cache == nil {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "re-rendering is disabled")
+			return
+		}
+		id := mux.Vars(req)["reportId"]
+		entry, ok := h.cache.get(id)
+		if !ok {
+			requestError(w, req, http.StatusNotFound, codeNotFound, "unknown or expired report id: "+id)
+			return
+		}
+		release, ok := h.acquireReportSlot(w, req)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Re-render with the permissions of the caller, not of the original request
+		token := apiToken(req)
+		if token == "" {
+			requestError(w, req, http.StatusUnauthorized, codeUnauthorized, "re-rendering needs an api token: the apitoken query parameter, or a bearer token with -forward-auth")
+			return
+		}
+		s := entry.settings
+		g := h.newGrafanaClient(grafanaURL(), token, entry.variables, *sslCheck, s.layout.gridSizing(), dashboardClientOptions(entry.dash))
+		if *forwardAuth {
+			// The cached dashboard is only shown to callers that may still read it
+			if _, err := g.GetDashboard(req.Context(), entry.dashName); err != nil {
+				slog.Error("Error checking access to the cached dashboard", "error", err)
+				writeReportError(w, err)
+				return
+			}
+		}
+		g = &cachingClient{Client: g, dash: &entry.dash}
+		rep := h.newReport(g, entry.dashName, timeRange(req), s.template, s.layout == layoutRow, s.opts)
+		file, ok := generateReport(req.Context(), w, rep)
+		if !ok {
+			return
+		}
+		defer file.Close()
+		writeReport(w, rep.Title(), s.opts.Format, file)
+	})
+}
@@ -0,0 +1,65 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// cancelGracePeriod is how long cancelled reports get to stop and clean their temporary
+// directories once the shutdown timeout has passed
+const cancelGracePeriod = 30 * time.Second
+
+// serve serves srv on ln until a signal arrives on stop. It then stops accepting requests
+// and lets in-flight reports finish for up to timeout, after which their request contexts are
+// cancelled so they abort and clean up.
+func serve(srv *http.Server, ln net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-stop:
+		slog.Info("Finishing in-flight reports before shutting down", "signal", sig, "timeout", timeout)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("In-flight reports did not finish in time, cancelling them", "timeout", timeout)
+		cancel()
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), cancelGracePeriod)
+		defer cancelGrace()
+		err = srv.Shutdown(graceCtx)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Shut down")
+	return nil
+}
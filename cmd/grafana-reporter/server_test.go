@@ -0,0 +1,85 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServe(t *testing.T) {
+	Convey("When the reporter is stopped while a report is in flight", t, func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		cancelled := make(chan bool, 1)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			select {
+			case <-finish:
+				w.Write([]byte("report"))
+				cancelled <- false
+			case <-r.Context().Done():
+				cancelled <- true
+			}
+		})
+		stop := make(chan os.Signal, 1)
+		served := make(chan error, 1)
+		get := func() (string, error) {
+			resp, err := http.Get("http://" + ln.Addr().String() + "/")
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			return string(body), err
+		}
+
+		Convey("It should finish the report before shutting down", func() {
+			go func() { served <- serve(&http.Server{Handler: handler}, ln, stop, time.Minute) }()
+			response := make(chan string, 1)
+			go func() {
+				body, _ := get()
+				response <- body
+			}()
+			<-started
+			stop <- syscall.SIGTERM
+			time.Sleep(50 * time.Millisecond)
+			close(finish)
+			So(<-response, ShouldEqual, "report")
+			So(<-cancelled, ShouldBeFalse)
+			So(<-served, ShouldBeNil)
+		})
+
+		Convey("It should cancel the report after the shutdown timeout", func() {
+			go func() { served <- serve(&http.Server{Handler: handler}, ln, stop, 50*time.Millisecond) }()
+			go get()
+			<-started
+			stop <- syscall.SIGTERM
+			So(<-cancelled, ShouldBeTrue)
+			So(<-served, ShouldBeNil)
+		})
+	})
+}
@@ -21,3 +21,6 @@ const generatedMajor = "2"
 const generatedMinor = "3"
 const generatedRelease = "0"
 const generatedGitHash = "124b9a35302b3e9d4746ec0cf643abfecda8c21d"
+
+// version of the reporter, e.g. 2.3-0
+const version = generatedMajor + "." + generatedMinor + "-" + generatedRelease
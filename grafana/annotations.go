@@ -0,0 +1,82 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Annotation is an event marked on a dashboard, such as a deploy or an incident
+type Annotation struct {
+	Time    int64    `json:"time"`    // Unix time in ms
+	TimeEnd int64    `json:"timeEnd"` // Unix time in ms, equal to Time for events without a duration
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+// maxAnnotations limits how many annotations are fetched for a report
+const maxAnnotations = 100
+
+// GetAnnotations fetches the annotations of the dashboard within the time range
+func (g *client) GetAnnotations(ctx context.Context, dashUID string, t TimeRange) ([]Annotation, error) {
+	from, to, ok := t.Bounds()
+	if !ok {
+		return nil, fmt.Errorf("error getting annotations: unrecognised time range %v", t)
+	}
+	vals := url.Values{}
+	vals.Add("dashboardUID", dashUID)
+	vals.Add("from", strconv.FormatInt(from.UnixNano()/int64(time.Millisecond), 10))
+	vals.Add("to", strconv.FormatInt(to.UnixNano()/int64(time.Millisecond), 10))
+	vals.Add("limit", strconv.Itoa(maxAnnotations))
+	annotationsURL := g.url + "/api/annotations?" + vals.Encode()
+	slog.Debug("Getting annotations", "url", annotationsURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", annotationsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating annotations request for %v: %w", annotationsURL, err)
+	}
+	g.addHeaders(req)
+
+	resp, err := g.apiClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error executing annotations request for %v: %w", annotationsURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotations response body for %v: %w", annotationsURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting annotations %v: Status %d, Body: %s", annotationsURL, resp.StatusCode, limitString(string(body), 500))
+	}
+
+	var annotations []Annotation
+	err = json.Unmarshal(body, &annotations)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling annotations JSON from %v: %w", annotationsURL, err)
+	}
+	return annotations, nil
+}
@@ -17,46 +17,236 @@
 package grafana
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Client is a Grafana API client
+// Client is a Grafana API client. Cancelling the context aborts requests in flight.
 type Client interface {
-	GetDashboard(dashName string) (Dashboard, error)
-	GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetDashboard(ctx context.Context, dashName string) (Dashboard, error)
+	GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetDashboardPng(ctx context.Context, dashUID string, t TimeRange) (io.ReadCloser, error)
+	GetAnnotations(ctx context.Context, dashUID string, t TimeRange) ([]Annotation, error)
+	GetPanelCSV(ctx context.Context, p Panel, dashUID string, t TimeRange) (io.ReadCloser, error)
+	GetPanelValue(ctx context.Context, p Panel, dashUID string, t TimeRange) (float64, error)
+	FindDashboardUID(ctx context.Context, folder, title string) (string, error)
+	GetLibraryPanel(ctx context.Context, uid string) (Panel, error)
 	UsesGridLayout() bool
+	// RenderKey identifies what the client's images of the panel depend on besides the
+	// dashboard, time range and variables, so that only images of the same key are shared
+	RenderKey(p Panel, t TimeRange) string
 	// GetRowPng removed - no longer used
 }
 
+// ClientOptions holds optional client settings. The zero value gives the default behaviour.
+type ClientOptions struct {
+	// RenderTimeout is how long the Grafana renderer may take to render a panel, rounded up to
+	// whole seconds. Zero leaves the renderer's own default in place.
+	RenderTimeout time.Duration
+	// RenderWidth and RenderHeight are the pixel size panels are rendered at.
+	// Zero or negative values use the default of 1000x500.
+	RenderWidth  int
+	RenderHeight int
+	// PanelSizes overrides the render size of individual panels, by panel id
+	PanelSizes map[int]RenderSize
+	// GridUnitSize is the pixel size of one Grafana grid unit. With grid layout, panels are
+	// rendered at their GridPos width and height times this size. Zero uses the default of 40.
+	GridUnitSize int
+	// TimeDensity, in pixels per minute of the time range, sets the render width of panels
+	// with a time axis so that reports over different ranges stay equally readable. The width
+	// is clamped to between 500 and 5000 pixels. Zero disables this.
+	TimeDensity float64
+	// Timezone is the IANA zone that panels are rendered in. Empty uses UTC. DashboardTimezone
+	// uses the timezone of the dashboard fetched by GetDashboard.
+	Timezone string
+	// RenderScale is the renderer's device scale factor, e.g. 2 for images at twice the pixel
+	// density that stay crisp in print. Zero leaves the renderer's default of 1 in place.
+	RenderScale float64
+	// RetryBaseDelay and RetryMaxDelay shape the backoff between render retries: the delay
+	// doubles from the base on every attempt, up to the max, plus random jitter. A Retry-After
+	// header replaces the backoff but is capped at the max too. Zero uses the defaults of 2s
+	// and 30s.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// MaxRetries is how often a failed render is retried. Zero uses the default of 3; a
+	// negative value disables retries.
+	MaxRetries int
+	// RequestTimeout bounds each render request, including the wait for the renderer.
+	// Zero uses the default of 180s. It is raised if needed to outlast RenderTimeout.
+	RequestTimeout time.Duration
+	// BasicAuthUser and BasicAuthPassword add basic auth to every request, e.g. for a reverse
+	// proxy in front of Grafana. They are sent in the BasicAuthHeader, so that the api token
+	// still reaches Grafana as bearer token in the Authorization header.
+	BasicAuthUser     string
+	BasicAuthPassword string
+	// BasicAuthHeader is the header the basic auth credentials are sent in. Empty is
+	// Proxy-Authorization.
+	BasicAuthHeader string
+	// OrgID selects the Grafana organisation of every request, for api tokens of another org
+	// on multi-org instances. Zero omits the X-Grafana-Org-Id header.
+	OrgID int
+	// UserAgent is the User-Agent of every request. Empty sends grafana-reporter-go.
+	UserAgent string
+	// Headers are sent with every request, e.g. the X-WEBAUTH-USER of an auth proxy in front of
+	// Grafana. They replace headers of the same name set by the client.
+	Headers http.Header
+	// ClientCertificate is presented to Grafana, or a gateway in front of it, that requires
+	// mutual TLS. Nil presents none. See LoadClientCertificate.
+	ClientCertificate *tls.Certificate
+	// RootCAs are the certificate authorities Grafana's certificate is validated against,
+	// instead of the system's. Setting them always validates, even without sslCheck.
+	// See LoadCACertificates.
+	RootCAs *x509.CertPool
+	// Proxy is the proxy requests to Grafana go through. Nil uses the proxy of the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, if any.
+	Proxy *url.URL
+}
+
+// LoadCACertificates loads a PEM encoded bundle of certificate authorities
+func LoadCACertificates(caFile string) (*x509.CertPool, error) {
+	pemCerts, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificates %v: %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("error loading CA certificates %v: no PEM encoded certificates found", caFile)
+	}
+	return pool, nil
+}
+
+// LoadClientCertificate loads a PEM encoded client certificate and key for mutual TLS
+func LoadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate %v and key %v: %w", certFile, keyFile, err)
+	}
+	return &cert, nil
+}
+
+// DashboardTimezone is the ClientOptions.Timezone that renders panels in the dashboard's own timezone
+const DashboardTimezone = "dashboard"
+
+// RenderSize is the pixel size of a rendered panel
+type RenderSize struct {
+	Width  int
+	Height int
+}
+
+const (
+	defaultRenderWidth  = 1000
+	defaultRenderHeight = 500
+	defaultGridUnitSize = 40
+	// dashboardRenderWidth is the width whole dashboards are rendered at; their height is that
+	// of the full page
+	dashboardRenderWidth = 1600
+	minDensityWidth      = 500
+	maxDensityWidth      = 5000
+)
+
 type client struct {
-	url              string
-	getDashEndpoint  func(dashName string) string
-	getPanelEndpoint func(dashName string, vals url.Values) string // Used for panel rendering
-	apiToken         string
-	variables        url.Values
-	sslCheck         bool
-	useGridLayout    bool
+	url                       string
+	getDashEndpoint           func(dashName string) string
+	getPanelEndpoint          func(dashName string, vals url.Values) string // Used for panel rendering
+	getDashboardImageEndpoint func(dashName string, vals url.Values) string // Used for rendering whole dashboards
+	apiToken                  string
+	variables                 url.Values
+	sslCheck                  bool
+	useGridLayout             bool
+	timezone                  string
+	templating                []TemplateVariable // Variables of the dashboard fetched by GetDashboard
+	opts                      ClientOptions
+	// Built once and shared by all requests, so connections and TLS sessions are reused
+	apiClient    *http.Client
+	renderClient *http.Client
+}
+
+// ErrConcurrentRenderLimit is returned when Grafana's renderer refuses a render because it is
+// already rendering as many images as it allows. Retrying with fewer concurrent renders helps.
+var ErrConcurrentRenderLimit = errors.New("renderer concurrency limit reached")
+
+// Errors wrapped by the errors of the client, so that callers can tell why a request failed, e.g.
+// to answer with a matching HTTP status. Their messages keep the details of the failure.
+var (
+	ErrDashboardNotFound = errors.New("dashboard not found")
+	ErrUnauthorized      = errors.New("not authorized by Grafana")
+	ErrRenderTimeout     = errors.New("render timed out")
+	ErrRenderServer      = errors.New("renderer failed")
+)
+
+// AuthError is the error of a request that Grafana refused, with its status: 401 Unauthorized
+// for missing or invalid credentials, 403 Forbidden for credentials without access. It matches
+// ErrUnauthorized.
+type AuthError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *AuthError) Error() string {
+	return ErrUnauthorized.Error() + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// statusError wraps err with the error of the client matching the status of a failed response,
+// if there is one
+func statusError(status int, err error) error {
+	switch {
+	case status == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrDashboardNotFound, err)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return &AuthError{StatusCode: status, Err: err}
+	case status == http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: %w", ErrRenderTimeout, err)
+	}
+	return err
 }
 
 // Retry configuration
 var getPanelRetrySleepTime = time.Duration(2 * time.Second) // Base sleep time
+const maxGetPanelRetrySleepTime = 30 * time.Second
 const maxGetPanelRetries = 3
 const renderRequestTimeout = 180 * time.Second // Keep increased timeout for panels
+const apiRequestTimeout = 30 * time.Second
+const defaultUserAgent = "grafana-reporter-go"
+
+// trimBaseURL drops the trailing slashes of the Grafana base URL, e.g. of https://host/grafana/,
+// so that the paths of the endpoints can be appended to it
+func trimBaseURL(baseURL string) string {
+	return strings.TrimRight(baseURL, "/")
+}
 
 // NewV4Client (Keep as is, no GetRowPng to worry about)
-func NewV4Client(baseURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) Client {
-	log.Println("Using Grafana v4 client.")
+func NewV4Client(baseURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, opts ClientOptions) Client {
+	slog.Debug("Using Grafana v4 client")
+	baseURL = trimBaseURL(baseURL)
 	// ... (rest of V4 implementation remains the same) ...
-	return &client{
+	g := &client{
 		url: baseURL,
 		getDashEndpoint: func(dashName string) string {
 			return baseURL + "/api/dashboards/db/" + dashName
@@ -65,18 +255,26 @@ func NewV4Client(baseURL string, apiToken string, variables url.Values, sslCheck
 			renderURL := baseURL + "/render/dashboard-solo/db/" + dashName + "?" + vals.Encode()
 			return renderURL
 		},
+		getDashboardImageEndpoint: func(dashName string, vals url.Values) string {
+			return baseURL + "/render/dashboard/db/" + dashName + "?" + vals.Encode()
+		},
 		apiToken:      apiToken,
 		variables:     variables,
 		sslCheck:      sslCheck,
 		useGridLayout: gridLayout,
+		timezone:      renderTimezone(opts.Timezone),
+		opts:          opts,
 	}
+	g.initHTTPClients()
+	return g
 }
 
 // NewV5Client (Keep as is, no GetRowPng to worry about)
-func NewV5Client(baseURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) Client {
-	log.Println("Using Grafana v5 client.")
+func NewV5Client(baseURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, opts ClientOptions) Client {
+	slog.Debug("Using Grafana v5 client")
+	baseURL = trimBaseURL(baseURL)
 	// ... (rest of V5 implementation remains the same) ...
-	return &client{
+	g := &client{
 		url: baseURL,
 		getDashEndpoint: func(dashName string) string {
 			isUID := false
@@ -90,10 +288,10 @@ func NewV5Client(baseURL string, apiToken string, variables url.Values, sslCheck
 				}
 			}
 			if isUID {
-				log.Printf("Assuming '%s' is a UID for dashboard fetching.", dashName)
+				slog.Debug("Assuming the dashboard name is a UID", "dashboard", dashName)
 				return baseURL + "/api/dashboards/uid/" + dashName
 			} else {
-				log.Printf("Assuming '%s' is a slug for dashboard fetching.", dashName)
+				slog.Debug("Assuming the dashboard name is a slug", "dashboard", dashName)
 				return baseURL + "/api/dashboards/db/" + dashName
 			}
 		},
@@ -101,11 +299,45 @@ func NewV5Client(baseURL string, apiToken string, variables url.Values, sslCheck
 			renderURL := baseURL + "/render/d-solo/" + dashName + "?" + vals.Encode()
 			return renderURL
 		},
+		getDashboardImageEndpoint: func(dashName string, vals url.Values) string {
+			return baseURL + "/render/d/" + dashName + "?" + vals.Encode()
+		},
+		apiToken:      apiToken,
+		variables:     variables,
+		sslCheck:      sslCheck,
+		useGridLayout: gridLayout,
+		timezone:      renderTimezone(opts.Timezone),
+		opts:          opts,
+	}
+	g.initHTTPClients()
+	return g
+}
+
+// NewV9Client creates a client for Grafana 9 and later. These versions dropped fetching
+// dashboards by slug, so dashName must be the dashboard UID.
+func NewV9Client(baseURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool, opts ClientOptions) Client {
+	slog.Debug("Using Grafana v9 client")
+	baseURL = trimBaseURL(baseURL)
+	g := &client{
+		url: baseURL,
+		getDashEndpoint: func(dashUID string) string {
+			return baseURL + "/api/dashboards/uid/" + dashUID
+		},
+		getPanelEndpoint: func(dashUID string, vals url.Values) string {
+			return baseURL + "/render/d-solo/" + dashUID + "?" + vals.Encode()
+		},
+		getDashboardImageEndpoint: func(dashUID string, vals url.Values) string {
+			return baseURL + "/render/d/" + dashUID + "?" + vals.Encode()
+		},
 		apiToken:      apiToken,
 		variables:     variables,
 		sslCheck:      sslCheck,
 		useGridLayout: gridLayout,
+		timezone:      renderTimezone(opts.Timezone),
+		opts:          opts,
 	}
+	g.initHTTPClients()
+	return g
 }
 
 // UsesGridLayout (Keep as is)
@@ -113,24 +345,38 @@ func (g *client) UsesGridLayout() bool {
 	return g.useGridLayout
 }
 
-// GetDashboard (Keep as is)
-func (g *client) GetDashboard(dashName string) (Dashboard, error) {
-	dashURL := g.getDashEndpoint(dashName)
-	log.Println("Getting dashboard definition from:", dashURL)
+// RenderKey is a hash of the credentials and organisation the panel is rendered for, and of its
+// size, scale and timezone
+func (g *client) RenderKey(p Panel, t TimeRange) string {
+	size := g.renderSize(p, t)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n%v\n%dx%d\n%g\n%s", g.apiToken, g.opts.BasicAuthUser, g.opts.OrgID, g.opts.Headers,
+		size.Width, size.Height, g.opts.RenderScale, g.timezone)
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !g.sslCheck},
+// GetDashboard fetches the dashboard by UID or slug, or by "folder/title", which is looked up first
+func (g *client) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	var dashURL string
+	if folder, title, ok := splitFolderTitle(dashName); ok {
+		uid, err := g.FindDashboardUID(ctx, folder, title)
+		if err != nil {
+			return Dashboard{}, fmt.Errorf("error finding dashboard %v: %w", dashName, err)
+		}
+		dashName = uid
+		dashURL = g.url + "/api/dashboards/uid/" + uid
+	} else {
+		dashURL = g.getDashEndpoint(dashName)
 	}
-	httpClient := &http.Client{Transport: tr, Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", dashURL, nil)
+	slog.Debug("Getting dashboard definition", "url", dashURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", dashURL, nil)
 	if err != nil {
 		return Dashboard{}, fmt.Errorf("error creating GetDashboard request for %v: %w", dashURL, err)
 	}
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
-	}
+	g.addHeaders(req)
 
-	resp, err := httpClient.Do(req)
+	resp, err := g.apiClient.Do(req)
 	if err != nil {
 		return Dashboard{}, fmt.Errorf("error executing GetDashboard request for %v: %w", dashURL, err)
 	}
@@ -138,7 +384,7 @@ func (g *client) GetDashboard(dashName string) (Dashboard, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return Dashboard{}, fmt.Errorf("error getting dashboard %v: Status %d, Body: %s", dashURL, resp.StatusCode, limitString(string(bodyBytes), 500))
+		return Dashboard{}, statusError(resp.StatusCode, fmt.Errorf("error getting dashboard %v: Status %d, Body: %s", dashURL, resp.StatusCode, limitString(string(bodyBytes), 500)))
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -151,6 +397,7 @@ func (g *client) GetDashboard(dashName string) (Dashboard, error) {
 	if err != nil {
 		return Dashboard{}, fmt.Errorf("error unmarshaling dashboard JSON from %v: %w\nRaw JSON response snippet:\n%s", dashURL, err, limitString(string(body), 500))
 	}
+	fullDash.Dashboard.variables = g.variables
 
 	if fullDash.Dashboard.Uid == "" {
 	    isUID := false
@@ -164,12 +411,12 @@ func (g *client) GetDashboard(dashName string) (Dashboard, error) {
             }
         }
         if isUID {
-            log.Printf("Dashboard JSON missing UID, using provided '%s' as UID.", dashName)
+            slog.Warn("Dashboard JSON is missing its UID, using the requested name", "dashboard", dashName)
             fullDash.Dashboard.Uid = dashName
         } else {
-             log.Printf("Warning: Dashboard JSON missing UID and provided name '%s' doesn't look like a UID.", dashName)
+             slog.Warn("Dashboard JSON is missing its UID and the requested name does not look like one", "dashboard", dashName)
              if fullDash.Meta.Slug != "" {
-                 log.Printf("Using dashboard slug '%s' as fallback identifier.", fullDash.Meta.Slug)
+                 slog.Warn("Using the dashboard slug as its identifier", "slug", fullDash.Meta.Slug)
                  fullDash.Dashboard.Uid = fullDash.Meta.Slug
              } else {
                  fullDash.Dashboard.Uid = dashName
@@ -179,11 +426,116 @@ func (g *client) GetDashboard(dashName string) (Dashboard, error) {
 
 	// Process panels and rows within the Dashboard struct
 	fullDash.Dashboard.processPanelsAndRows()
+	g.resolveLibraryPanels(ctx, &fullDash.Dashboard)
 
-	log.Printf("Successfully fetched dashboard: %s (UID: %s)", fullDash.Dashboard.Title, fullDash.Dashboard.Uid)
+	if g.opts.Timezone == DashboardTimezone {
+		g.timezone = fullDash.Dashboard.RenderTimezone()
+	}
+	g.templating = fullDash.Dashboard.Templating.List
+
+	slog.Info("Fetched dashboard", "title", fullDash.Dashboard.Title, "uid", fullDash.Dashboard.Uid)
 	return fullDash.Dashboard, nil
 }
 
+// isConcurrentRenderLimit recognises the response of a renderer at its concurrency limit
+func isConcurrentRenderLimit(status int, body []byte) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return bytes.Contains(bytes.ToLower(body), []byte("concurrent"))
+	}
+	return false
+}
+
+// initHTTPClients builds the http clients for API and render requests. Render requests
+// reject redirects, which usually mean a login page instead of an image.
+func (g *client) initHTTPClients() {
+	tr := g.transport()
+	g.apiClient = &http.Client{Transport: tr, Timeout: apiRequestTimeout}
+	g.renderClient = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return fmt.Errorf("redirect detected for render URL %s (possible auth/token issue?)", req.URL)
+		},
+		Transport: tr,
+		Timeout:   g.renderRequestTimeout(),
+	}
+}
+
+// transportKey identifies the TLS and proxy configuration of a transport
+type transportKey struct {
+	insecure bool
+	rootCAs  *x509.CertPool
+	cert     *tls.Certificate
+	proxy    string
+}
+
+// transports are shared by the clients of a configuration, so that a client per report does
+// not leave a pool of idle connections behind, and reports reuse each other's connections
+var transports = struct {
+	sync.Mutex
+	m map[transportKey]*http.Transport
+}{m: map[transportKey]*http.Transport{}}
+
+// transport is the transport of requests to Grafana
+func (g *client) transport() *http.Transport {
+	key := transportKey{insecure: !g.sslCheck, rootCAs: g.opts.RootCAs, cert: g.opts.ClientCertificate}
+	proxy := http.ProxyFromEnvironment
+	if g.opts.Proxy != nil {
+		proxy = http.ProxyURL(g.opts.Proxy)
+		key.proxy = g.opts.Proxy.String()
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+	tr, ok := transports.m[key]
+	if !ok {
+		tr = &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: g.tlsConfig(),
+		}
+		transports.m[key] = tr
+	}
+	return tr
+}
+
+// tlsConfig is the TLS configuration of requests to Grafana
+func (g *client) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: !g.sslCheck && g.opts.RootCAs == nil,
+		RootCAs:            g.opts.RootCAs,
+	}
+	if g.opts.ClientCertificate != nil {
+		config.Certificates = []tls.Certificate{*g.opts.ClientCertificate}
+	}
+	return config
+}
+
+// addHeaders adds the basic auth credentials, the api token, the organisation, the User-Agent
+// and the configured headers to the request
+func (g *client) addHeaders(req *http.Request) {
+	if g.opts.BasicAuthUser != "" {
+		header := g.opts.BasicAuthHeader
+		if header == "" {
+			header = "Proxy-Authorization"
+		}
+		credentials := g.opts.BasicAuthUser + ":" + g.opts.BasicAuthPassword
+		req.Header.Set(header, "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if g.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiToken)
+	}
+	if g.opts.OrgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.Itoa(g.opts.OrgID))
+	}
+	userAgent := g.opts.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for name, values := range g.opts.Headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+}
+
 // Helper to limit string length for logging
 func limitString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -197,37 +549,46 @@ func limitString(s string, maxLen int) string {
 }
 
 // GetPanelPng fetches a panel's PNG image (Keep as is)
-func (g *client) GetPanelPng(p Panel, dashUID string, t TimeRange) (io.ReadCloser, error) {
+func (g *client) GetPanelPng(ctx context.Context, p Panel, dashUID string, t TimeRange) (io.ReadCloser, error) {
 	if dashUID == "" {
 		return nil, fmt.Errorf("error rendering panel %d: dashboard UID is empty", p.Id)
 	}
 	// Construct URL parameters
 	vals := url.Values{}
-	vals.Add("panelId", strconv.Itoa(p.Id))
-	vals.Add("width", "1000")
-	vals.Add("height", "500")
-	vals.Add("tz", "UTC")
+	vals.Add("panelId", strconv.Itoa(p.RenderID()))
+	size := g.renderSize(p, t)
+	vals.Add("width", strconv.Itoa(size.Width))
+	vals.Add("height", strconv.Itoa(size.Height))
+	vals.Add("tz", g.timezone)
 	vals.Add("from", t.From)
 	vals.Add("to", t.To)
+	if g.opts.RenderTimeout > 0 {
+		vals.Add("timeout", g.renderTimeoutSeconds())
+	}
+	scale := 1.0
+	if g.opts.RenderScale > 0 {
+		scale = g.opts.RenderScale
+		vals.Add("scale", strconv.FormatFloat(scale, 'f', -1, 64))
+	}
 
-	// Add dashboard variables
-	for k, v := range g.variables {
-		for _, singleV := range v {
-			key := k
-			if len(key) < 4 || key[:4] != "var-" {
-				key = "var-" + key
-			}
-			vals.Add(key, singleV)
+	// Add dashboard variables, the values of a repeated panel replace those of the report
+	for key, v := range g.renderVariables() {
+		if _, scoped := p.ScopedVars[key]; scoped {
+			continue
 		}
+		vals[key] = v
+	}
+	for k, v := range p.ScopedVars {
+		vals[k] = v
 	}
 
 	// Generate the final render URL using the correct endpoint function
 	endpointFunc := g.getPanelEndpoint // Get the function assigned during client creation
 	renderURL := endpointFunc(dashUID, vals)
-	log.Printf("Requesting panel '%s' (ID: %d) image using endpoint for UID '%s': %s", p.Title, p.Id, dashUID, renderURL)
+	slog.Debug("Requesting panel image", "panel", p.Id, "title", p.Title, "width", size.Width, "height", size.Height, "scale", scale, "dashboard", dashUID, "url", renderURL)
 
 	// Make the HTTP request with retries
-	resp, err := g.makeRenderRequest(renderURL, p.Id, "panel")
+	resp, err := g.makeRenderRequest(ctx, renderURL, p.Id, "panel")
 	if err != nil {
 		return nil, err
 	}
@@ -235,83 +596,265 @@ func (g *client) GetPanelPng(p Panel, dashUID string, t TimeRange) (io.ReadClose
 	return resp.Body, nil
 }
 
-// makeRenderRequest (Keep as is, with increased timeout)
-func (g *client) makeRenderRequest(renderURL string, id int, renderType string) (*http.Response, error) {
-	var resp *http.Response
-	var err error
+// GetDashboardPng renders the whole dashboard as one PNG image, in kiosk mode so that Grafana's
+// menus are left out. The image is as high as the full dashboard.
+func (g *client) GetDashboardPng(ctx context.Context, dashUID string, t TimeRange) (io.ReadCloser, error) {
+	if dashUID == "" {
+		return nil, fmt.Errorf("error rendering dashboard: dashboard UID is empty")
+	}
+	vals := g.renderVariables()
+	vals.Set("width", strconv.Itoa(dashboardRenderWidth))
+	vals.Set("height", "-1") // The height of the full page
+	vals.Set("kiosk", "")
+	vals.Set("tz", g.timezone)
+	vals.Set("from", t.From)
+	vals.Set("to", t.To)
+	if g.opts.RenderTimeout > 0 {
+		vals.Set("timeout", g.renderTimeoutSeconds())
+	}
+	if g.opts.RenderScale > 0 {
+		vals.Set("scale", strconv.FormatFloat(g.opts.RenderScale, 'f', -1, 64))
+	}
 
-	// Configure HTTP client
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !g.sslCheck},
+	renderURL := g.getDashboardImageEndpoint(dashUID, vals)
+	slog.Debug("Requesting dashboard image", "dashboard", dashUID, "url", renderURL)
+	resp, err := g.makeRenderRequest(ctx, renderURL, 0, "dashboard")
+	if err != nil {
+		return nil, err
 	}
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return fmt.Errorf("redirect detected for render URL %s (possible auth/token issue?)", req.URL)
-		},
-		Transport: tr,
-		Timeout:   renderRequestTimeout, // Use timeout constant
+	return resp.Body, nil
+}
+
+// retryDelay is the exponential backoff before the given retry, with up to 50% random jitter
+// so that many panels failing at once do not all retry in lockstep
+func (g *client) retryDelay(retry int) time.Duration {
+	base, max := g.opts.RetryBaseDelay, g.maxRetryDelay()
+	if base <= 0 {
+		base = getPanelRetrySleepTime
+	}
+	delay := max
+	if shift := retry - 1; shift < 32 && base<<shift > 0 && base<<shift < max {
+		delay = base << shift
+	}
+	delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// maxRetryDelay is the longest wait before a render retry, whether from the backoff or from a
+// Retry-After header
+func (g *client) maxRetryDelay() time.Duration {
+	if g.opts.RetryMaxDelay <= 0 {
+		return maxGetPanelRetrySleepTime
+	}
+	return g.opts.RetryMaxDelay
+}
+
+// parseRetryAfter is the wait asked for by a Retry-After header, given either in seconds or
+// as an HTTP date. ok is false if the header is missing or not recognised.
+func parseRetryAfter(header string, now time.Time) (wait time.Duration, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait = date.Sub(now); wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// renderTimezone is the zone to render in for the configured timezone. Until a dashboard
+// is fetched, the DashboardTimezone sentinel renders in UTC.
+func renderTimezone(tz string) string {
+	if tz == "" || tz == DashboardTimezone {
+		return "UTC"
 	}
+	return tz
+}
+
+// renderRequestTimeout makes sure we wait for the renderer at least as long as it may take
+func (g *client) renderRequestTimeout() time.Duration {
+	timeout := renderRequestTimeout
+	if g.opts.RequestTimeout > 0 {
+		timeout = g.opts.RequestTimeout
+	}
+	if margin := 10 * time.Second; g.opts.RenderTimeout+margin > timeout {
+		return g.opts.RenderTimeout + margin
+	}
+	return timeout
+}
+
+// maxRetries is how often a failed render is retried
+func (g *client) maxRetries() int {
+	switch {
+	case g.opts.MaxRetries < 0:
+		return 0
+	case g.opts.MaxRetries == 0:
+		return maxGetPanelRetries
+	}
+	return g.opts.MaxRetries
+}
+
+// renderTimeoutSeconds is the renderer's timeout parameter: the render timeout in whole
+// seconds, rounded up so that the renderer gets at least the configured time
+func (g *client) renderTimeoutSeconds() string {
+	return strconv.Itoa(int(math.Ceil(g.opts.RenderTimeout.Seconds())))
+}
+
+// renderSize is the size for the panel: a per-panel override, else the size derived from its
+// grid position when using grid layout, else the configured size. With a time density, the
+// width of panels with a time axis follows from the time range instead. Dimensions that are
+// not positive fall back to the defaults.
+func (g *client) renderSize(p Panel, t TimeRange) RenderSize {
+	size := RenderSize{g.opts.RenderWidth, g.opts.RenderHeight}
+	if override, ok := g.opts.PanelSizes[p.Id]; ok {
+		return withDefaultSize(override)
+	}
+	if g.useGridLayout && p.GridPos.W > 0 && p.GridPos.H > 0 {
+		unit := g.opts.GridUnitSize
+		if unit <= 0 {
+			unit = defaultGridUnitSize
+		}
+		size = RenderSize{int(p.GridPos.W * float64(unit)), int(p.GridPos.H * float64(unit))}
+	}
+	if g.opts.TimeDensity > 0 && p.HasTimeAxis() {
+		if span, ok := t.Span(); ok {
+			size.Width = densityWidth(span, g.opts.TimeDensity)
+		} else {
+			slog.Warn("Cannot determine the span of the time range, rendering the panel at its normal width", "timeRange", t, "panel", p.Id)
+		}
+	}
+	return withDefaultSize(size)
+}
+
+// densityWidth is the width that gives span the density in pixels per minute, clamped to sane bounds
+func densityWidth(span time.Duration, density float64) int {
+	width := int(span.Minutes() * density)
+	if width < minDensityWidth {
+		return minDensityWidth
+	}
+	if width > maxDensityWidth {
+		return maxDensityWidth
+	}
+	return width
+}
+
+// withDefaultSize replaces dimensions that are not positive with the defaults
+func withDefaultSize(size RenderSize) RenderSize {
+	if size.Width <= 0 {
+		size.Width = defaultRenderWidth
+	}
+	if size.Height <= 0 {
+		size.Height = defaultRenderHeight
+	}
+	return size
+}
+
+// makeRenderRequest (Keep as is, with increased timeout)
+func (g *client) makeRenderRequest(ctx context.Context, renderURL string, id int, renderType string) (*http.Response, error) {
+	var resp *http.Response
+	var err error
 
 	// Create request
-	req, err := http.NewRequest("GET", renderURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", renderURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating render request for %s ID %d URL %v: %w", renderType, id, renderURL, err)
 	}
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
-	}
-	req.Header.Add("User-Agent", "grafana-reporter-go")
+	g.addHeaders(req)
 
 	// Execute request with retries
-	for retries := 0; retries <= maxGetPanelRetries; retries++ {
+	maxRetries := g.maxRetries()
+	var retryAfter time.Duration // Wait asked for by a rate limited response, replaces the backoff, capped like it
+	for retries := 0; retries <= maxRetries; retries++ {
 		if retries > 0 {
-			delay := getPanelRetrySleepTime * time.Duration(retries)
-			log.Printf("Retrying %s render for ID %d after %v...", renderType, id, delay)
-			time.Sleep(delay)
+			delay := g.retryDelay(retries)
+			if retryAfter > 0 {
+				delay, retryAfter = retryAfter, 0
+			}
+			slog.Debug("Retrying render", "type", renderType, "id", id, "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("render of %s ID %d cancelled: %w", renderType, id, ctx.Err())
+			}
 		}
 
-		resp, err = client.Do(req)
+		resp, err = g.renderClient.Do(req)
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("render of %s ID %d cancelled: %w", renderType, id, ctx.Err())
+		}
 		if err != nil {
 			if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
-				log.Printf("Timeout error executing render request for %s ID %d (attempt %d/%d): %v", renderType, id, retries+1, maxGetPanelRetries+1, err)
+				slog.Warn("Render request timed out", "type", renderType, "id", id, "attempt", retries+1, "attempts", maxRetries+1, "error", err)
 			} else {
-				log.Printf("Error executing render request for %s ID %d (attempt %d/%d): %v", renderType, id, retries+1, maxGetPanelRetries+1, err)
+				slog.Warn("Render request failed", "type", renderType, "id", id, "attempt", retries+1, "attempts", maxRetries+1, "error", err)
 			}
-			if retries == maxGetPanelRetries {
-				return nil, fmt.Errorf("error executing render request for %s ID %d URL %v after %d retries: %w", renderType, id, renderURL, maxGetPanelRetries, err)
+			if retries == maxRetries {
+				if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
+					err = fmt.Errorf("%w: %w", ErrRenderTimeout, err)
+				}
+				return nil, fmt.Errorf("error executing render request for %s ID %d URL %v after %d retries: %w", renderType, id, renderURL, maxRetries, err)
 			}
 			continue
 		}
 
 		// Check status code
 		if resp.StatusCode == http.StatusOK {
-			log.Printf("Successfully obtained render for %s ID %d (Status: %d)", renderType, id, resp.StatusCode)
+			slog.Debug("Obtained render", "type", renderType, "id", id, "status", resp.StatusCode)
 			return resp, nil // Success!
 		}
 
 		// Handle non-OK status codes
-		log.Printf("Error obtaining render for %s ID %d (attempt %d/%d), Status: %d", renderType, id, retries+1, maxGetPanelRetries+1, resp.StatusCode)
+		slog.Warn("Render failed", "type", renderType, "id", id, "attempt", retries+1, "attempts", maxRetries+1, "status", resp.StatusCode)
 		bodyBytes, readErr := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
 		if readErr != nil {
-		    log.Printf("Failed to read response body after error status %d: %v", resp.StatusCode, readErr)
+		    slog.Warn("Failed to read the body of the render response", "status", resp.StatusCode, "error", readErr)
 		}
-		log.Printf("Response Body Snippet: %s", limitString(string(bodyBytes), 200))
+		slog.Debug("Render response", "body", limitString(string(bodyBytes), 200))
 
+		if isConcurrentRenderLimit(resp.StatusCode, bodyBytes) {
+			return nil, fmt.Errorf("error rendering %s ID %d: %w (Status %d)", renderType, id, ErrConcurrentRenderLimit, resp.StatusCode)
+		}
 		if resp.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("error rendering %s ID %d: Not Found (404). Check dashboard UID/slug and %s ID. URL: %s", renderType, id, renderType, renderURL)
+			return nil, fmt.Errorf("error rendering %s ID %d: %w (404). Check dashboard UID/slug and %s ID. URL: %s", renderType, id, ErrDashboardNotFound, renderType, renderURL)
 		}
 		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			return nil, fmt.Errorf("error rendering %s ID %d: Authorization Failed (%d). Check API token permissions. URL: %s. Body: %s", renderType, id, resp.StatusCode, renderURL, limitString(string(bodyBytes), 200))
+			return nil, &AuthError{StatusCode: resp.StatusCode, Err: fmt.Errorf("error rendering %s ID %d (%d). Check API token permissions. URL: %s. Body: %s", renderType, id, resp.StatusCode, renderURL, limitString(string(bodyBytes), 200))}
 		}
-		if resp.StatusCode >= 500 {
-			log.Printf("Server error (%d), will retry...", resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				retryAfter = min(wait, g.maxRetryDelay())
+				slog.Debug("Rate limited, will retry after the Retry-After delay", "delay", wait)
+			} else {
+				slog.Debug("Rate limited, will retry")
+			}
+		} else if resp.StatusCode >= 500 {
+			slog.Debug("Server error, will retry", "status", resp.StatusCode)
 		} else {
 			return nil, fmt.Errorf("error rendering %s ID %d: Client Error Status %d. URL: %s. Body: %s", renderType, id, resp.StatusCode, renderURL, limitString(string(bodyBytes), 200))
 		}
 
-		if retries == maxGetPanelRetries {
-			return nil, fmt.Errorf("error rendering %s ID %d after %d retries: Last status %d. URL: %s. Body: %s", renderType, id, maxGetPanelRetries, resp.StatusCode, renderURL, limitString(string(bodyBytes), 200))
+		if retries == maxRetries {
+			err := fmt.Errorf("error rendering %s ID %d after %d retries: Last status %d. URL: %s. Body: %s", renderType, id, maxRetries, resp.StatusCode, renderURL, limitString(string(bodyBytes), 200))
+			if resp.StatusCode == http.StatusGatewayTimeout {
+				return nil, fmt.Errorf("%w: %w", ErrRenderTimeout, err)
+			} else if resp.StatusCode >= 500 {
+				return nil, fmt.Errorf("%w: %w", ErrRenderServer, err)
+			}
+			return nil, err
 		}
 	} // End retry loop
 
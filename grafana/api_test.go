@@ -17,10 +17,26 @@
 package grafana
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -37,8 +53,8 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 		defer ts.Close()
 
 		Convey("When using the Grafana v4 client", func() {
-			grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
-			grf.GetDashboard("testDash")
+			grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
 
 			Convey("It should use the v4 dashboards endpoint", func() {
 				So(requestURI, ShouldEqual, "/api/dashboards/db/testDash")
@@ -46,14 +62,549 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 		})
 
 		Convey("When using the Grafana v5 client", func() {
-			grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
-			grf.GetDashboard("rYy7Paekz")
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "rYy7Paekz")
 
 			Convey("It should use the v5 dashboards endpoint", func() {
 				So(requestURI, ShouldEqual, "/api/dashboards/uid/rYy7Paekz")
 			})
 		})
 
+		Convey("When using the Grafana v9 client", func() {
+			grf := NewV9Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "short")
+
+			Convey("It should use the uid endpoint, even for short names", func() {
+				So(requestURI, ShouldEqual, "/api/dashboards/uid/short")
+			})
+		})
+
+	})
+}
+
+func TestGrafanaSubPathEndpoints(t *testing.T) {
+	Convey("When Grafana is served below the root of its host", t, func() {
+		vals := url.Values{"panelId": {"2"}}
+
+		Convey("The endpoints should be joined to the sub-path without double slashes", func() {
+			for _, base := range []string{"https://host/grafana", "https://host/grafana/"} {
+				g4 := NewV4Client(base, "", url.Values{}, true, false, ClientOptions{}).(*client)
+				So(g4.getDashEndpoint("testDash"), ShouldEqual, "https://host/grafana/api/dashboards/db/testDash")
+				So(g4.getPanelEndpoint("testDash", vals), ShouldEqual, "https://host/grafana/render/dashboard-solo/db/testDash?panelId=2")
+
+				g5 := NewV5Client(base, "", url.Values{}, true, false, ClientOptions{}).(*client)
+				So(g5.getDashEndpoint("rYy7Paekz"), ShouldEqual, "https://host/grafana/api/dashboards/uid/rYy7Paekz")
+				So(g5.getPanelEndpoint("rYy7Paekz", vals), ShouldEqual, "https://host/grafana/render/d-solo/rYy7Paekz?panelId=2")
+
+				g9 := NewV9Client(base, "", url.Values{}, true, false, ClientOptions{}).(*client)
+				So(g9.getDashEndpoint("short"), ShouldEqual, "https://host/grafana/api/dashboards/uid/short")
+				So(g9.getPanelEndpoint("short", vals), ShouldEqual, "https://host/grafana/render/d-solo/short?panelId=2")
+				So(g9.url, ShouldEqual, "https://host/grafana")
+			}
+		})
+
+		Convey("Requests should go to the sub-path", func() {
+			requestURI := ""
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestURI = r.RequestURI
+				fmt.Fprintln(w, `{"":""}`)
+			}))
+			defer ts.Close()
+			grf := NewV9Client(ts.URL+"/grafana/", "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "short")
+			So(requestURI, ShouldEqual, "/grafana/api/dashboards/uid/short")
+		})
+	})
+}
+
+func TestGrafanaClientFindsDashboardUID(t *testing.T) {
+	Convey("When looking up a dashboard by folder and title", t, func() {
+		var requests []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			if r.URL.Path == "/api/search" {
+				fmt.Fprintln(w, `[
+					{"uid":"abc123","title":"Backend","url":"/d/abc123/backend","folderTitle":"Ops"},
+					{"uid":"def456","title":"Backend","url":"/d/def456/backend"},
+					{"uid":"ghi789","title":"Backend services","url":"/d/ghi789/backend-services","folderTitle":"Ops"},
+					{"uid":"dup1","title":"Frontend","url":"/d/dup1/frontend","folderTitle":"Ops"},
+					{"uid":"dup2","title":"Frontend","url":"/d/dup2/frontend-copy","folderTitle":"Ops"}]`)
+				return
+			}
+			fmt.Fprintln(w, `{"dashboard":{"title":"Backend","uid":"abc123"}}`)
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+
+		Convey("It should resolve the UID of the dashboard in that folder", func() {
+			uid, err := grf.FindDashboardUID(context.Background(), "ops", "backend")
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, "abc123")
+		})
+
+		Convey("It should treat an empty folder as the General folder", func() {
+			uid, err := grf.FindDashboardUID(context.Background(), "", "Backend")
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, "def456")
+		})
+
+		Convey("It should fail when no dashboard matches", func() {
+			_, err := grf.FindDashboardUID(context.Background(), "Dev", "Backend")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("It should list the candidates when several dashboards match", func() {
+			_, err := grf.FindDashboardUID(context.Background(), "Ops", "Frontend")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "dup1")
+			So(err.Error(), ShouldContainSubstring, "dup2")
+		})
+
+		Convey("GetDashboard should accept folder/title", func() {
+			dash, err := grf.GetDashboard(context.Background(), "Ops/Backend")
+			So(err, ShouldBeNil)
+			So(dash.Uid, ShouldEqual, "abc123")
+			So(requests, ShouldResemble, []string{"/api/search", "/api/dashboards/uid/abc123"})
+		})
+	})
+}
+
+func TestGrafanaClientResolvesLibraryPanels(t *testing.T) {
+	Convey("When fetching a dashboard with library panels", t, func() {
+		libraryRequests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/library-elements/lib1":
+				libraryRequests++
+				fmt.Fprintln(w, `{"result":{"uid":"lib1","name":"CPU","model":{"type":"timeseries","title":"CPU usage","description":"Per core","gridPos":{"h":3,"w":3,"x":0,"y":0}}}}`)
+			case "/api/library-elements/missing":
+				http.Error(w, "not found", http.StatusNotFound)
+			default:
+				fmt.Fprintln(w, `{"dashboard":{"uid":"abc123XYZ","panels":[
+					{"id":1,"gridPos":{"h":8,"w":12,"x":0,"y":0},"libraryPanel":{"uid":"lib1","name":"CPU"}},
+					{"id":2,"gridPos":{"h":8,"w":12,"x":12,"y":0},"libraryPanel":{"uid":"lib1","name":"CPU"}},
+					{"id":3,"title":"Gone","gridPos":{"h":8,"w":12,"x":0,"y":8},"libraryPanel":{"uid":"missing","name":"Gone"}}]}}`)
+			}
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		dash, err := grf.GetDashboard(context.Background(), "abc123XYZ")
+		So(err, ShouldBeNil)
+		panels := dash.GetGridPanels()
+
+		Convey("The panels should be defined by their library panel", func() {
+			So(panels[0].Type, ShouldEqual, "timeseries")
+			So(panels[0].Title, ShouldEqual, "CPU usage")
+			So(panels[0].Description, ShouldEqual, "Per core")
+		})
+
+		Convey("The panels should keep their own id and position", func() {
+			So(panels[1].Id, ShouldEqual, 2)
+			So(panels[1].GridPos, ShouldResemble, GridPos{H: 8, W: 12, X: 12, Y: 0})
+		})
+
+		Convey("Each library panel should be fetched once", func() {
+			So(libraryRequests, ShouldEqual, 1)
+		})
+
+		Convey("Panels whose library panel cannot be fetched should be kept", func() {
+			So(panels, ShouldHaveLength, 3)
+			So(panels[2].Title, ShouldEqual, "Gone")
+		})
+	})
+}
+
+func TestGrafanaClientAuth(t *testing.T) {
+	Convey("When calling the Grafana API", t, func() {
+		var auth, proxyAuth []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Values("Authorization")
+			proxyAuth = r.Header.Values("Proxy-Authorization")
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+		}))
+		defer ts.Close()
+
+		Convey("It should send the api token as bearer token", func() {
+			grf := NewV5Client(ts.URL, "1234", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
+			So(auth, ShouldResemble, []string{"Bearer 1234"})
+		})
+
+		Convey("It should send basic auth for the proxy alongside the api token", func() {
+			grf := NewV5Client(ts.URL, "1234", url.Values{}, true, false, ClientOptions{BasicAuthUser: "user", BasicAuthPassword: "pass"})
+			grf.GetDashboard(context.Background(), "testDash")
+			So(auth, ShouldResemble, []string{"Bearer 1234"})
+			So(proxyAuth, ShouldResemble, []string{"Basic dXNlcjpwYXNz"})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(auth, ShouldResemble, []string{"Bearer 1234"})
+			So(proxyAuth, ShouldResemble, []string{"Basic dXNlcjpwYXNz"})
+		})
+
+		Convey("It should send basic auth in the configured header", func() {
+			var gatewayAuth []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Values("Authorization")
+				gatewayAuth = r.Header.Values("X-Gateway-Authorization")
+				fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+			}))
+			defer ts.Close()
+
+			grf := NewV5Client(ts.URL, "1234", url.Values{}, true, false, ClientOptions{BasicAuthUser: "user", BasicAuthPassword: "pass", BasicAuthHeader: "X-Gateway-Authorization"})
+			grf.GetDashboard(context.Background(), "testDash")
+			So(auth, ShouldResemble, []string{"Bearer 1234"})
+			So(gatewayAuth, ShouldResemble, []string{"Basic dXNlcjpwYXNz"})
+		})
+
+		Convey("It should send the organisation only when configured", func() {
+			var orgIDs []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				orgIDs = append(orgIDs, r.Header.Get("X-Grafana-Org-Id"))
+				_, present := r.Header["X-Grafana-Org-Id"]
+				if !present {
+					orgIDs[len(orgIDs)-1] = "absent"
+				}
+				fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+			}))
+			defer ts.Close()
+
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf = NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{OrgID: 3})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(orgIDs, ShouldResemble, []string{"absent", "3", "3"})
+		})
+
+		Convey("It should send the configured headers with every request", func() {
+			var users []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				users = append(users, r.Header.Get("X-Webauth-User"))
+				fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+			}))
+			defer ts.Close()
+
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{Headers: http.Header{"X-WEBAUTH-USER": {"svc"}}})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(users, ShouldResemble, []string{"svc", "svc"})
+		})
+
+		Convey("It should send the User-Agent with every request", func() {
+			var agents []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				agents = append(agents, r.Header.Values("User-Agent")...)
+				fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+			}))
+			defer ts.Close()
+
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			grf = NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{UserAgent: "grafana-reporter/2.3-0"})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(agents, ShouldResemble, []string{"grafana-reporter-go", "grafana-reporter/2.3-0", "grafana-reporter/2.3-0"})
+		})
+
+		Convey("It should send only basic auth without an api token", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{BasicAuthUser: "user", BasicAuthPassword: "pass"})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(auth, ShouldBeEmpty)
+			So(proxyAuth, ShouldResemble, []string{"Basic dXNlcjpwYXNz"})
+		})
+	})
+}
+
+// selfSignedCert creates a certificate and key for TLS tests, PEM encoded
+func selfSignedCert() (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reporter-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestGrafanaClientCertificate(t *testing.T) {
+	Convey("When Grafana requires a client certificate", t, func() {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+		}))
+		ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		ts.StartTLS()
+		defer ts.Close()
+
+		dir, err := ioutil.TempDir("", "certs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		certPEM, keyPEM := selfSignedCert()
+		certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+		So(ioutil.WriteFile(certFile, certPEM, 0600), ShouldBeNil)
+		So(ioutil.WriteFile(keyFile, keyPEM, 0600), ShouldBeNil)
+
+		Convey("Requests without one should fail", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, false, false, ClientOptions{})
+			_, err := grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Requests with the loaded certificate should succeed", func() {
+			cert, err := LoadClientCertificate(certFile, keyFile)
+			So(err, ShouldBeNil)
+			grf := NewV5Client(ts.URL, "", url.Values{}, false, false, ClientOptions{ClientCertificate: cert})
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			_, err = grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Loading a certificate that cannot be parsed should fail clearly", func() {
+			_, err := LoadClientCertificate(keyFile, keyFile)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "error loading client certificate")
+		})
+	})
+}
+
+func TestGrafanaClientCACertificates(t *testing.T) {
+	Convey("When Grafana has a certificate of a private CA", t, func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+		}))
+		defer ts.Close()
+
+		dir, err := ioutil.TempDir("", "certs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		caFile, otherCAFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "other.pem")
+		So(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600), ShouldBeNil)
+		otherCA, _ := selfSignedCert()
+		So(ioutil.WriteFile(otherCAFile, otherCA, 0600), ShouldBeNil)
+
+		Convey("Requests validated against the system's CAs should fail", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			_, err := grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Requests validated against its CA should succeed", func() {
+			pool, err := LoadCACertificates(caFile)
+			So(err, ShouldBeNil)
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RootCAs: pool})
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			_, err = grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Requests should be validated against the given CAs even without sslCheck", func() {
+			pool, err := LoadCACertificates(otherCAFile)
+			So(err, ShouldBeNil)
+			grf := NewV5Client(ts.URL, "", url.Values{}, false, false, ClientOptions{RootCAs: pool})
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Loading a file without certificates should fail clearly", func() {
+			So(ioutil.WriteFile(otherCAFile, []byte("not a certificate"), 0600), ShouldBeNil)
+			_, err := LoadCACertificates(otherCAFile)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no PEM encoded certificates")
+		})
+	})
+}
+
+func TestGrafanaClientProxy(t *testing.T) {
+	Convey("When requests go through a proxy", t, func() {
+		var proxied []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = append(proxied, r.URL.String())
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash"}}`)
+		}))
+		defer proxy.Close()
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		grf := NewV5Client("http://grafana.invalid", "", url.Values{}, true, false, ClientOptions{Proxy: proxyURL})
+		_, dashErr := grf.GetDashboard(context.Background(), "testDash")
+		_, panelErr := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+
+		Convey("Dashboard and render requests should be sent to the proxy", func() {
+			So(dashErr, ShouldBeNil)
+			So(panelErr, ShouldBeNil)
+			So(len(proxied), ShouldEqual, 2)
+			So(proxied[0], ShouldStartWith, "http://grafana.invalid/api/dashboards/")
+			So(proxied[1], ShouldStartWith, "http://grafana.invalid/render/d-solo/testDash")
+		})
+	})
+}
+
+func TestGrafanaClientConnectionReuse(t *testing.T) {
+	Convey("When making several requests with one client", t, func() {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"dashboard":{"title":"Test"},"meta":{"slug":"testDash"}}`)
+		}))
+		var mu sync.Mutex
+		newConns := 0
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				mu.Lock()
+				newConns++
+				mu.Unlock()
+			}
+		}
+		ts.Start()
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		for i := 0; i < 3; i++ {
+			_, err := grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			body, err := grf.GetPanelPng(context.Background(), Panel{Id: 1}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldBeNil)
+			ioutil.ReadAll(body)
+			body.Close()
+		}
+
+		Convey("It should reuse the connection to Grafana", func() {
+			mu.Lock()
+			defer mu.Unlock()
+			So(newConns, ShouldEqual, 1)
+		})
+
+		Convey("Another client with the same settings should reuse it too", func() {
+			grf := NewV5Client(ts.URL, "5678", url.Values{}, true, false, ClientOptions{})
+			_, err := grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			mu.Lock()
+			defer mu.Unlock()
+			So(newConns, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestGrafanaClientFetchesAnnotations(t *testing.T) {
+	Convey("When fetching annotations", t, func() {
+		var query url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			fmt.Fprintln(w, `[{"time":1453206447000,"timeEnd":1453206447000,"text":"Deploy v1.2","tags":["deploy"]}]`)
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		annotations, err := grf.GetAnnotations(context.Background(), "testDash", TimeRange{"1453206447000", "1453213647000"})
+
+		Convey("It should query the annotations of the dashboard within the time range", func() {
+			So(err, ShouldBeNil)
+			So(query.Get("dashboardUID"), ShouldEqual, "testDash")
+			So(query.Get("from"), ShouldEqual, "1453206447000")
+			So(query.Get("to"), ShouldEqual, "1453213647000")
+		})
+
+		Convey("It should return the annotations", func() {
+			So(annotations, ShouldResemble, []Annotation{{Time: 1453206447000, TimeEnd: 1453206447000, Text: "Deploy v1.2", Tags: []string{"deploy"}}})
+		})
+
+		Convey("It should fail for unrecognised time ranges", func() {
+			_, err := grf.GetAnnotations(context.Background(), "testDash", TimeRange{"yesterday", "now"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGrafanaClientFetchesPanelCSV(t *testing.T) {
+	Convey("When fetching the data of a panel as CSV", t, func() {
+		var path string
+		var query map[string]interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			json.NewDecoder(r.Body).Decode(&query)
+			fmt.Fprintln(w, `{"results":{
+				"B":{"frames":[{"schema":{"fields":[{"name":"host"},{"name":"load"}]},"data":{"values":[["web1","web2"],[0.5,null]]}}]},
+				"A":{"frames":[{"schema":{"fields":[{"name":"Time"},{"name":"Value"}]},"data":{"values":[[1453206447000,1453206507000],[1,2]]}}]}}}`)
+		}))
+		defer ts.Close()
+
+		panel := Panel{Id: 4, Type: "table",
+			Datasource: json.RawMessage(`{"uid":"prom"}`),
+			Targets:    []json.RawMessage{json.RawMessage(`{"refId":"A","expr":"up"}`), json.RawMessage(`{"refId":"B","datasource":{"uid":"other"}}`)}}
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		body, err := grf.GetPanelCSV(context.Background(), panel, "testDash", TimeRange{"1453206447000", "1453213647000"})
+
+		Convey("It should send the panel's queries to the datasource query API", func() {
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, "/api/ds/query")
+			So(query["from"], ShouldEqual, "1453206447000")
+			So(query["to"], ShouldEqual, "1453213647000")
+			queries := query["queries"].([]interface{})
+			So(queries, ShouldHaveLength, 2)
+			So(queries[0], ShouldResemble, map[string]interface{}{"refId": "A", "expr": "up", "datasource": map[string]interface{}{"uid": "prom"}})
+			So(queries[1].(map[string]interface{})["datasource"], ShouldResemble, map[string]interface{}{"uid": "other"})
+		})
+
+		Convey("It should return the frames as CSV, ordered by query", func() {
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(body)
+			So(string(data), ShouldEqual, "Time,Value\n1453206447000,1\n1453206507000,2\n\nhost,load\nweb1,0.5\nweb2,\n")
+		})
+
+		Convey("It should fail for panels without queries", func() {
+			_, err := grf.GetPanelCSV(context.Background(), Panel{Id: 5, Type: "text"}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGrafanaClientFetchesPanelValue(t *testing.T) {
+	Convey("When fetching the value of a stat panel", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"results":{
+				"A":{"frames":[{"schema":{"fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number"}]},"data":{"values":[[1453206447000,1453206507000,1453206567000],[4,2.5,null]]}}]}}}`)
+		}))
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		panel := Panel{Id: 2, Type: "stat", Targets: []json.RawMessage{json.RawMessage(`{"refId":"A"}`)}}
+		tr := TimeRange{"1453206447000", "1453213647000"}
+
+		Convey("It should reduce the numeric field to its last value by default", func() {
+			v, err := grf.GetPanelValue(context.Background(), panel, "testDash", tr)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 2.5)
+		})
+
+		Convey("It should use the calculation of the panel", func() {
+			panel.Options.ReduceOptions.Calcs = []string{"mean"}
+			v, err := grf.GetPanelValue(context.Background(), panel, "testDash", tr)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 3.25)
+		})
+
+		Convey("Singlestat panels should map their value name to a calculation", func() {
+			So(Panel{ValueName: "max"}.ReduceCalc(), ShouldEqual, "max")
+			So(Panel{ValueName: "total"}.ReduceCalc(), ShouldEqual, "sum")
+			So(Panel{}.ReduceCalc(), ShouldEqual, "lastNotNull")
+		})
+
+		Convey("Unsupported calculations should fail", func() {
+			panel.Options.ReduceOptions.Calcs = []string{"variance"}
+			_, err := grf.GetPanelValue(context.Background(), panel, "testDash", tr)
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
 
@@ -77,12 +628,12 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			client      Client
 			pngEndpoint string
 		}{
-			"v4": {NewV4Client(ts.URL, apiToken, variables, true, false), "/render/dashboard-solo/db/testDash"},
-			"v5": {NewV5Client(ts.URL, apiToken, variables, true, false), "/render/d-solo/testDash/_"},
+			"v4": {NewV4Client(ts.URL, apiToken, variables, true, false, ClientOptions{}), "/render/dashboard-solo/db/testDash"},
+			"v5": {NewV5Client(ts.URL, apiToken, variables, true, false, ClientOptions{}), "/render/d-solo/testDash?"},
 		}
 		for clientDesc, cl := range cases {
 			grf := cl.client
-			grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
 
 			Convey(fmt.Sprintf("The %s client should use the render endpoint with the dashboard name", clientDesc), func() {
 				So(requestURI, ShouldStartWith, cl.pngEndpoint)
@@ -106,19 +657,16 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 				So(requestURI, ShouldContainSubstring, "var-port=adapter")
 			})
 
-			Convey(fmt.Sprintf("The %s client should request singlestat panels at a smaller size", clientDesc), func() {
-				So(requestURI, ShouldContainSubstring, "width=300")
-				So(requestURI, ShouldContainSubstring, "height=150")
-			})
-
-			Convey(fmt.Sprintf("The %s client should request text panels with a small height", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "text", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
-				So(requestURI, ShouldContainSubstring, "width=1000")
-				So(requestURI, ShouldContainSubstring, "height=100")
+			Convey(fmt.Sprintf("The %s client should render repeated panels with their own variable values", clientDesc), func() {
+				repeat := Panel{Id: 45, RepeatPanelId: 44, Type: "graph", ScopedVars: url.Values{"var-host": {"web2"}}}
+				grf.GetPanelPng(context.Background(), repeat, "testDash", TimeRange{"now-1h", "now"})
+				So(requestURI, ShouldContainSubstring, "panelId=44")
+				So(requestURI, ShouldContainSubstring, "var-host=web2")
+				So(requestURI, ShouldNotContainSubstring, "var-host=servername")
+				So(requestURI, ShouldContainSubstring, "var-port=adapter")
 			})
 
-			Convey(fmt.Sprintf("The %s client should request other panels in a larger size", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+			Convey(fmt.Sprintf("The %s client should request panels at the default size", clientDesc), func() {
 				So(requestURI, ShouldContainSubstring, "width=1000")
 				So(requestURI, ShouldContainSubstring, "height=500")
 			})
@@ -128,25 +676,397 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			client      Client
 			pngEndpoint string
 		}{
-			"v4": {NewV4Client(ts.URL, apiToken, variables, true, true), "/render/dashboard-solo/db/testDash"},
-			"v5": {NewV5Client(ts.URL, apiToken, variables, true, true), "/render/d-solo/testDash/_"},
+			"v4": {NewV4Client(ts.URL, apiToken, variables, true, true, ClientOptions{}), "/render/dashboard-solo/db/testDash"},
+			"v5": {NewV5Client(ts.URL, apiToken, variables, true, true, ClientOptions{}), "/render/d-solo/testDash?"},
 		}
 		for clientDesc, cl := range casesGridLayout {
 			grf := cl.client
 
-			Convey(fmt.Sprintf("The %s client should request grid layout panels with width=1000 and height=240", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{6, 24, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+			Convey(fmt.Sprintf("The %s client should request grid layout panels with width=960 and height=240", clientDesc), func() {
+				grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{H: 6, W: 24}}, "testDash", TimeRange{"now", "now-1h"})
 				So(requestURI, ShouldContainSubstring, "width=960")
 				So(requestURI, ShouldContainSubstring, "height=240")
 			})
 
 			Convey(fmt.Sprintf("The %s client should request grid layout panels with width=480 and height=120", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{3, 12, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+				grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{H: 3, W: 12}}, "testDash", TimeRange{"now", "now-1h"})
 				So(requestURI, ShouldContainSubstring, "width=480")
 				So(requestURI, ShouldContainSubstring, "height=120")
 			})
+
+			Convey(fmt.Sprintf("The %s client should request panels without a grid position at the default size", clientDesc), func() {
+				grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph", Title: "title"}, "testDash", TimeRange{"now", "now-1h"})
+				So(requestURI, ShouldContainSubstring, "width=1000")
+				So(requestURI, ShouldContainSubstring, "height=500")
+			})
 		}
 
+		Convey("The grid unit size should be configurable", func() {
+			grf := NewV5Client(ts.URL, apiToken, variables, true, true, ClientOptions{GridUnitSize: 60})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{H: 6, W: 12}}, "testDash", TimeRange{"now", "now-1h"})
+			So(requestURI, ShouldContainSubstring, "width=720")
+			So(requestURI, ShouldContainSubstring, "height=360")
+		})
+	})
+}
+
+func TestGrafanaClientFetchesDashboardPNG(t *testing.T) {
+	Convey("When fetching the PNG of a whole dashboard", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+
+		variables := url.Values{"var-host": {"servername"}}
+		cases := map[string]struct {
+			client      Client
+			pngEndpoint string
+		}{
+			"v4": {NewV4Client(ts.URL, "", variables, true, false, ClientOptions{}), "/render/dashboard/db/testDash?"},
+			"v5": {NewV5Client(ts.URL, "", variables, true, false, ClientOptions{}), "/render/d/testDash?"},
+			"v9": {NewV9Client(ts.URL, "", variables, true, false, ClientOptions{}), "/render/d/testDash?"},
+		}
+		for clientDesc, cl := range cases {
+			_, err := cl.client.GetDashboardPng(context.Background(), "testDash", TimeRange{"now-1h", "now"})
+
+			Convey(fmt.Sprintf("The %s client should render the full dashboard page in kiosk mode", clientDesc), func() {
+				So(err, ShouldBeNil)
+				So(requestURI, ShouldStartWith, cl.pngEndpoint)
+				So(requestURI, ShouldNotContainSubstring, "panelId")
+				So(requestURI, ShouldContainSubstring, "width=1600")
+				So(requestURI, ShouldContainSubstring, "height=-1")
+				So(requestURI, ShouldContainSubstring, "kiosk")
+				So(requestURI, ShouldContainSubstring, "from=now-1h")
+				So(requestURI, ShouldContainSubstring, "var-host=servername")
+			})
+		}
+	})
+}
+
+func TestGrafanaClientRenderTimeout(t *testing.T) {
+	Convey("When fetching a panel PNG", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+
+		Convey("It should not send a renderer timeout by default", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldNotContainSubstring, "timeout=")
+		})
+
+		Convey("It should send the configured renderer timeout in seconds", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RenderTimeout: 90 * time.Second})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "timeout=90")
+		})
+
+		Convey("It should round the renderer timeout up to whole seconds", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RenderTimeout: 1500 * time.Millisecond})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "timeout=2&")
+			grf.GetDashboardPng(context.Background(), "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "timeout=2")
+		})
+
+		Convey("The http client should outlast a long renderer timeout", func() {
+			cl := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RenderTimeout: 300 * time.Second}).(*client)
+			So(cl.renderRequestTimeout(), ShouldBeGreaterThan, 300*time.Second)
+		})
+
+		Convey("The http client timeout should be configurable", func() {
+			cl := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{}).(*client)
+			So(cl.renderRequestTimeout(), ShouldEqual, 180*time.Second)
+			cl = NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RequestTimeout: 10 * time.Minute}).(*client)
+			So(cl.renderRequestTimeout(), ShouldEqual, 10*time.Minute)
+		})
+	})
+}
+
+func TestGrafanaClientRenderScale(t *testing.T) {
+	Convey("When fetching a panel PNG", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+
+		Convey("It should not send a scale by default", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldNotContainSubstring, "scale=")
+		})
+
+		Convey("It should send the configured scale", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RenderScale: 2.5})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "scale=2.5")
+		})
+	})
+}
+
+func TestGrafanaClientMultiValueVariables(t *testing.T) {
+	Convey("When fetching a panel PNG with multi-value variables", t, func() {
+		var query url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash","templating":{"list":[
+				{"name":"server","multi":true,"includeAll":true,"options":[{"text":"All","value":"$__all"},{"text":"a","value":"a"},{"text":"b","value":"b"}]},
+				{"name":"regex","options":[{"text":"x,y","value":"x,y"}]}]}}}`)
+		}))
+		defer ts.Close()
+
+		render := func(variables url.Values) {
+			grf := NewV5Client(ts.URL, "", variables, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+		}
+
+		Convey("It should split joined values into repeated parameters", func() {
+			render(url.Values{"var-server": {"a,b,c"}})
+			So(query["var-server"], ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		Convey("It should keep repeated values", func() {
+			render(url.Values{"var-server": {"a", "b"}})
+			So(query["var-server"], ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("It should expand All to the variable's options", func() {
+			render(url.Values{"var-server": {"$__all"}})
+			So(query["var-server"], ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("It should not split the value of a single-value variable", func() {
+			render(url.Values{"var-regex": {"x,y"}})
+			So(query["var-regex"], ShouldResemble, []string{"x,y"})
+		})
+	})
+}
+
+func TestGrafanaClientAdhocAndIntervalVariables(t *testing.T) {
+	Convey("When fetching a panel PNG of a dashboard with adhoc and interval variables", t, func() {
+		var query url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash","templating":{"list":[
+				{"name":"Filters","type":"adhoc","filters":[{"key":"host","operator":"=","value":"web1"},{"key":"dc","operator":"!=","value":"eu"}]},
+				{"name":"interval","type":"interval","current":{"text":"5m","value":"5m"}}]}}}`)
+		}))
+		defer ts.Close()
+
+		render := func(grf Client) {
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+		}
+
+		Convey("It should send the dashboard's filters and interval", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
+			render(grf)
+			So(query["var-Filters"], ShouldResemble, []string{"host|=|web1", "dc|!=|eu"})
+			So(query["var-interval"], ShouldResemble, []string{"5m"})
+		})
+
+		Convey("It should encode requested filters and keep the requested interval", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{"var-Filters": {"host=~web.*", "dc|=|us"}, "var-interval": {"1h"}}, true, false, ClientOptions{})
+			grf.GetDashboard(context.Background(), "testDash")
+			render(grf)
+			So(query["var-Filters"], ShouldResemble, []string{"host|=~|web.*", "dc|=|us"})
+			So(query["var-interval"], ShouldResemble, []string{"1h"})
+		})
+
+		Convey("It should use the variables given for a dashboard it did not fetch", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			SetTemplating(grf, []TemplateVariable{{Name: "Filters", Type: "adhoc", Filters: []AdhocFilter{{"job", "=", "api"}}}})
+			render(grf)
+			So(query["var-Filters"], ShouldResemble, []string{"job|=|api"})
+		})
+	})
+}
+
+func TestGrafanaClientTimezone(t *testing.T) {
+	Convey("When fetching a panel PNG", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+			fmt.Fprintln(w, `{"dashboard":{"uid":"testDash","timezone":"America/New_York"}}`)
+		}))
+		defer ts.Close()
+
+		Convey("It should render in UTC by default", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "tz=UTC")
+		})
+
+		Convey("It should render in the configured timezone", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{Timezone: "Europe/Berlin"})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "tz=Europe%2FBerlin")
+		})
+
+		Convey("It should render in the dashboard's timezone when asked to", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{Timezone: DashboardTimezone})
+			grf.GetDashboard(context.Background(), "testDash")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "tz=America%2FNew_York")
+		})
+	})
+}
+
+func TestGrafanaClientRenderSize(t *testing.T) {
+	Convey("When fetching a panel PNG with a configured render size", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+
+		opts := ClientOptions{RenderWidth: 1600, RenderHeight: 900, PanelSizes: map[int]RenderSize{7: {800, 1200}}}
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, opts)
+
+		Convey("It should request panels at the configured size", func() {
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=1600")
+			So(requestURI, ShouldContainSubstring, "height=900")
+		})
+
+		Convey("A per-panel size should override the configured size", func() {
+			grf.GetPanelPng(context.Background(), Panel{Id: 7, Type: "table"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=800")
+			So(requestURI, ShouldContainSubstring, "height=1200")
+		})
+
+		Convey("Dimensions that are not positive should fall back to the defaults", func() {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RenderWidth: -1, PanelSizes: map[int]RenderSize{7: {640, 0}}})
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=1000")
+			So(requestURI, ShouldContainSubstring, "height=500")
+			grf.GetPanelPng(context.Background(), Panel{Id: 7, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=640")
+			So(requestURI, ShouldContainSubstring, "height=500")
+		})
+	})
+}
+
+func TestGrafanaClientRenderKey(t *testing.T) {
+	Convey("When identifying the images a client renders", t, func() {
+		p := Panel{Id: 44, Type: "graph", GridPos: GridPos{H: 6, W: 12}}
+		tr := TimeRange{"now-1h", "now"}
+		key := func(apiToken string, gridLayout bool, opts ClientOptions) string {
+			return NewV5Client("http://grafana", apiToken, url.Values{}, true, gridLayout, opts).RenderKey(p, tr)
+		}
+		base := key("1234", false, ClientOptions{})
+
+		Convey("Clients with the same credentials and settings should share images", func() {
+			So(key("1234", false, ClientOptions{}), ShouldEqual, base)
+		})
+
+		Convey("Other credentials or organisations should not share images", func() {
+			So(key("5678", false, ClientOptions{}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{BasicAuthUser: "user"}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{OrgID: 2}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{Headers: http.Header{"X-Webauth-User": {"svc"}}}), ShouldNotEqual, base)
+		})
+
+		Convey("Images of another size, scale or timezone should not be shared", func() {
+			So(key("1234", true, ClientOptions{}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{RenderWidth: 1600}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{RenderScale: 2}), ShouldNotEqual, base)
+			So(key("1234", false, ClientOptions{Timezone: "Europe/Berlin"}), ShouldNotEqual, base)
+		})
+	})
+}
+
+func TestGrafanaClientTimeDensity(t *testing.T) {
+	Convey("When fetching a panel PNG with a time density of 2 pixels per minute", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+
+		opts := ClientOptions{TimeDensity: 2, PanelSizes: map[int]RenderSize{7: {800, 600}}}
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, opts)
+
+		Convey("The width of a time series panel should follow from the time range", func() {
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "timeseries"}, "testDash", TimeRange{"now-6h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=720")
+			So(requestURI, ShouldContainSubstring, "height=500")
+		})
+
+		Convey("The width should be clamped", func() {
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=500")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-7d", "now"})
+			So(requestURI, ShouldContainSubstring, "width=5000")
+		})
+
+		Convey("Panels without a time axis, unparseable ranges and per-panel sizes should be unaffected", func() {
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "stat"}, "testDash", TimeRange{"now-6h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=1000")
+			grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"yesterday", "now"})
+			So(requestURI, ShouldContainSubstring, "width=1000")
+			grf.GetPanelPng(context.Background(), Panel{Id: 7, Type: "graph"}, "testDash", TimeRange{"now-6h", "now"})
+			So(requestURI, ShouldContainSubstring, "width=800")
+		})
+	})
+}
+
+func TestGrafanaClientRetryDelay(t *testing.T) {
+	Convey("When retrying a render", t, func() {
+		grf := NewV5Client("", "", url.Values{}, true, false, ClientOptions{RetryBaseDelay: time.Second, RetryMaxDelay: 10 * time.Second}).(*client)
+
+		Convey("The delay should double per attempt with up to 50% jitter", func() {
+			for retry, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+				for i := 0; i < 20; i++ {
+					delay := grf.retryDelay(retry)
+					So(delay, ShouldBeGreaterThanOrEqualTo, base)
+					So(delay, ShouldBeLessThanOrEqualTo, base+base/2)
+				}
+			}
+		})
+
+		Convey("The delay should be capped", func() {
+			So(grf.retryDelay(4), ShouldBeLessThanOrEqualTo, 10*time.Second)
+			So(grf.retryDelay(4), ShouldBeGreaterThanOrEqualTo, 8*time.Second)
+			So(grf.retryDelay(100), ShouldEqual, 10*time.Second)
+		})
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	Convey("When parsing a Retry-After header", t, func() {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		Convey("It should accept seconds", func() {
+			wait, ok := parseRetryAfter("120", now)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 2*time.Minute)
+		})
+
+		Convey("It should accept an HTTP date", func() {
+			wait, ok := parseRetryAfter("Fri, 01 Mar 2024 12:00:30 GMT", now)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 30*time.Second)
+		})
+
+		Convey("It should not wait for dates in the past", func() {
+			wait, ok := parseRetryAfter("Fri, 01 Mar 2024 11:00:00 GMT", now)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 0)
+		})
+
+		Convey("It should reject missing and unrecognised values", func() {
+			for _, header := range []string{"", "soon", "-5"} {
+				_, ok := parseRetryAfter(header, now)
+				So(ok, ShouldBeFalse)
+			}
+		})
 	})
 }
 
@@ -167,27 +1087,229 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 		}))
 		defer ts.Close()
 
-		grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
+		grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
 
 		Convey("It should retry a couple of times if it receives errors", func() {
 			So(err, ShouldBeNil)
 		})
 	})
 
+	Convey("When the renderer is at its concurrency limit", t, func() {
+		tries := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			http.Error(w, "Rendering failed: concurrent limit reached", http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+
+		Convey("It should return ErrConcurrentRenderLimit without retrying itself", func() {
+			So(errors.Is(err, ErrConcurrentRenderLimit), ShouldBeTrue)
+			So(tries, ShouldEqual, 1)
+		})
+	})
+
+	Convey("When the renderer is rate limited", t, func() {
+		tries := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			if tries < 3 {
+				if tries == 1 {
+					w.Header().Set("Retry-After", "0")
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+
+		Convey("It should retry, with or without Retry-After", func() {
+			So(err, ShouldBeNil)
+			So(tries, ShouldEqual, 3)
+		})
+	})
+
+	Convey("When the renderer asks to retry after longer than the maximum retry delay", t, func() {
+		tries := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			if tries == 1 {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		defer ts.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{RetryMaxDelay: 10 * time.Millisecond})
+		_, err := grf.GetPanelPng(ctx, Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+
+		Convey("It should only wait the maximum retry delay", func() {
+			So(err, ShouldBeNil)
+			So(tries, ShouldEqual, 2)
+		})
+	})
+
 	Convey("When trying to fetching a panel from the server consistently returns an error", t, func() {
+		tries := 0
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
 			w.WriteHeader(http.StatusInternalServerError)
 		}))
 		defer ts.Close()
 
-		grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
+		grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
 
 		Convey("The Grafana API should return an error", func() {
 			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrRenderServer), ShouldBeTrue)
+		})
+
+		Convey("It should retry the default number of times", func() {
+			So(tries, ShouldEqual, 4)
+		})
+
+		Convey("It should retry as often as configured", func() {
+			tries = 0
+			grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{MaxRetries: 1})
+			_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldNotBeNil)
+			So(tries, ShouldEqual, 2)
+		})
+
+		Convey("It should stop retrying when the context is cancelled", func() {
+			tries = 0
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+			_, err := grf.GetPanelPng(ctx, Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			So(tries, ShouldEqual, 0)
+		})
+
+		Convey("It should not retry when retries are disabled", func() {
+			tries = 0
+			grf := NewV4Client(ts.URL, "", url.Values{}, true, false, ClientOptions{MaxRetries: -1})
+			_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{"now-1h", "now"})
+			So(err, ShouldNotBeNil)
+			So(tries, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestPing(t *testing.T) {
+	Convey("When pinging Grafana", t, func() {
+		status := http.StatusOK
+		var path, orgID string
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			orgID = r.Header.Get("X-Grafana-Org-Id")
+			w.WriteHeader(status)
+			fmt.Fprintln(w, `{"database":"ok"}`)
+		}))
+		var mu sync.Mutex
+		newConns := 0
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				mu.Lock()
+				newConns++
+				mu.Unlock()
+			}
+		}
+		ts.Start()
+		defer ts.Close()
+
+		Convey("A healthy Grafana should be reached at its health endpoint", func() {
+			So(Ping(context.Background(), ts.URL, true, ClientOptions{OrgID: 2}), ShouldBeNil)
+			So(path, ShouldEqual, "/api/health")
+			So(orgID, ShouldEqual, "2")
+		})
+
+		Convey("An unhealthy Grafana should be an error", func() {
+			status = http.StatusServiceUnavailable
+			So(Ping(context.Background(), ts.URL, true, ClientOptions{}), ShouldNotBeNil)
+		})
+
+		Convey("Repeated probes should reuse the connection", func() {
+			for i := 0; i < 3; i++ {
+				So(Ping(context.Background(), ts.URL, true, ClientOptions{}), ShouldBeNil)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			So(newConns, ShouldEqual, 1)
+		})
+
+		Convey("An unreachable Grafana should be an error", func() {
+			ts.Close()
+			So(Ping(context.Background(), ts.URL, true, ClientOptions{}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGrafanaClientErrors(t *testing.T) {
+	Convey("When Grafana refuses a request", t, func() {
+		status := http.StatusNotFound
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/search" {
+				fmt.Fprint(w, "[]")
+				return
+			}
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false, ClientOptions{})
+		getPanel := func() error {
+			_, err := grf.GetPanelPng(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"})
+			return err
+		}
+
+		Convey("A missing dashboard should be ErrDashboardNotFound", func() {
+			_, err := grf.GetDashboard(context.Background(), "testDash")
+			So(errors.Is(err, ErrDashboardNotFound), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "Status 404")
+			So(errors.Is(getPanel(), ErrDashboardNotFound), ShouldBeTrue)
+		})
+
+		Convey("A dashboard missing in its folder should be ErrDashboardNotFound", func() {
+			_, err := grf.GetDashboard(context.Background(), "Ops/Backend")
+			So(errors.Is(err, ErrDashboardNotFound), ShouldBeTrue)
+		})
+
+		Convey("A refused token should be ErrUnauthorized, with Grafana's status", func() {
+			for _, status = range []int{http.StatusUnauthorized, http.StatusForbidden} {
+				_, err := grf.GetDashboard(context.Background(), "testDash")
+				So(errors.Is(err, ErrUnauthorized), ShouldBeTrue)
+				var authErr *AuthError
+				So(errors.As(err, &authErr), ShouldBeTrue)
+				So(authErr.StatusCode, ShouldEqual, status)
+				err = getPanel()
+				So(errors.Is(err, ErrUnauthorized), ShouldBeTrue)
+				So(errors.As(err, &authErr), ShouldBeTrue)
+				So(authErr.StatusCode, ShouldEqual, status)
+			}
+		})
+
+		Convey("A renderer timing out should be ErrRenderTimeout", func() {
+			status = http.StatusGatewayTimeout
+			So(errors.Is(getPanel(), ErrRenderTimeout), ShouldBeTrue)
+		})
+
+		Convey("Other client errors should not match any of them", func() {
+			status = http.StatusBadRequest
+			err := getPanel()
+			So(err, ShouldNotBeNil)
+			for _, target := range []error{ErrDashboardNotFound, ErrUnauthorized, ErrRenderTimeout, ErrRenderServer} {
+				So(errors.Is(err, target), ShouldBeFalse)
+			}
 		})
 	})
 }
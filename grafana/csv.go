@@ -0,0 +1,192 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// queryRequest is the body of a request to Grafana's datasource query API
+type queryRequest struct {
+	Queries []map[string]interface{} `json:"queries"`
+	From    string                   `json:"from"`
+	To      string                   `json:"to"`
+}
+
+// queryResponse holds the data frames returned by the datasource query API, by query refId
+type queryResponse struct {
+	Results map[string]struct {
+		Error  string      `json:"error"`
+		Frames []dataFrame `json:"frames"`
+	} `json:"results"`
+}
+
+type dataFrame struct {
+	Schema struct {
+		Fields []struct {
+			Name string `json:"name"`
+			Type string `json:"type"` // e.g. "time", "number" or "string"
+		} `json:"fields"`
+	} `json:"schema"`
+	Data struct {
+		Values [][]interface{} `json:"values"` // One slice of values per field
+	} `json:"data"`
+}
+
+// GetPanelCSV queries the data of the panel within the time range and returns it as CSV.
+// Each data frame starts with a header row of its field names; frames are separated by an empty line.
+func (g *client) GetPanelCSV(ctx context.Context, p Panel, dashUID string, t TimeRange) (io.ReadCloser, error) {
+	result, err := g.queryPanel(ctx, p, dashUID, t)
+	if err != nil {
+		return nil, err
+	}
+	data, err := result.csv()
+	if err != nil {
+		return nil, fmt.Errorf("error writing data of panel %d as CSV: %w", p.Id, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// queryPanel runs the queries of the panel within the time range through the datasource query API
+func (g *client) queryPanel(ctx context.Context, p Panel, dashUID string, t TimeRange) (queryResponse, error) {
+	var result queryResponse
+	if len(p.Targets) == 0 {
+		return result, fmt.Errorf("error getting data of panel %d: panel has no queries", p.Id)
+	}
+	from, to, ok := t.Bounds()
+	if !ok {
+		return result, fmt.Errorf("error getting data of panel %d: unrecognised time range %v", p.Id, t)
+	}
+	query := queryRequest{
+		From: strconv.FormatInt(from.UnixNano()/int64(time.Millisecond), 10),
+		To:   strconv.FormatInt(to.UnixNano()/int64(time.Millisecond), 10),
+	}
+	for _, raw := range p.Targets {
+		var target map[string]interface{}
+		if err := json.Unmarshal(raw, &target); err != nil {
+			return result, fmt.Errorf("error parsing query of panel %d: %w", p.Id, err)
+		}
+		if _, ok := target["datasource"]; !ok && len(p.Datasource) > 0 {
+			target["datasource"] = p.Datasource // Queries use the panel's datasource unless they set their own
+		}
+		query.Queries = append(query.Queries, target)
+	}
+	reqBody, err := json.Marshal(query)
+	if err != nil {
+		return result, fmt.Errorf("error creating query of panel %d: %w", p.Id, err)
+	}
+
+	queryURL := g.url + "/api/ds/query"
+	slog.Debug("Querying panel data", "panel", p.Id, "title", p.Title, "dashboard", dashUID, "url", queryURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", queryURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return result, fmt.Errorf("error creating query request for %v: %w", queryURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	g.addHeaders(req)
+
+	resp, err := g.apiClient.Do(req)
+	if err != nil {
+		return result, fmt.Errorf("error executing query request for %v: %w", queryURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return result, fmt.Errorf("error reading query response body for %v: %w", queryURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("error querying data of panel %d: Status %d, Body: %s", p.Id, resp.StatusCode, limitString(string(body), 500))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Keep numbers as sent, e.g. ms timestamps would otherwise print in exponent form
+	if err := dec.Decode(&result); err != nil {
+		return result, fmt.Errorf("error unmarshaling query response JSON from %v: %w", queryURL, err)
+	}
+	return result, nil
+}
+
+// csv writes the frames of all results, ordered by refId
+func (r queryResponse) csv() ([]byte, error) {
+	refIDs := make([]string, 0, len(r.Results))
+	for refID := range r.Results {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	first := true
+	for _, refID := range refIDs {
+		result := r.Results[refID]
+		if result.Error != "" {
+			return nil, fmt.Errorf("query %s failed: %s", refID, result.Error)
+		}
+		for _, frame := range result.Frames {
+			if !first {
+				w.Write(nil)
+			}
+			first = false
+			if err := frame.writeCSV(w); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// writeCSV writes the header row and the rows of the frame
+func (f dataFrame) writeCSV(w *csv.Writer) error {
+	header := make([]string, len(f.Schema.Fields))
+	for i, field := range f.Schema.Fields {
+		header[i] = field.Name
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	rows := 0
+	for _, values := range f.Data.Values {
+		if len(values) > rows {
+			rows = len(values)
+		}
+	}
+	for row := 0; row < rows; row++ {
+		record := make([]string, len(f.Data.Values))
+		for i, values := range f.Data.Values {
+			if row < len(values) && values[row] != nil {
+				record[i] = fmt.Sprint(values[row])
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,10 +18,14 @@ package grafana
 
 import (
 	"encoding/json" // Keep for unmarshaling panel/row JSON if needed later
-	"log"
+	"fmt"
+	"log/slog"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings" // Keep for getVariablesValues and sanitizeLaTexInput
+	"unicode"
+	"unicode/utf8"
 )
 
 // --- Panel Type Enum (Keep as is) ---
@@ -33,6 +37,11 @@ const (
 	Graph
 	Table
 	Row // Keep Row type for identification
+	// Panel types of Grafana 9 and later
+	Timeseries
+	Stat
+	BarChart
+	Gauge
 )
 
 func (p PanelType) string() string {
@@ -42,6 +51,10 @@ func (p PanelType) string() string {
 		"graph",
 		"table",
 		"row", // Added "row" representation
+		"timeseries",
+		"stat",
+		"barchart",
+		"gauge",
 	}[p]
 }
 
@@ -66,6 +79,7 @@ type Dashboard struct {
 	Title       string            `json:"title"`
 	Description string            `json:"description"` // Added Description field
 	Uid         string            `json:"uid"`
+	Tags        []string          `json:"tags"`
 	Time        Time              `json:"time"`
 	Templating  Templating        `json:"templating"`
 	Timezone    string            `json:"timezone"`
@@ -74,6 +88,8 @@ type Dashboard struct {
 	// Internal fields to store processed panels/rows
 	processedPanels []Panel
 	processedRows   []GrafanaRow
+	panelSections   map[int]int // By panel id, the number of rows above the panel, counting from 1
+	variables       url.Values  // Variables requested for the report, these select the values of repeated panels
 }
 
 // Time represents the dashboard's default time range
@@ -89,24 +105,32 @@ type Templating struct {
 
 // TemplateVariable represents a single dashboard variable
 type TemplateVariable struct {
-	Name       string      `json:"name"`
-	Label      string      `json:"label"`    // Display name
-	Type       string      `json:"type"`     // e.g., query, custom, interval
-	Current    CurrentVal  `json:"current"`  // Selected value(s)
-	Options    []OptionVal `json:"options"`  // Available options
-	Multi      bool        `json:"multi"`    // Allow multiple selections?
-	IncludeAll bool        `json:"includeAll"`// Has 'All' option?
-	Hide       int         `json:"hide"`     // 0=visible, 1=label only, 2=hidden
+	Name       string        `json:"name"`
+	Label      string        `json:"label"`      // Display name
+	Type       string        `json:"type"`       // e.g., query, custom, interval, adhoc
+	Current    CurrentVal    `json:"current"`    // Selected value(s)
+	Options    []OptionVal   `json:"options"`    // Available options
+	Multi      bool          `json:"multi"`      // Allow multiple selections?
+	IncludeAll bool          `json:"includeAll"` // Has 'All' option?
+	Hide       int           `json:"hide"`       // 0=visible, 1=label only, 2=hidden
+	Filters    []AdhocFilter `json:"filters"`    // Filters of adhoc variables
 	// Add other fields like 'query', 'datasource' if needed
 }
 
 // CurrentVal holds the currently selected value(s) for a template variable
 type CurrentVal struct {
 	Text  interface{} `json:"text"`  // Can be string or []string/[]interface{}
-	Value string      `json:"value"` // Can be string or JSON array string '["val1","val2"]'
+	Value interface{} `json:"value"` // Can be string, []interface{} or JSON array string '["val1","val2"]'
 	// Add 'tags' or other fields if necessary
 }
 
+// AdhocFilter is one filter of an adhoc filters variable, e.g. host = web1
+type AdhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
 // OptionVal represents one available option for a template variable
 type OptionVal struct {
 	Text     string `json:"text"`
@@ -116,10 +140,40 @@ type OptionVal struct {
 
 // Panel represents common fields for Grafana panels (including rows)
 type Panel struct {
-	Id      int     `json:"id"`
-	Type    string  `json:"type"` // "row", "graph", "singlestat", etc.
-	Title   string  `json:"title"`
-	GridPos GridPos `json:"gridPos"`
+	Id          int      `json:"id"`
+	Type        string   `json:"type"` // "row", "graph", "singlestat", etc.
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags,omitempty"`
+	GridPos     GridPos  `json:"gridPos"`
+
+	// Set on panels that are defined by a library panel
+	LibraryPanel *LibraryPanelRef `json:"libraryPanel,omitempty"`
+
+	// Repeating the panel per value of a variable
+	Repeat          string     `json:"repeat,omitempty"`          // Name of the variable
+	RepeatDirection string     `json:"repeatDirection,omitempty"` // "h" (default) or "v"
+	MaxPerRow       int        `json:"maxPerRow,omitempty"`
+	RepeatPanelId   int        `json:"repeatPanelId,omitempty"` // Set on repeated panels, the id of the panel they repeat
+	ScopedVars      url.Values `json:"-"`                       // Variable values of a repeated panel, e.g. var-server=web1
+
+	// Time range overrides of the panel, e.g. "2h" and "1d"
+	TimeFrom  string `json:"timeFrom,omitempty"`
+	TimeShift string `json:"timeShift,omitempty"`
+
+	// Field configuration (Grafana v7+), used for thresholds of stat/gauge panels
+	FieldConfig FieldConfig `json:"fieldConfig"`
+
+	// Options of text and stat panels. Text panels of Grafana before v7 have their content on
+	// the panel, and singlestat panels the calculation of their value.
+	Options   PanelOptions `json:"options"`
+	Content   string       `json:"content,omitempty"`
+	Mode      string       `json:"mode,omitempty"`      // "markdown" (the default) or "html"
+	ValueName string       `json:"valueName,omitempty"` // e.g. "current" or "avg"
+
+	// Queries of the panel, kept as JSON to pass them on to the datasource query API
+	Datasource json.RawMessage   `json:"datasource,omitempty"`
+	Targets    []json.RawMessage `json:"targets,omitempty"`
 
 	// Fields specific to 'row' type panels:
 	Collapsed bool              `json:"collapsed,omitempty"`
@@ -129,6 +183,52 @@ type Panel struct {
 	ContentPanels []Panel `json:"-"` // Use json:"-" to prevent marshalling loops
 }
 
+// FieldConfig holds the field options of a panel. Only the defaults are used.
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+// FieldDefaults holds the default field options of a panel
+type FieldDefaults struct {
+	Unit       string     `json:"unit"`
+	Decimals   *int       `json:"decimals"`
+	Thresholds Thresholds `json:"thresholds"`
+}
+
+// Thresholds defines the colors a panel uses for value ranges
+type Thresholds struct {
+	Mode  string          `json:"mode"` // "absolute" or "percentage"
+	Steps []ThresholdStep `json:"steps"`
+}
+
+// ThresholdStep is a color that applies from Value upwards. The base step has no Value.
+type ThresholdStep struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"`
+}
+
+// PanelOptions holds the options of text and stat panels. Other options are ignored.
+type PanelOptions struct {
+	Content       string        `json:"content,omitempty"`
+	Mode          string        `json:"mode,omitempty"`
+	ReduceOptions ReduceOptions `json:"reduceOptions"`
+}
+
+// ReduceOptions selects how stat panels reduce a series to one value
+type ReduceOptions struct {
+	Calcs []string `json:"calcs"` // e.g. ["lastNotNull"]; only the first is used
+}
+
+// UnmarshalJSON ignores options that are not an object, as some old panel types have
+func (o *PanelOptions) UnmarshalJSON(data []byte) error {
+	type plain PanelOptions
+	var opts plain
+	if json.Unmarshal(data, &opts) == nil {
+		*o = PanelOptions(opts)
+	}
+	return nil
+}
+
 // GridPos represents position and size in the Grafana grid
 type GridPos struct {
 	H float64 `json:"h"`
@@ -148,6 +248,29 @@ type GrafanaRow struct {
 
 // --- Helper Functions ---
 
+// ParseDashboard creates a Dashboard from JSON, either as returned by the Grafana dashboard API
+// ({"dashboard": {...}, "meta": {...}}) or as the bare dashboard model exported from Grafana.
+func ParseDashboard(dashJSON []byte) (Dashboard, error) {
+	var wrapped struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+	}
+	err := json.Unmarshal(dashJSON, &wrapped)
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error unmarshaling dashboard JSON: %w", err)
+	}
+	if wrapped.Dashboard != nil {
+		dashJSON = wrapped.Dashboard
+	}
+
+	var dash Dashboard
+	err = json.Unmarshal(dashJSON, &dash)
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error unmarshaling dashboard JSON: %w", err)
+	}
+	dash.processPanelsAndRows()
+	return dash, nil
+}
+
 // processPanelsAndRows extracts Panels and Rows from the raw JSON messages
 // This should be called after unmarshaling into FullDashboard
 func (d *Dashboard) processPanelsAndRows() {
@@ -155,85 +278,126 @@ func (d *Dashboard) processPanelsAndRows() {
 		return // Already processed
 	}
 
-	var allPanels []Panel
 	panelSource := d.Panels // Use Panels field (Grafana v5+)
+	legacyRows := false
 
 	// Fallback to Rows field if Panels is empty (older Grafana versions)
 	if len(panelSource) == 0 && len(d.Rows) > 0 {
-		log.Println("Using deprecated 'rows' field for panel data.")
+		slog.Debug("Using deprecated 'rows' field for panel data")
 		panelSource = d.Rows
+		legacyRows = true
 	}
 
-	log.Printf("Processing %d raw panel/row entries...", len(panelSource))
-	currentY := -1.0 // Track Y coordinate to group panels into implicit rows if needed
-	var currentRowPanels []Panel
-	var explicitRows []GrafanaRow
-
+	slog.Debug("Processing panel and row entries", "count", len(panelSource))
+	var topLevel []Panel
 	for _, raw := range panelSource {
 		var p Panel
 		err := json.Unmarshal(raw, &p)
 		if err != nil {
-			log.Printf("Warning: Skipping panel/row - Error unmarshaling panel JSON: %v. JSON: %s", err, limitString(string(raw), 100))
+			slog.Warn("Skipping panel or row that cannot be unmarshaled", "error", err, "json", limitString(string(raw), 100))
 			continue
 		}
-
+		if legacyRows {
+			p.Type = "row" // v4 rows have no type, but nest their panels like v5 rows do
+		}
 		if p.Type == "row" {
-			log.Printf("Processing Row: %s (ID: %d)", p.Title, p.Id)
-			// Process nested panels within the row
-			var nestedPanels []Panel
+			// Process nested panels within the row. Grafana nests them in collapsed rows and v4 rows.
 			for _, nestedRaw := range p.Panels {
 				var nestedP Panel
 				err := json.Unmarshal(nestedRaw, &nestedP)
 				if err != nil {
-					log.Printf("Warning: Skipping nested panel in row %d - Error unmarshaling: %v. JSON: %s", p.Id, err, limitString(string(nestedRaw), 100))
+					slog.Warn("Skipping nested panel that cannot be unmarshaled", "row", p.Id, "error", err, "json", limitString(string(nestedRaw), 100))
 					continue
 				}
-				// Assign Y coordinate relative to row if needed, though GridPos usually handles it
-				nestedPanels = append(nestedPanels, nestedP)
-				allPanels = append(allPanels, nestedP) // Also add to the flat list
+				p.ContentPanels = append(p.ContentPanels, nestedP)
+			}
+		}
+		topLevel = append(topLevel, p)
+	}
+	sortByGridPos(topLevel)
+	nextID := maxPanelID(topLevel)
+
+	// Panels are ordered by the section of the dashboard they are in, i.e. the row above them, and
+	// then by position. The panels of a collapsed row keep the positions they have when the row is
+	// expanded, so they would otherwise sort among the panels of the rows below it.
+	type placedPanel struct {
+		Panel
+		section int
+	}
+	var placed []placedPanel
+	var explicitRows []GrafanaRow
+	section := 0
+
+	for _, p := range topLevel {
+		if p.Type == "row" {
+			section++
+			slog.Debug("Processing row", "row", p.Id, "title", p.Title, "collapsed", p.Collapsed)
+			nestedPanels := d.repeatPanels(p.ContentPanels, &nextID)
+			sortByGridPos(nestedPanels)
+			for _, nestedP := range nestedPanels {
+				placed = append(placed, placedPanel{nestedP, section}) // Also add to the flat list
 			}
-			p.ContentPanels = nestedPanels // Store processed nested panels internally
 
 			// Create a structured GrafanaRow
 			explicitRows = append(explicitRows, GrafanaRow{
 				Id:            p.Id,
 				Title:         p.Title,
-				Showtitle:     !p.Collapsed, // Consider collapsed rows as not showing title prominently
+				Showtitle:     p.Title != "", // Collapsing a row hides its panels in Grafana, not its title
 				ContentPanels: nestedPanels,
 				GridPos:       p.GridPos,
 			})
 		} else {
-			// Regular panel
-			allPanels = append(allPanels, p)
-
-			// Group panels by Y coordinate for implicit row generation if needed (less common now)
-			if int(p.GridPos.Y) != int(currentY) {
-				// Start of a new potential implicit row
-				currentY = p.GridPos.Y
-				currentRowPanels = []Panel{}
+			// Regular panel, or one per value of the variable it repeats by
+			for _, repeated := range d.repeatPanels([]Panel{p}, &nextID) {
+				placed = append(placed, placedPanel{repeated, section})
 			}
-			currentRowPanels = append(currentRowPanels, p)
 		}
 	}
 
-	// Sort panels and rows primarily by Y coordinate, then X
-	sort.SliceStable(allPanels, func(i, j int) bool {
-		if allPanels[i].GridPos.Y != allPanels[j].GridPos.Y {
-			return allPanels[i].GridPos.Y < allPanels[j].GridPos.Y
+	sort.SliceStable(placed, func(i, j int) bool {
+		if placed[i].section != placed[j].section {
+			return placed[i].section < placed[j].section
 		}
-		return allPanels[i].GridPos.X < allPanels[j].GridPos.X
-	})
-	sort.SliceStable(explicitRows, func(i, j int) bool {
-		// Use row's GridPos.Y for sorting
-		if explicitRows[i].GridPos.Y != explicitRows[j].GridPos.Y {
-			return explicitRows[i].GridPos.Y < explicitRows[j].GridPos.Y
+		if placed[i].GridPos.Y != placed[j].GridPos.Y {
+			return placed[i].GridPos.Y < placed[j].GridPos.Y
 		}
-		return explicitRows[i].GridPos.X < explicitRows[j].GridPos.X
+		return placed[i].GridPos.X < placed[j].GridPos.X
 	})
+	allPanels := make([]Panel, len(placed))
+	d.panelSections = make(map[int]int, len(placed))
+	for i, p := range placed {
+		allPanels[i] = p.Panel
+		d.panelSections[p.Id] = p.section
+	}
 
 	d.processedPanels = allPanels
 	d.processedRows = explicitRows // Store the processed rows
-	log.Printf("Finished processing: %d panels, %d explicit rows identified.", len(d.processedPanels), len(d.processedRows))
+	slog.Debug("Finished processing panels", "panels", len(d.processedPanels), "rows", len(d.processedRows))
+}
+
+// sortByGridPos sorts panels by their position on the dashboard, top to bottom, then left to right
+func sortByGridPos(panels []Panel) {
+	sort.SliceStable(panels, func(i, j int) bool {
+		if panels[i].GridPos.Y != panels[j].GridPos.Y {
+			return panels[i].GridPos.Y < panels[j].GridPos.Y
+		}
+		return panels[i].GridPos.X < panels[j].GridPos.X
+	})
+}
+
+// RenderTimezone is the IANA zone the dashboard is displayed in. Grafana's "browser" setting
+// has no meaning when rendering server side, so it, like "utc" and an unset zone, gives UTC.
+func (d Dashboard) RenderTimezone() string {
+	switch d.Timezone {
+	case "", "browser", "utc":
+		return "UTC"
+	}
+	return d.Timezone
+}
+
+// Variables are the variable values requested for the report, e.g. var-server=web1
+func (d Dashboard) Variables() url.Values {
+	return d.variables
 }
 
 // GetGridPanels returns panels suitable for grid layout (non-row panels)
@@ -249,6 +413,49 @@ func (d *Dashboard) GetGridPanels() []Panel {
 	return gridPanels
 }
 
+// FilterPanels is a copy of the dashboard with only the panels that keep returns true for, in
+// both the grid panels and the rows
+func (d Dashboard) FilterPanels(keep func(Panel) bool) Dashboard {
+	d.processPanelsAndRows()
+	var panels []Panel
+	for _, p := range d.processedPanels {
+		if keep(p) {
+			panels = append(panels, p)
+		}
+	}
+	rows := make([]GrafanaRow, len(d.processedRows))
+	for i, r := range d.processedRows {
+		rows[i] = r
+		rows[i].ContentPanels = nil
+		for _, p := range r.ContentPanels {
+			if keep(p) {
+				rows[i].ContentPanels = append(rows[i].ContentPanels, p)
+			}
+		}
+	}
+	d.processedPanels = panels
+	d.processedRows = rows
+	return d
+}
+
+// RowStarts maps the first grid panel below each titled row to the row, by panel id, to mark
+// where the sections of the dashboard start in grid layout reports
+func (d *Dashboard) RowStarts() map[int]*GrafanaRow {
+	d.processPanelsAndRows()
+	starts := map[int]*GrafanaRow{}
+	last := 0
+	for _, p := range d.GetGridPanels() {
+		section := d.panelSections[p.Id]
+		if section != last && section > 0 && section <= len(d.processedRows) {
+			if row := d.processedRows[section-1]; row.Title != "" {
+				starts[p.Id] = &row
+			}
+		}
+		last = section
+	}
+	return starts
+}
+
 // GetRows returns processed rows suitable for row layout.
 // It ensures panels/rows are processed first.
 func (d *Dashboard) GetRows() []GrafanaRow {
@@ -258,8 +465,50 @@ func (d *Dashboard) GetRows() []GrafanaRow {
 
 // --- Methods for Panel and GrafanaRow (Keep existing ones) ---
 
+// IsSingleStat is true for panels showing a single value: the singlestat panel and the stat
+// and gauge panels that replace it since Grafana 7
 func (p Panel) IsSingleStat() bool {
-	return p.Is(SingleStat)
+	return p.Is(SingleStat) || p.Is(Stat) || p.Is(Gauge)
+}
+
+// IsText is true for text panels, whose content is written into the report instead of rendered
+func (p Panel) IsText() bool {
+	return p.Is(Text)
+}
+
+// IsRenderable is true for panels that are rendered to an image, i.e. all but rows and text panels
+func (p Panel) IsRenderable() bool {
+	return !p.Is(Row) && !p.Is(Text)
+}
+
+// TextContent is the content of a text panel and its mode, "markdown" or "html"
+func (p Panel) TextContent() (content, mode string) {
+	content, mode = p.Options.Content, p.Options.Mode
+	if content == "" {
+		content, mode = p.Content, p.Mode
+	}
+	if mode == "" {
+		mode = "markdown"
+	}
+	return content, mode
+}
+
+// HasThresholds is true for stat-like panels that define more than the base threshold step
+func (p Panel) HasThresholds() bool {
+	switch p.Type {
+	case "singlestat", "stat", "gauge", "bargauge":
+		return len(p.FieldConfig.Defaults.Thresholds.Steps) > 1
+	}
+	return false
+}
+
+// HasTimeAxis is true for panels that plot values along a time axis
+func (p Panel) HasTimeAxis() bool {
+	switch p.Type {
+	case "graph", "timeseries", "heatmap", "state-timeline", "status-history", "candlestick":
+		return true
+	}
+	return false
 }
 
 func (p Panel) IsPartialWidth() bool {
@@ -296,28 +545,116 @@ func getVariablesValues(variables url.Values) string {
 	return strings.Join(values, ", ")
 }
 
-// SanitizeLaTexInput escapes characters problematic for LaTeX
+// latexReplacer escapes the characters LaTeX gives a meaning, in a single pass so that the
+// escapes are not escaped again. Without T1 font encoding < and > typeset as inverted
+// exclamation and question marks, so they get text commands as well. Brackets are braced so
+// that text after a command or line break is not taken as its optional argument; they are
+// not the template's [[ ]] delimiters once the template has been executed.
+var latexReplacer = strings.NewReplacer(
+	"\\", "\\textbackslash{}",
+	"&", "\\&",
+	"%", "\\%",
+	"$", "\\$",
+	"#", "\\#",
+	"_", "\\_",
+	"{", "\\{",
+	"}", "\\}",
+	"~", "\\textasciitilde{}",
+	"^", "\\textasciicircum{}",
+	"<", "\\textless{}",
+	">", "\\textgreater{}",
+	"|", "\\textbar{}",
+	"[", "{[}",
+	"]", "{]}",
+)
+
+// SanitizeLaTexInput escapes characters problematic for LaTeX. Percent-encoded UTF-8, such as
+// caf%C3%A9 from a URL that was encoded twice, is decoded first. Emoji, which pdflatex cannot
+// typeset and the default fonts lack, are dropped; other symbols are kept.
 func SanitizeLaTexInput(input string) string {
-	// Use a temporary placeholder for backslashes to avoid double escaping
-	placeholder := "___TEMP_BACKSLASH___"
-	input = strings.ReplaceAll(input, "\\", placeholder)
-
-	// Escape other special characters
-	input = strings.ReplaceAll(input, "&", "\\&")
-	input = strings.ReplaceAll(input, "%", "\\%")
-	input = strings.ReplaceAll(input, "$", "\\$")
-	input = strings.ReplaceAll(input, "#", "\\#")
-	input = strings.ReplaceAll(input, "_", "\\_")
-	input = strings.ReplaceAll(input, "{", "\\{")
-	input = strings.ReplaceAll(input, "}", "\\}")
-	input = strings.ReplaceAll(input, "~", "\\textasciitilde{}") // Requires textcomp package? Or use verb
-	input = strings.ReplaceAll(input, "^", "\\textasciicircum{}") // Requires textcomp package? Or use verb
-
-	// Replace the placeholder with the LaTeX command for backslash
-	// Ensure space after command if needed depending on context, but usually safe this way
-	input = strings.ReplaceAll(input, placeholder, "\\textbackslash{}")
-
-	return input
+	return latexReplacer.Replace(dropEmoji(decodePercentUTF8(input)))
+}
+
+// decodePercentUTF8 decodes runs of %XX escapes that spell non-ASCII UTF-8 characters. Other
+// percent signs, e.g. of "100%" or "%20", are left as they are.
+func decodePercentUTF8(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		var raw []byte
+		j := i
+		for j+2 < len(s) && s[j] == '%' {
+			c, err := strconv.ParseUint(s[j+1:j+3], 16, 8)
+			if err != nil || c < utf8.RuneSelf {
+				break
+			}
+			raw = append(raw, byte(c))
+			j += 3
+		}
+		if len(raw) > 0 && utf8.Valid(raw) {
+			b.Write(raw)
+			i = j
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// dropEmoji removes emoji, including those made of a symbol and the emoji variation selector
+// and sequences joined by zero width joiners
+func dropEmoji(s string) string {
+	runes := []rune(s)
+	kept := runes[:0]
+	for i, r := range runes {
+		emojiStyle := i+1 < len(runes) && runes[i+1] == 0xFE0F
+		if !isEmoji(r) && !emojiStyle {
+			kept = append(kept, r)
+		}
+	}
+	return string(kept)
+}
+
+// isEmoji tells whether r is shown as emoji by default, or only makes sense in emoji sequences
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F1E6 && r <= 0x1F1FF, // Regional indicators of flags
+		r >= 0x1F300 && r <= 0x1F64F, // Pictographs, skin tones and emoticons
+		r >= 0x1F680 && r <= 0x1F6FF, // Transport and map symbols
+		r >= 0x1F7E0 && r <= 0x1F7EB, // Colored circles and squares
+		r >= 0x1F900 && r <= 0x1F9FF, // Supplemental pictographs
+		r >= 0x1FA70 && r <= 0x1FAFF, // Pictographs extended-A
+		r >= 0xE0020 && r <= 0xE007F, // Tags of subdivision flags
+		r == 0xFE0E || r == 0xFE0F,   // Text and emoji variation selectors
+		r == 0x200D,                  // Zero width joiner of emoji sequences
+		r == 0x20E3:                  // Keycap
+		return true
+	}
+	return unicode.Is(emojiPresentation, r)
+}
+
+// emojiPresentation are the symbols below the supplementary pictographs that are shown as emoji
+// without a variation selector, e.g. ⌚ and ⚡ but not ✓
+var emojiPresentation = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x231A, 0x231B, 1}, {0x23E9, 0x23EC, 1}, {0x23F0, 0x23F0, 1}, {0x23F3, 0x23F3, 1},
+		{0x25FD, 0x25FE, 1}, {0x2614, 0x2615, 1}, {0x2648, 0x2653, 1}, {0x267F, 0x267F, 1},
+		{0x2693, 0x2693, 1}, {0x26A1, 0x26A1, 1}, {0x26AA, 0x26AB, 1}, {0x26BD, 0x26BE, 1},
+		{0x26C4, 0x26C5, 1}, {0x26CE, 0x26CE, 1}, {0x26D4, 0x26D4, 1}, {0x26EA, 0x26EA, 1},
+		{0x26F2, 0x26F3, 1}, {0x26F5, 0x26F5, 1}, {0x26FA, 0x26FA, 1}, {0x26FD, 0x26FD, 1},
+		{0x2705, 0x2705, 1}, {0x270A, 0x270B, 1}, {0x2728, 0x2728, 1}, {0x274C, 0x274C, 1},
+		{0x274E, 0x274E, 1}, {0x2753, 0x2755, 1}, {0x2757, 0x2757, 1}, {0x2795, 0x2797, 1},
+		{0x27B0, 0x27B0, 1}, {0x27BF, 0x27BF, 1}, {0x2B1B, 0x2B1C, 1}, {0x2B50, 0x2B50, 1},
+		{0x2B55, 0x2B55, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1F004, 0x1F004, 1}, {0x1F0CF, 0x1F0CF, 1}, {0x1F18E, 0x1F18E, 1}, {0x1F191, 0x1F19A, 1},
+		{0x1F201, 0x1F201, 1}, {0x1F21A, 0x1F21A, 1}, {0x1F22F, 0x1F22F, 1}, {0x1F232, 0x1F236, 1},
+		{0x1F238, 0x1F23A, 1}, {0x1F250, 0x1F251, 1},
+	},
 }
 
 // Helper to limit string length for logging (already defined in api.go, but keep here for potential use within package)
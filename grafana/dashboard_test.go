@@ -17,12 +17,24 @@
 package grafana
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/url"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func parseDashboard(dashJSON string) Dashboard {
+	var fullDash FullDashboard
+	err := json.Unmarshal([]byte(dashJSON), &fullDash)
+	So(err, ShouldBeNil)
+	fullDash.Dashboard.processPanelsAndRows()
+	return fullDash.Dashboard
+}
+
 func TestV4Dashboard(t *testing.T) {
 	Convey("When creating a new dashboard from Grafana v4 dashboard JSON", t, func() {
 		const v4DashJSON = `
@@ -43,35 +55,42 @@ func TestV4Dashboard(t *testing.T) {
 "Meta":
 	{"Slug":"testDash"}
 }`
-		dash := NewDashboard([]byte(v4DashJSON), url.Values{})
+		dash := parseDashboard(v4DashJSON)
+		panels := dash.GetGridPanels()
+		rows := dash.GetRows()
 
 		Convey("Panel Is(type) should work for all panels", func() {
-			So(dash.Panels[0].Is(Graph), ShouldBeFalse)
-			So(dash.Panels[0].Is(Text), ShouldBeFalse)
-			So(dash.Panels[0].Is(Table), ShouldBeFalse)
-			So(dash.Panels[0].Is(SingleStat), ShouldBeTrue)
-			So(dash.Panels[1].Is(Graph), ShouldBeTrue)
-			So(dash.Panels[2].Is(SingleStat), ShouldBeTrue)
+			So(panels[0].Is(Graph), ShouldBeFalse)
+			So(panels[0].Is(Text), ShouldBeFalse)
+			So(panels[0].Is(Table), ShouldBeFalse)
+			So(panels[0].Is(SingleStat), ShouldBeTrue)
+			So(panels[1].Is(Graph), ShouldBeTrue)
+			So(panels[2].Is(SingleStat), ShouldBeTrue)
+		})
+
+		Convey("Row title should be parsed", func() {
+			So(rows[0].Title, ShouldEqual, "RowTitle #")
 		})
 
-		Convey("Row title should be parsed and santised", func() {
-			So(dash.Rows[0].Title, ShouldEqual, "RowTitle \\#")
+		Convey("Rows, which have no type in v4, should be parsed as rows with their panels", func() {
+			So(rows, ShouldHaveLength, 2)
+			So(rows[0].ContentPanels, ShouldHaveLength, 2)
 		})
 
-		Convey("Panel titles should be parsed and sanitised", func() {
-			So(dash.Panels[2].Title, ShouldEqual, "Panel3Title \\#")
+		Convey("Panel titles should be parsed", func() {
+			So(panels[2].Title, ShouldEqual, "Panel3Title #")
 		})
 
-		Convey("When accessing Panels from within Rows, titles should still be sanitised", func() {
-			So(dash.Rows[1].Panels[0].Title, ShouldEqual, "Panel3Title \\#")
+		Convey("When accessing Panels from within Rows, titles should still be parsed", func() {
+			So(rows[1].ContentPanels[0].Title, ShouldEqual, "Panel3Title #")
 		})
 
 		Convey("Panels should contain all panels from all rows", func() {
-			So(dash.Panels, ShouldHaveLength, 3)
+			So(panels, ShouldHaveLength, 3)
 		})
 
-		Convey("The Title should be parsed and sanitised", func() {
-			So(dash.Title, ShouldEqual, "DashTitle \\#")
+		Convey("The Title should be parsed", func() {
+			So(dash.Title, ShouldEqual, "DashTitle #")
 		})
 	})
 }
@@ -94,60 +113,384 @@ func TestV5Dashboard(t *testing.T) {
 "Meta":
 	{"Slug":"testDash"}
 }`
-		dash := NewDashboard([]byte(v5DashJSON), url.Values{})
+		dash := parseDashboard(v5DashJSON)
+		panels := dash.GetGridPanels()
 
 		Convey("Panel Is(type) should work for all panels", func() {
-			So(dash.Panels[0].Is(SingleStat), ShouldBeTrue)
-			So(dash.Panels[1].Is(Graph), ShouldBeTrue)
-			So(dash.Panels[2].Is(SingleStat), ShouldBeTrue)
-			So(dash.Panels[3].Is(Text), ShouldBeTrue)
-			So(dash.Panels[4].Is(Table), ShouldBeTrue)
+			So(panels[0].Is(SingleStat), ShouldBeTrue)
+			So(panels[1].Is(Graph), ShouldBeTrue)
+			So(panels[2].Is(SingleStat), ShouldBeTrue)
+			So(panels[3].Is(Text), ShouldBeTrue)
+			So(panels[4].Is(Table), ShouldBeTrue)
 		})
 
-		Convey("Panel titles should be parsed and sanitised", func() {
-			So(dash.Panels[2].Title, ShouldEqual, "Panel3Title \\#")
+		Convey("Panel titles should be parsed", func() {
+			So(panels[2].Title, ShouldEqual, "Panel3Title #")
 		})
 
 		Convey("Panels should contain all panels that have type != row", func() {
-			So(dash.Panels, ShouldHaveLength, 5)
-			So(dash.Panels[0].Id, ShouldEqual, 0)
-			So(dash.Panels[1].Id, ShouldEqual, 1)
-			So(dash.Panels[2].Id, ShouldEqual, 2)
+			So(panels, ShouldHaveLength, 5)
+			So(panels[0].Id, ShouldEqual, 0)
+			So(panels[1].Id, ShouldEqual, 1)
+			So(panels[2].Id, ShouldEqual, 2)
 		})
 
 		Convey("The Title should be parsed", func() {
-			So(dash.Title, ShouldEqual, "DashTitle \\#")
+			So(dash.Title, ShouldEqual, "DashTitle #")
 		})
 
 		Convey("Panels should contain GridPos H & W", func() {
-			So(dash.Panels[1].GridPos.H, ShouldEqual, 6)
-			So(dash.Panels[1].GridPos.W, ShouldEqual, 24)
+			So(panels[1].GridPos.H, ShouldEqual, 6)
+			So(panels[1].GridPos.W, ShouldEqual, 24)
 		})
 
 		Convey("Panels GridPos should allow floatt", func() {
-			So(dash.Panels[3].GridPos.H, ShouldEqual, 6.5)
-			So(dash.Panels[3].GridPos.W, ShouldEqual, 20.5)
+			So(panels[3].GridPos.H, ShouldEqual, 6.5)
+			So(panels[3].GridPos.W, ShouldEqual, 20.5)
 		})
 
 	})
 }
 
-func TestVariableValues(t *testing.T) {
-	Convey("When creating a dashboard and passing url varialbes in", t, func() {
-		const v5DashJSON = `
-{
-	"Dashboard":
-		{
-		}
+func TestCollapsedRowDashboard(t *testing.T) {
+	Convey("When creating a dashboard with a collapsed row", t, func() {
+		const collapsedDashJSON = `
+{"dashboard":
+	{
+		"panels":
+			[{"type":"graph", "id":1, "gridPos":{"h":8,"w":24,"x":0,"y":0}},
+			{"type":"row", "id":2, "title":"Collapsed", "collapsed":true, "gridPos":{"h":1,"w":24,"x":0,"y":8},
+				"panels":[{"type":"table", "id":4, "gridPos":{"h":8,"w":24,"x":0,"y":17}},
+					{"type":"stat", "id":3, "gridPos":{"h":8,"w":12,"x":0,"y":9}}]},
+			{"type":"row", "id":5, "title":"Expanded", "collapsed":false, "gridPos":{"h":1,"w":24,"x":0,"y":9}},
+			{"type":"graph", "id":6, "gridPos":{"h":8,"w":24,"x":0,"y":10}}],
+		"title":"DashTitle"
+	}
+}`
+		dash := parseDashboard(collapsedDashJSON)
+		panels := dash.GetGridPanels()
+		rows := dash.GetRows()
+
+		Convey("The panels of the collapsed row should be in the grid panels, in dashboard order", func() {
+			So(panels, ShouldHaveLength, 4)
+			ids := []int{panels[0].Id, panels[1].Id, panels[2].Id, panels[3].Id}
+			So(ids, ShouldResemble, []int{1, 3, 4, 6})
+		})
+
+		Convey("The collapsed row should contain its panels", func() {
+			So(rows, ShouldHaveLength, 2)
+			So(rows[0].ContentPanels, ShouldHaveLength, 2)
+			So(rows[0].ContentPanels[0].Id, ShouldEqual, 3)
+			So(rows[0].ContentPanels[1].Id, ShouldEqual, 4)
+		})
+
+		Convey("The collapsed row should still show its title", func() {
+			So(rows[0].Title, ShouldEqual, "Collapsed")
+			So(rows[0].IsVisible(), ShouldBeTrue)
+		})
+
+		Convey("Each row should start at its first grid panel", func() {
+			starts := dash.RowStarts()
+			So(starts, ShouldHaveLength, 2)
+			So(starts[3].Title, ShouldEqual, "Collapsed")
+			So(starts[6].Title, ShouldEqual, "Expanded")
+		})
+	})
+}
+
+func TestRepeatedPanels(t *testing.T) {
+	Convey("When creating a dashboard with a panel repeated by a variable", t, func() {
+		const repeatDashJSON = `
+{"dashboard":
+	{
+		"panels":
+			[{"type":"graph", "id":2, "title":"Load $server", "repeat":"server", "maxPerRow":2, "gridPos":{"h":8,"w":24,"x":0,"y":0}},
+			{"type":"table", "id":7, "gridPos":{"h":8,"w":24,"x":0,"y":8}}],
+		"templating":{"list":[{"name":"server", "current":{"text":"web1 + web2 + web3","value":""},
+			"options":[{"text":"All","value":"$__all"},{"text":"web1","value":"web1","selected":true},
+				{"text":"web2","value":"web2","selected":true},{"text":"web3","value":"web3","selected":true}]}]}
+	}
+}`
+		dash := parseDashboard(repeatDashJSON)
+		panels := dash.GetGridPanels()
+
+		Convey("There should be a panel for each selected value", func() {
+			So(panels, ShouldHaveLength, 4)
+			So(panels[0].Title, ShouldEqual, "Load web1")
+			So(panels[1].Title, ShouldEqual, "Load web2")
+			So(panels[2].Title, ShouldEqual, "Load web3")
+			So(panels[3].Id, ShouldEqual, 7)
+		})
+
+		Convey("Each repeat should render the original panel with its own value", func() {
+			for i, value := range []string{"web1", "web2", "web3"} {
+				So(panels[i].RenderID(), ShouldEqual, 2)
+				So(panels[i].ScopedVars.Get("var-server"), ShouldEqual, value)
+			}
+		})
+
+		Convey("Repeats should get unique ids", func() {
+			So(panels[0].Id, ShouldEqual, 2)
+			So(panels[1].Id, ShouldEqual, 8)
+			So(panels[2].Id, ShouldEqual, 9)
+		})
+
+		Convey("Repeats should share the width, up to maxPerRow per line", func() {
+			So(panels[0].GridPos, ShouldResemble, GridPos{H: 8, W: 12, X: 0, Y: 0})
+			So(panels[1].GridPos, ShouldResemble, GridPos{H: 8, W: 12, X: 12, Y: 0})
+			So(panels[2].GridPos, ShouldResemble, GridPos{H: 8, W: 12, X: 0, Y: 8})
+		})
+
+		Convey("The variables requested for the report should select the values", func() {
+			var fullDash FullDashboard
+			So(json.Unmarshal([]byte(repeatDashJSON), &fullDash), ShouldBeNil)
+			fullDash.Dashboard.variables = url.Values{"var-server": {"web3"}}
+			panels := fullDash.Dashboard.GetGridPanels()
+			So(panels, ShouldHaveLength, 2)
+			So(panels[0].Title, ShouldEqual, "Load web3")
+		})
+	})
+}
+
+func TestV9Dashboard(t *testing.T) {
+	Convey("When creating a new dashboard from Grafana v9 dashboard JSON", t, func() {
+		const v9DashJSON = `
+{"dashboard":
+	{
+		"panels":
+			[{"type":"timeseries", "id":1, "gridPos":{"h":8,"w":12,"x":0,"y":0}},
+			{"type":"stat", "id":2, "gridPos":{"h":4,"w":6,"x":12,"y":0}},
+			{"type":"barchart", "id":3, "gridPos":{"h":8,"w":12,"x":0,"y":8}},
+			{"type":"gauge", "id":4, "gridPos":{"h":4,"w":6,"x":18,"y":0}},
+			{"type":"text", "id":5, "tags":["exec-summary"], "gridPos":{"h":8,"w":12,"x":12,"y":8}}],
+		"title":"DashTitle",
+		"uid":"abc123XYZ"
+	}
 }`
+		dash := parseDashboard(v9DashJSON)
+		panels := dash.GetGridPanels()
+
+		Convey("Panel Is(type) should recognise the new panel types", func() {
+			So(panels, ShouldHaveLength, 5)
+			So(panels[0].Is(Timeseries), ShouldBeTrue)
+			So(panels[1].Is(Stat), ShouldBeTrue)
+			So(panels[2].Is(Gauge), ShouldBeTrue)
+			So(panels[3].Is(BarChart), ShouldBeTrue)
+			So(panels[4].Is(Text), ShouldBeTrue)
+		})
+
+		Convey("Stat and gauge panels should be laid out like singlestat panels", func() {
+			So(panels[0].IsSingleStat(), ShouldBeFalse)
+			So(panels[1].IsSingleStat(), ShouldBeTrue)
+			So(panels[2].IsSingleStat(), ShouldBeTrue)
+			So(panels[3].IsSingleStat(), ShouldBeFalse)
+		})
+
+		Convey("All but the text panel should be renderable", func() {
+			for _, p := range panels[:4] {
+				So(p.IsRenderable(), ShouldBeTrue)
+			}
+			So(panels[4].IsRenderable(), ShouldBeFalse)
+		})
+
+		Convey("Panel tags should be parsed", func() {
+			So(panels[4].Tags, ShouldResemble, []string{"exec-summary"})
+			So(panels[0].Tags, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestVariableValues(t *testing.T) {
+	Convey("When formatting url varialbes", t, func() {
 		vars := url.Values{}
 		vars.Add("var-one", "oneval")
 		vars.Add("var-two", "twoval")
-		dash := NewDashboard([]byte(v5DashJSON), vars)
+		values := getVariablesValues(vars)
 
-		Convey("The dashboard should contain the variable values in a random order", func() {
-			So(dash.VariableValues, ShouldContainSubstring, "oneval")
-			So(dash.VariableValues, ShouldContainSubstring, "twoval")
+		Convey("The result should contain the variable values in a random order", func() {
+			So(values, ShouldContainSubstring, "oneval")
+			So(values, ShouldContainSubstring, "twoval")
 		})
 	})
 }
+
+func TestCurrentVariableValues(t *testing.T) {
+	Convey("When reading the current value of a variable from dashboard JSON", t, func() {
+		cases := []struct {
+			json   string
+			values []string
+			all    bool
+		}{
+			{`{"includeAll":true,"current":{"text":"web1","value":"web1"}}`, []string{"web1"}, false},
+			{`{"includeAll":true,"current":{"text":["web1","web2"],"value":["web1","web2"]}}`, []string{"web1", "web2"}, false},
+			{`{"includeAll":true,"current":{"text":"web1 + web2","value":"[\"web1\",\"web2\"]"}}`, []string{"web1", "web2"}, false},
+			{`{"includeAll":true,"current":{"text":"All","value":"$__all"}}`, []string{"$__all"}, true},
+			{`{"includeAll":true,"current":{"text":["All"],"value":["$__all"]}}`, []string{"$__all"}, true},
+			{`{"includeAll":false,"current":{"text":"All","value":"All"}}`, []string{"All"}, false},
+			{`{"current":{}}`, nil, false},
+		}
+		for _, c := range cases {
+			var v TemplateVariable
+			So(json.Unmarshal([]byte(c.json), &v), ShouldBeNil)
+			So(v.Current.Values(), ShouldResemble, c.values)
+			So(v.AllSelected(), ShouldEqual, c.all)
+		}
+	})
+}
+
+func TestPanelThresholds(t *testing.T) {
+	Convey("When parsing panels with field config thresholds", t, func() {
+		const thresholdsDashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"stat", "Id":1, "fieldConfig":{"defaults":{"unit":"percent","thresholds":{"mode":"absolute","steps":[
+				{"color":"green","value":null},{"color":"red","value":90}]}}}},
+			{"Type":"graph", "Id":2, "fieldConfig":{"defaults":{"thresholds":{"steps":[
+				{"color":"green","value":null},{"color":"red","value":90}]}}}},
+			{"Type":"gauge", "Id":3, "fieldConfig":{"defaults":{"thresholds":{"steps":[{"color":"green","value":null}]}}}}]
+	}
+}`
+		dash := parseDashboard(thresholdsDashJSON)
+		panels := dash.GetGridPanels()
+
+		Convey("The threshold steps should be parsed", func() {
+			steps := panels[0].FieldConfig.Defaults.Thresholds.Steps
+			So(steps, ShouldHaveLength, 2)
+			So(steps[0].Color, ShouldEqual, "green")
+			So(steps[0].Value, ShouldBeNil)
+			So(*steps[1].Value, ShouldEqual, 90)
+			So(panels[0].FieldConfig.Defaults.Unit, ShouldEqual, "percent")
+		})
+
+		Convey("Only stat-like panels with more than a base step should have thresholds", func() {
+			So(panels[0].HasThresholds(), ShouldBeTrue)
+			So(panels[1].HasThresholds(), ShouldBeFalse)
+			So(panels[2].HasThresholds(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestParseDashboard(t *testing.T) {
+	Convey("When parsing dashboard JSON", t, func() {
+		Convey("The dashboard API format should be accepted", func() {
+			dash, err := ParseDashboard([]byte(`{"dashboard":{"title":"Wrapped","uid":"abc","panels":[{"type":"graph","id":1}]},"meta":{"slug":"wrapped"}}`))
+			So(err, ShouldBeNil)
+			So(dash.Title, ShouldEqual, "Wrapped")
+			So(dash.Uid, ShouldEqual, "abc")
+			So(dash.GetGridPanels(), ShouldHaveLength, 1)
+		})
+
+		Convey("The bare dashboard model should be accepted", func() {
+			dash, err := ParseDashboard([]byte(`{"title":"Bare","panels":[{"type":"graph","id":1},{"type":"stat","id":2}]}`))
+			So(err, ShouldBeNil)
+			So(dash.Title, ShouldEqual, "Bare")
+			So(dash.GetGridPanels(), ShouldHaveLength, 2)
+		})
+
+		Convey("Invalid JSON should be an error", func() {
+			_, err := ParseDashboard([]byte(`{"title":`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDashboardRenderTimezone(t *testing.T) {
+	Convey("The render timezone of a dashboard", t, func() {
+		Convey("Should be UTC for unset, browser and utc timezones", func() {
+			for _, tz := range []string{"", "browser", "utc"} {
+				So(Dashboard{Timezone: tz}.RenderTimezone(), ShouldEqual, "UTC")
+			}
+		})
+
+		Convey("Should be the dashboard's IANA zone otherwise", func() {
+			So(Dashboard{Timezone: "Europe/Berlin"}.RenderTimezone(), ShouldEqual, "Europe/Berlin")
+		})
+	})
+}
+
+func TestTextPanelContent(t *testing.T) {
+	Convey("When parsing text panels", t, func() {
+		dash := parseDashboard(`
+{"dashboard":
+	{
+		"panels":
+			[{"type":"text", "id":1, "options":{"content":"# Notes", "mode":"markdown"}},
+			{"type":"text", "id":2, "content":"<b>Old</b> notes", "mode":"html"},
+			{"type":"text", "id":3, "content":"No mode"},
+			{"type":"singlestat", "id":4, "options":[]}]
+	}
+}`)
+		panels := dash.GetGridPanels()
+
+		Convey("The content should be read from the options of Grafana v7 and later", func() {
+			content, mode := panels[0].TextContent()
+			So(content, ShouldEqual, "# Notes")
+			So(mode, ShouldEqual, "markdown")
+		})
+
+		Convey("The content should be read from the panel of older Grafana versions", func() {
+			content, mode := panels[1].TextContent()
+			So(content, ShouldEqual, "<b>Old</b> notes")
+			So(mode, ShouldEqual, "html")
+		})
+
+		Convey("Markdown should be the default mode", func() {
+			_, mode := panels[2].TextContent()
+			So(mode, ShouldEqual, "markdown")
+		})
+
+		Convey("Options of other panels that are not an object should be ignored", func() {
+			So(panels, ShouldHaveLength, 4)
+			So(panels[3].IsText(), ShouldBeFalse)
+			So(panels[0].IsText(), ShouldBeTrue)
+		})
+	})
+}
+
+// latexSpecials holds every character SanitizeLaTexInput escapes, around emoji and accents
+const latexSpecials = `Disk \ & 100% $5 #1 a_b {x} ~y^2 <a> b|c [d] café 🚀 ❤️ 👨‍💻`
+
+func TestSanitizeLaTexInput(t *testing.T) {
+	Convey("When escaping text for LaTeX", t, func() {
+		escaped := SanitizeLaTexInput(latexSpecials)
+
+		Convey("Every special character should be escaped once", func() {
+			So(escaped, ShouldEqual, `Disk \textbackslash{} \& 100\% \$5 \#1 a\_b \{x\} \textasciitilde{}y\textasciicircum{}2 `+
+				`\textless{}a\textgreater{} b\textbar{}c {[}d{]} café   `)
+		})
+
+		Convey("Plain text should be kept", func() {
+			So(SanitizeLaTexInput("CPU load (5m), réseau: 42"), ShouldEqual, "CPU load (5m), réseau: 42")
+		})
+
+		Convey("Symbols and letters outside the emoji ranges should be kept", func() {
+			So(SanitizeLaTexInput("✓ done ★ 𝔸 𠀀 ✔︎"), ShouldEqual, "✓ done ★ 𝔸 𠀀 ✔")
+			So(SanitizeLaTexInput("⚡ 1️⃣ 🇿🇦 ✔️ ok"), ShouldEqual, "    ok")
+		})
+
+		Convey("Percent-encoded UTF-8 should be decoded", func() {
+			So(SanitizeLaTexInput("caf%C3%A9 %F0%9F%9A%80"), ShouldEqual, "café ")
+			So(SanitizeLaTexInput("100% of 50%25, %C3 or %zz"), ShouldEqual, `100\% of 50\%25, \%C3 or \%zz`)
+		})
+	})
+}
+
+func TestSanitizedLaTeXCompiles(t *testing.T) {
+	if _, err := exec.LookPath("pdflatex"); err != nil {
+		t.Skip("pdflatex is not installed")
+	}
+	Convey("When compiling escaped text with pdflatex", t, func() {
+		dir := t.TempDir()
+		doc := "\\documentclass{article}\n\\usepackage[utf8]{inputenc}\n\\begin{document}\n" +
+			"\\section{" + SanitizeLaTexInput(latexSpecials) + "}\n" +
+			"Line\\\\" + SanitizeLaTexInput(latexSpecials) + "\n\\end{document}\n"
+		So(ioutil.WriteFile(filepath.Join(dir, "escaped.tex"), []byte(doc), 0644), ShouldBeNil)
+
+		cmd := exec.Command("pdflatex", "-halt-on-error", "-interaction=nonstopmode", "escaped.tex")
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		So(err, ShouldBeNil)
+		So(string(out), ShouldNotContainSubstring, "Unicode character")
+	})
+}
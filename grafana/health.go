@@ -0,0 +1,54 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Ping checks that Grafana at baseURL is reachable and healthy, using its unauthenticated
+// /api/health endpoint. Requests go through the transport of clients with the same proxy and TLS
+// settings, so that probes reuse its connections instead of opening new ones.
+func Ping(ctx context.Context, baseURL string, sslCheck bool, opts ClientOptions) error {
+	baseURL = trimBaseURL(baseURL)
+	g := &client{url: baseURL, sslCheck: sslCheck, opts: opts}
+	httpClient := &http.Client{Transport: g.transport(), Timeout: apiRequestTimeout}
+	healthURL := baseURL + "/api/health"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating health request for %v: %w", healthURL, err)
+	}
+	g.addHeaders(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error executing health request for %v: %w", healthURL, err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, resp.Body) // Read to the end, so that the connection can be reused
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("grafana at %v is unhealthy: Status %d, Body: %s", baseURL, resp.StatusCode, limitString(string(body), 500))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// LibraryPanelRef is how a dashboard refers to a library panel, whose definition is stored separately
+type LibraryPanelRef struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+// GetLibraryPanel fetches the definition of the library panel with the given UID
+func (g *client) GetLibraryPanel(ctx context.Context, uid string) (Panel, error) {
+	libraryURL := g.url + "/api/library-elements/" + url.PathEscape(uid)
+	slog.Debug("Getting library panel", "url", libraryURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", libraryURL, nil)
+	if err != nil {
+		return Panel{}, fmt.Errorf("error creating library panel request for %v: %w", libraryURL, err)
+	}
+	g.addHeaders(req)
+
+	resp, err := g.apiClient.Do(req)
+	if err != nil {
+		return Panel{}, fmt.Errorf("error executing library panel request for %v: %w", libraryURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Panel{}, fmt.Errorf("error reading library panel response body for %v: %w", libraryURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Panel{}, fmt.Errorf("error getting library panel %v: Status %d, Body: %s", libraryURL, resp.StatusCode, limitString(string(body), 500))
+	}
+
+	var element struct {
+		Result struct {
+			Model Panel `json:"model"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(body, &element)
+	if err != nil {
+		return Panel{}, fmt.Errorf("error unmarshaling library panel JSON from %v: %w", libraryURL, err)
+	}
+	return element.Result.Model, nil
+}
+
+// IsLibraryPanel is true for panels that refer to a library panel instead of defining the panel
+func (p Panel) IsLibraryPanel() bool {
+	return p.LibraryPanel != nil && p.LibraryPanel.UID != ""
+}
+
+// withLibraryModel is the panel defined by the library panel model, placed where the dashboard puts it
+func (p Panel) withLibraryModel(model Panel) Panel {
+	model.Id = p.Id
+	model.GridPos = p.GridPos
+	model.LibraryPanel = p.LibraryPanel
+	model.RepeatPanelId = p.RepeatPanelId
+	model.ScopedVars = p.ScopedVars
+	if model.Title == "" {
+		model.Title = p.Title
+	}
+	return model
+}
+
+// resolveLibraryPanels replaces the library panels of the dashboard by their definitions. Panels
+// whose definition cannot be fetched are left as they are, so the rest of the report still renders.
+func (g *client) resolveLibraryPanels(ctx context.Context, d *Dashboard) {
+	models := map[string]*Panel{}
+	resolve := func(p Panel) Panel {
+		if !p.IsLibraryPanel() {
+			return p
+		}
+		model, fetched := models[p.LibraryPanel.UID]
+		if !fetched {
+			m, err := g.GetLibraryPanel(ctx, p.LibraryPanel.UID)
+			if err != nil {
+				slog.Warn("Cannot resolve library panel", "name", p.LibraryPanel.Name, "uid", p.LibraryPanel.UID, "panel", p.Id, "error", err)
+			} else {
+				model = &m
+			}
+			models[p.LibraryPanel.UID] = model
+		}
+		if model == nil {
+			return p
+		}
+		return p.withLibraryModel(*model)
+	}
+
+	for i, p := range d.processedPanels {
+		d.processedPanels[i] = resolve(p)
+	}
+	for i := range d.processedRows {
+		for j, p := range d.processedRows[i].ContentPanels {
+			d.processedRows[i].ContentPanels[j] = resolve(p)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockGrafana is a fake Grafana server for client tests. It serves the dashboards in
+// testdata/dashboards, by the file name as UID or by their meta slug, and renders every panel
+// as testdata/panel.png. Renders first fail with the status codes of renderFailures, in
+// order. All requests are recorded.
+type mockGrafana struct {
+	*httptest.Server
+	mu             sync.Mutex
+	requests       []*http.Request
+	renderFailures []int
+}
+
+func newMockGrafana(renderFailures ...int) *mockGrafana {
+	m := &mockGrafana{renderFailures: renderFailures}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *mockGrafana) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.requests = append(m.requests, r.Clone(context.Background()))
+	m.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/dashboards/uid/"):
+		m.serveFile(w, filepath.Join("testdata", "dashboards", path.Base(r.URL.Path)+".json"), "application/json")
+	case strings.HasPrefix(r.URL.Path, "/api/dashboards/db/"):
+		m.serveFile(w, m.dashboardBySlug(path.Base(r.URL.Path)), "application/json")
+	case strings.HasPrefix(r.URL.Path, "/render/"):
+		m.mu.Lock()
+		failure := 0
+		if len(m.renderFailures) > 0 {
+			failure, m.renderFailures = m.renderFailures[0], m.renderFailures[1:]
+		}
+		m.mu.Unlock()
+		if failure != 0 {
+			http.Error(w, "mock render failure", failure)
+			return
+		}
+		m.serveFile(w, filepath.Join("testdata", "panel.png"), "image/png")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *mockGrafana) serveFile(w http.ResponseWriter, file, contentType string) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		http.Error(w, `{"message":"Dashboard not found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content)
+}
+
+// dashboardBySlug is the fixture file of the dashboard with the slug, or "" if there is none
+func (m *mockGrafana) dashboardBySlug(slug string) string {
+	files, _ := filepath.Glob(filepath.Join("testdata", "dashboards", "*.json"))
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(file)
+		if bytes.Contains(content, []byte(`"slug": "`+slug+`"`)) {
+			return file
+		}
+	}
+	return ""
+}
+
+// received are the recorded requests whose path starts with the prefix
+func (m *mockGrafana) received(prefix string) []*http.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matching []*http.Request
+	for _, r := range m.requests {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			matching = append(matching, r)
+		}
+	}
+	return matching
+}
+
+// fastRetries retry renders without waiting, to keep tests fast
+var fastRetries = ClientOptions{RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond}
+
+func TestMockGrafanaDashboards(t *testing.T) {
+	Convey("When fetching the fixture dashboards from the mock Grafana", t, func() {
+		m := newMockGrafana()
+		defer m.Close()
+		grf := NewV5Client(m.URL, "1234", url.Values{}, true, false, fastRetries)
+
+		Convey("A dashboard with rows should have its rows and their panels in order", func() {
+			dash, err := grf.GetDashboard(context.Background(), "rows-dashboard")
+			So(err, ShouldBeNil)
+			So(dash.Title, ShouldEqual, "Rows")
+			ids := []int{}
+			for _, p := range dash.GetGridPanels() {
+				ids = append(ids, p.Id)
+			}
+			So(ids, ShouldResemble, []int{2, 3, 5})
+			rows := dash.GetRows()
+			So(rows, ShouldHaveLength, 2)
+			So(rows[0].Title, ShouldEqual, "Overview")
+			So(rows[1].Title, ShouldEqual, "Details")
+		})
+
+		Convey("A dashboard with collapsed rows should include the panels inside them", func() {
+			dash, err := grf.GetDashboard(context.Background(), "collapsed-rows")
+			So(err, ShouldBeNil)
+			ids := []int{}
+			for _, p := range dash.GetGridPanels() {
+				ids = append(ids, p.Id)
+			}
+			So(ids, ShouldResemble, []int{1, 3, 4, 6})
+		})
+
+		Convey("A dashboard with multi-value variables should have their selections", func() {
+			dash, err := grf.GetDashboard(context.Background(), "multi-value-vars")
+			So(err, ShouldBeNil)
+			So(dash.Templating.List, ShouldHaveLength, 2)
+			So(dash.Templating.List[0].Current.Values(), ShouldResemble, []string{"web1", "web2"})
+			So(dash.Templating.List[1].AllSelected(), ShouldBeTrue)
+			So(dash.GetGridPanels(), ShouldHaveLength, 2) // Repeated for web1 and web2
+		})
+
+		Convey("A dashboard should also be found by its slug", func() {
+			dash, err := grf.GetDashboard(context.Background(), "rows")
+			So(err, ShouldBeNil)
+			So(dash.Uid, ShouldEqual, "rows-dashboard")
+			So(m.received("/api/dashboards/db/rows"), ShouldHaveLength, 1)
+		})
+
+		Convey("An unknown dashboard should fail", func() {
+			_, err := grf.GetDashboard(context.Background(), "no-such-dashboard")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Every request should carry the api token", func() {
+			grf.GetDashboard(context.Background(), "rows-dashboard")
+			grf.GetPanelPng(context.Background(), Panel{Id: 2, Type: "stat"}, "rows-dashboard", TimeRange{"now-1h", "now"})
+			So(m.received("/"), ShouldHaveLength, 2)
+			for _, r := range m.received("/") {
+				So(r.Header.Get("Authorization"), ShouldEqual, "Bearer 1234")
+			}
+		})
+	})
+}
+
+func TestMockGrafanaRenders(t *testing.T) {
+	Convey("When rendering panels with the mock Grafana", t, func() {
+		render := func(m *mockGrafana) ([]byte, error) {
+			grf := NewV5Client(m.URL, "", url.Values{}, true, false, fastRetries)
+			body, err := grf.GetPanelPng(context.Background(), Panel{Id: 3, Type: "timeseries"}, "rows-dashboard", TimeRange{"now-1h", "now"})
+			if err != nil {
+				return nil, err
+			}
+			defer body.Close()
+			return ioutil.ReadAll(body)
+		}
+
+		Convey("It should return the rendered PNG", func() {
+			m := newMockGrafana()
+			defer m.Close()
+			img, err := render(m)
+			So(err, ShouldBeNil)
+			_, err = png.Decode(bytes.NewReader(img))
+			So(err, ShouldBeNil)
+			So(m.received("/render/d-solo/rows-dashboard"), ShouldHaveLength, 1)
+		})
+
+		Convey("It should retry server errors and rate limits", func() {
+			m := newMockGrafana(http.StatusBadGateway, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+			defer m.Close()
+			_, err := render(m)
+			So(err, ShouldBeNil)
+			So(m.received("/render/"), ShouldHaveLength, 4)
+		})
+
+		Convey("It should give up after the retries", func() {
+			m := newMockGrafana(500, 500, 500, 500, 500)
+			defer m.Close()
+			_, err := render(m)
+			So(err, ShouldNotBeNil)
+			So(m.received("/render/"), ShouldHaveLength, maxGetPanelRetries+1)
+		})
+
+		Convey("It should not retry client errors", func() {
+			m := newMockGrafana(http.StatusNotFound)
+			defer m.Close()
+			_, err := render(m)
+			So(err, ShouldNotBeNil)
+			So(m.received("/render/"), ShouldHaveLength, 1)
+		})
+	})
+}
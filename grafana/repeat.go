@@ -0,0 +1,149 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+const (
+	allValue          = "$__all"
+	defaultMaxPerRow  = 4
+	dashboardGridSize = 24
+)
+
+// RenderID is the id of the panel to render: that of the panel a repeated panel was cloned from
+func (p Panel) RenderID() int {
+	if p.RepeatPanelId != 0 {
+		return p.RepeatPanelId
+	}
+	return p.Id
+}
+
+// SelectedValues are the values of the variable currently selected in the dashboard. Selecting
+// "All" selects every option.
+func (v TemplateVariable) SelectedValues() []string {
+	var values []string
+	all := v.Current.hasAll()
+	for _, o := range v.Options {
+		if o.Value == allValue {
+			all = all || o.Selected
+			continue
+		}
+		if o.Selected {
+			values = append(values, o.Value)
+		}
+	}
+	if all {
+		values = v.optionValues()
+	}
+	if len(values) == 0 && !all {
+		values = v.Current.Values()
+	}
+	return values
+}
+
+// repeatValues are the values a panel repeated by the named variable is repeated for: those
+// requested for the report, else those selected in the dashboard
+func (d *Dashboard) repeatValues(name string) []string {
+	if values := d.variables["var-"+name]; len(values) > 0 {
+		return values
+	}
+	if values := d.variables[name]; len(values) > 0 {
+		return values
+	}
+	for _, v := range d.Templating.List {
+		if v.Name == name {
+			return v.SelectedValues()
+		}
+	}
+	return nil
+}
+
+// repeatPanels replaces each panel that repeats by a variable with one panel per value of the
+// variable. The first keeps the id of the panel; the others get unused ids above nextID and
+// remember the panel they were cloned from. Like in Grafana, horizontally repeated panels share
+// the width of the panel, up to maxPerRow of them per line, and vertically repeated panels stack.
+func (d *Dashboard) repeatPanels(panels []Panel, nextID *int) []Panel {
+	var repeated []Panel
+	for _, p := range panels {
+		if p.RepeatPanelId != 0 {
+			slog.Debug("Skipping a repeat saved by an older Grafana", "panel", p.Id, "repeatOf", p.RepeatPanelId)
+			continue
+		}
+		if p.Repeat == "" {
+			repeated = append(repeated, p)
+			continue
+		}
+		values := d.repeatValues(p.Repeat)
+		if len(values) == 0 {
+			slog.Warn("Panel repeats by a variable without values, rendering it once", "panel", p.Id, "variable", p.Repeat)
+			repeated = append(repeated, p)
+			continue
+		}
+		slog.Debug("Repeating panel", "panel", p.Id, "title", p.Title, "values", len(values), "variable", p.Repeat)
+
+		perRow := p.MaxPerRow
+		if perRow <= 0 {
+			perRow = defaultMaxPerRow
+		}
+		if perRow > len(values) {
+			perRow = len(values)
+		}
+		width := p.GridPos.W
+		if p.RepeatDirection != "v" {
+			width = float64(dashboardGridSize / perRow)
+		}
+		for i, value := range values {
+			clone := p
+			if i > 0 {
+				*nextID++
+				clone.Id = *nextID
+				clone.RepeatPanelId = p.Id
+			}
+			clone.ScopedVars = url.Values{"var-" + p.Repeat: {value}}
+			clone.Title = strings.NewReplacer("${"+p.Repeat+"}", value, "$"+p.Repeat, value).Replace(p.Title)
+			if p.RepeatDirection == "v" {
+				clone.GridPos.Y = p.GridPos.Y + float64(i)*p.GridPos.H
+			} else {
+				clone.GridPos.W = width
+				clone.GridPos.X = float64(i%perRow) * width
+				clone.GridPos.Y = p.GridPos.Y + float64(i/perRow)*p.GridPos.H
+			}
+			repeated = append(repeated, clone)
+		}
+	}
+	return repeated
+}
+
+// maxPanelID is the highest id of the panels, including those nested in rows
+func maxPanelID(panels []Panel) int {
+	max := 0
+	for _, p := range panels {
+		if p.Id > max {
+			max = p.Id
+		}
+		for _, c := range p.ContentPanels {
+			if c.Id > max {
+				max = c.Id
+			}
+		}
+	}
+	return max
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// generalFolder is the title of the folder of dashboards that are not in a folder
+const generalFolder = "General"
+
+// searchHit is a dashboard found by Grafana's search API
+type searchHit struct {
+	UID         string `json:"uid"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	FolderTitle string `json:"folderTitle"`
+}
+
+// splitFolderTitle splits a "folder/title" dashboard name. ok is false for names without a folder,
+// such as UIDs and slugs.
+func splitFolderTitle(dashName string) (folder, title string, ok bool) {
+	i := strings.Index(dashName, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return dashName[:i], dashName[i+1:], true
+}
+
+// FindDashboardUID looks up the UID of the dashboard with the given title in the given folder.
+// Titles and folders are matched case-insensitively; an empty folder or "General" is the top level.
+func (g *client) FindDashboardUID(ctx context.Context, folder, title string) (string, error) {
+	vals := url.Values{}
+	vals.Add("query", title)
+	vals.Add("type", "dash-db")
+	searchURL := g.url + "/api/search?" + vals.Encode()
+	slog.Debug("Searching dashboard", "url", searchURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating search request for %v: %w", searchURL, err)
+	}
+	g.addHeaders(req)
+
+	resp, err := g.apiClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error executing search request for %v: %w", searchURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading search response body for %v: %w", searchURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp.StatusCode, fmt.Errorf("error searching dashboard %v: Status %d, Body: %s", searchURL, resp.StatusCode, limitString(string(body), 500)))
+	}
+
+	var hits []searchHit
+	if err := json.Unmarshal(body, &hits); err != nil {
+		return "", fmt.Errorf("error unmarshaling search JSON from %v: %w", searchURL, err)
+	}
+
+	if folder == "" {
+		folder = generalFolder
+	}
+	var matches []searchHit
+	for _, hit := range hits {
+		hitFolder := hit.FolderTitle
+		if hitFolder == "" {
+			hitFolder = generalFolder
+		}
+		if strings.EqualFold(hit.Title, title) && strings.EqualFold(hitFolder, folder) {
+			matches = append(matches, hit)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: no dashboard titled '%s' in folder '%s'", ErrDashboardNotFound, title, folder)
+	case 1:
+		slog.Info("Found dashboard", "folder", folder, "title", title, "uid", matches[0].UID)
+		return matches[0].UID, nil
+	}
+	candidates := make([]string, len(matches))
+	for i, m := range matches {
+		candidates[i] = fmt.Sprintf("%s (UID: %s)", m.URL, m.UID)
+	}
+	return "", fmt.Errorf("%d dashboards titled '%s' in folder '%s', use one of their UIDs instead: %s", len(matches), title, folder, strings.Join(candidates, ", "))
+}
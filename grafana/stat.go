@@ -0,0 +1,138 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// singlestatCalcs maps the value names of singlestat panels to the calculations of stat panels
+var singlestatCalcs = map[string]string{
+	"current": "lastNotNull",
+	"first":   "firstNotNull",
+	"avg":     "mean",
+	"total":   "sum",
+	"min":     "min",
+	"max":     "max",
+	"count":   "count",
+}
+
+// ReduceCalc is the calculation that reduces the panel's series to the value it shows, e.g.
+// "lastNotNull", as Grafana defaults to
+func (p Panel) ReduceCalc() string {
+	if calcs := p.Options.ReduceOptions.Calcs; len(calcs) > 0 {
+		return calcs[0]
+	}
+	if calc, ok := singlestatCalcs[p.ValueName]; ok {
+		return calc
+	}
+	return "lastNotNull"
+}
+
+// GetPanelValue queries the data of a stat-like panel within the time range and reduces it to
+// the value the panel shows, using the first numeric field of the first query
+func (g *client) GetPanelValue(ctx context.Context, p Panel, dashUID string, t TimeRange) (float64, error) {
+	result, err := g.queryPanel(ctx, p, dashUID, t)
+	if err != nil {
+		return 0, err
+	}
+	v, err := result.reduce(p.ReduceCalc())
+	if err != nil {
+		return 0, fmt.Errorf("error getting value of panel %d: %w", p.Id, err)
+	}
+	return v, nil
+}
+
+// reduce reduces the first numeric field of the first frame, by refId, with the calculation
+func (r queryResponse) reduce(calc string) (float64, error) {
+	refIDs := make([]string, 0, len(r.Results))
+	for refID := range r.Results {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	for _, refID := range refIDs {
+		result := r.Results[refID]
+		if result.Error != "" {
+			return 0, fmt.Errorf("query %s failed: %s", refID, result.Error)
+		}
+		for _, frame := range result.Frames {
+			if values, ok := frame.numbers(); ok {
+				return reduceValues(values, calc)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no numeric data")
+}
+
+// numbers are the non-null values of the first numeric field of the frame
+func (f dataFrame) numbers() ([]float64, bool) {
+	for i, field := range f.Schema.Fields {
+		if field.Type != "number" || i >= len(f.Data.Values) {
+			continue
+		}
+		var values []float64
+		for _, v := range f.Data.Values[i] {
+			if n, ok := v.(json.Number); ok {
+				if x, err := n.Float64(); err == nil {
+					values = append(values, x)
+				}
+			}
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func reduceValues(values []float64, calc string) (float64, error) {
+	if calc == "count" {
+		return float64(len(values)), nil
+	}
+	if len(values) == 0 {
+		return 0, fmt.Errorf("no values in the time range")
+	}
+	switch calc {
+	case "lastNotNull", "last":
+		return values[len(values)-1], nil
+	case "firstNotNull", "first":
+		return values[0], nil
+	case "min", "max":
+		v := values[0]
+		for _, x := range values[1:] {
+			if calc == "min" {
+				v = math.Min(v, x)
+			} else {
+				v = math.Max(v, x)
+			}
+		}
+		return v, nil
+	case "sum", "mean":
+		sum := 0.0
+		for _, x := range values {
+			sum += x
+		}
+		if calc == "mean" {
+			return sum / float64(len(values)), nil
+		}
+		return sum, nil
+	}
+	return 0, fmt.Errorf("unsupported calculation %q", calc)
+}
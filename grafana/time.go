@@ -19,8 +19,10 @@ package grafana
 import (
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -80,6 +82,43 @@ func (tr TimeRange) ToFormatted() string {
 	return n.parseTo(tr.To).Format(time.UnixDate)
 }
 
+// Span is the duration covered by the time range. ok is false if either time spec is not recognised.
+func (tr TimeRange) Span() (span time.Duration, ok bool) {
+	from, to, ok := tr.Bounds()
+	return to.Sub(from), ok
+}
+
+// Bounds are the absolute times of the time range. ok is false if either time spec is not recognised.
+func (tr TimeRange) Bounds() (from, to time.Time, ok bool) {
+	return newNow().bounds(tr)
+}
+
+// BoundsIn are the absolute times of the time range in the location, which also decides where
+// the days, weeks, months and years of boundaries such as now/d start. ok is false if either time
+// spec is not recognised.
+func (tr TimeRange) BoundsIn(loc *time.Location) (from, to time.Time, ok bool) {
+	return newNow().boundsIn(tr, loc)
+}
+
+func (n now) boundsIn(tr TimeRange, loc *time.Location) (from, to time.Time, ok bool) {
+	from, to, ok = now(n.asTime().In(loc)).bounds(tr)
+	return from.In(loc), to.In(loc), ok
+}
+
+// IsRelative is true if either end of the time range is relative to now, e.g. now-6h or now/d
+func (tr TimeRange) IsRelative() bool {
+	return strings.HasPrefix(tr.From, "now") || strings.HasPrefix(tr.To, "now")
+}
+
+func (n now) bounds(tr TimeRange) (from, to time.Time, ok bool) {
+	defer func() {
+		if recover() != nil {
+			from, to, ok = time.Time{}, time.Time{}, false
+		}
+	}()
+	return n.parseFrom(tr.From), n.parseTo(tr.To), true
+}
+
 func newNow() now {
 	return now(time.Now())
 }
@@ -178,22 +217,58 @@ func (n now) parseRelativeTime(s string) time.Time {
 	i, err := strconv.Atoi(number)
 	stopIf(err)
 
+	return addUnits(n.asTime(), i, unit)
+}
+
+// addUnits adds i of the time unit, one of "mhdwMy", to t
+func addUnits(t time.Time, i int, unit string) time.Time {
 	switch unit {
-	case "m", "h":
-		d, err := time.ParseDuration(number + unit)
-		stopIf(err)
-		return n.asTime().Add(d)
+	case "m":
+		return t.Add(time.Duration(i) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(i) * time.Hour)
 	case "d":
-		return n.asTime().AddDate(0, 0, i)
+		return t.AddDate(0, 0, i)
 	case "w":
-		return n.asTime().AddDate(0, 0, i*7)
+		return t.AddDate(0, 0, i*7)
 	case "M":
-		return n.asTime().AddDate(0, i, 0)
+		return t.AddDate(0, i, 0)
 	case "y":
-		return n.asTime().AddDate(i, 0, 0)
+		return t.AddDate(i, 0, 0)
 	}
 
-	return n.asTime()
+	return t
+}
+
+// TimeRange is the time range the panel shows on its dashboard, given the dashboard's. A
+// relative time override (timeFrom, e.g. "2h") replaces the dashboard's range up to now, and a
+// time shift (timeShift, e.g. "1d") moves the range back. Panels without either show tr.
+func (p Panel) TimeRange(tr TimeRange) TimeRange {
+	return newNow().panelTimeRange(tr, p.TimeFrom, p.TimeShift)
+}
+
+func (n now) panelTimeRange(tr TimeRange, timeFrom, timeShift string) TimeRange {
+	if timeFrom = strings.TrimSpace(timeFrom); timeFrom != "" {
+		if !strings.HasPrefix(timeFrom, "now") {
+			timeFrom = "now-" + timeFrom
+		}
+		tr = TimeRange{From: timeFrom, To: "now"}
+	}
+	if timeShift = strings.TrimSpace(timeShift); timeShift == "" {
+		return tr
+	}
+
+	matches := regexp.MustCompile(relTimeRegExp).FindStringSubmatch("now-" + strings.TrimPrefix(timeShift, "-"))
+	from, to, ok := n.bounds(tr)
+	if matches == nil || !ok {
+		slog.Warn("Ignoring time shift", "timeShift", timeShift, "timeRange", tr)
+		return tr
+	}
+	i, _ := strconv.Atoi(matches[1])
+	return TimeRange{
+		From: strconv.FormatInt(addUnits(from, i, matches[2]).UnixNano()/int64(time.Millisecond), 10),
+		To:   strconv.FormatInt(addUnits(to, i, matches[2]).UnixNano()/int64(time.Millisecond), 10),
+	}
 }
 
 func parseAbsTime(s string) time.Time {
@@ -203,3 +203,74 @@ func TestTimeParsing(tst *testing.T) {
 
 	})
 }
+
+func TestPanelTimeRange(tst *testing.T) {
+	testNow, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 16:34:32 UTC")
+	t := now(testNow)
+	tr := TimeRange{From: "now-6h", To: "now"}
+	ms := func(tm time.Time) string { return fmt.Sprint(tm.UnixNano() / int64(time.Millisecond)) }
+
+	Convey("When computing the time range of a panel", tst, func() {
+		Convey("Panels without overrides should show the dashboard's range", func() {
+			So(t.panelTimeRange(tr, "", ""), ShouldResemble, tr)
+		})
+
+		Convey("A relative time override should replace the range up to now", func() {
+			So(t.panelTimeRange(tr, "2h", ""), ShouldResemble, TimeRange{From: "now-2h", To: "now"})
+			So(t.panelTimeRange(tr, "now-1d", ""), ShouldResemble, TimeRange{From: "now-1d", To: "now"})
+		})
+
+		Convey("A time shift should move the range back", func() {
+			So(t.panelTimeRange(tr, "", "1d"), ShouldResemble, TimeRange{
+				From: ms(testNow.Add(-6*time.Hour).AddDate(0, 0, -1)),
+				To:   ms(testNow.AddDate(0, 0, -1)),
+			})
+		})
+
+		Convey("Both should apply together", func() {
+			So(t.panelTimeRange(tr, "30m", "1h"), ShouldResemble, TimeRange{
+				From: ms(testNow.Add(-90 * time.Minute)),
+				To:   ms(testNow.Add(-time.Hour)),
+			})
+		})
+
+		Convey("Unrecognised time shifts should be ignored", func() {
+			So(t.panelTimeRange(tr, "", "yesterday"), ShouldResemble, tr)
+		})
+	})
+}
+
+func TestBoundsIn(tst *testing.T) {
+	testNow, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 16:34:32 UTC")
+	t := now(testNow)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		tst.Skip("no timezone database:", err)
+	}
+
+	Convey("When resolving a time range in a timezone", tst, func() {
+		Convey("Relative times should be in the timezone", func() {
+			from, to, ok := t.boundsIn(TimeRange{From: "now-6h", To: "now"}, tokyo)
+			So(ok, ShouldBeTrue)
+			So(from.Format("2006-01-02 15:04 MST"), ShouldEqual, "2016-01-06 19:34 JST")
+			So(to.Format("2006-01-02 15:04 MST"), ShouldEqual, "2016-01-07 01:34 JST")
+		})
+
+		Convey("Boundaries should be the days of the timezone", func() {
+			from, to, ok := t.boundsIn(TimeRange{From: "now/d", To: "now/d"}, tokyo)
+			So(ok, ShouldBeTrue)
+			So(from.Format("2006-01-02 15:04 MST"), ShouldEqual, "2016-01-07 00:00 JST")
+			So(to.Format("2006-01-02 15:04 MST"), ShouldEqual, "2016-01-08 00:00 JST")
+		})
+
+		Convey("Unrecognised times should not resolve", func() {
+			_, _, ok := t.boundsIn(TimeRange{From: "yesterday", To: "now"}, tokyo)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Ranges with an end relative to now should be relative", tst, func() {
+		So(TimeRange{From: "now-6h", To: "now"}.IsRelative(), ShouldBeTrue)
+		So(TimeRange{From: "1500000000000", To: "1500021600000"}.IsRelative(), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,181 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// renderVariables are the var- parameters of render requests, by the report's variables.
+// Grafana expects the values of multi-value variables as repeated parameters, so a value
+// joined with commas is split, and All is expanded to the values of the variable's options.
+// Adhoc filters and intervals the report does not set are taken from the dashboard, as the
+// renderer does not apply them otherwise. Variables of the dashboard fetched by GetDashboard,
+// or given to SetTemplating, are known; others are passed as given.
+func (g *client) renderVariables() url.Values {
+	byName := make(map[string]TemplateVariable, len(g.templating))
+	for _, v := range g.templating {
+		byName[v.Name] = v
+	}
+
+	vals := url.Values{}
+	for k, values := range g.variables {
+		key := k
+		if !strings.HasPrefix(key, "var-") {
+			key = "var-" + key
+		}
+		v, known := byName[strings.TrimPrefix(key, "var-")]
+		for _, value := range values {
+			switch {
+			case known && v.Type == "adhoc":
+				vals.Add(key, adhocFilterParam(value))
+			case known && value == allValue && len(v.optionValues()) > 0:
+				vals[key] = append(vals[key], v.optionValues()...)
+			case known && v.Multi && strings.Contains(value, ","):
+				for _, part := range strings.Split(value, ",") {
+					if part = strings.TrimSpace(part); part != "" {
+						vals.Add(key, part)
+					}
+				}
+			default:
+				vals.Add(key, value)
+			}
+		}
+	}
+
+	for _, v := range g.templating {
+		key := "var-" + v.Name
+		if _, set := vals[key]; set {
+			continue
+		}
+		switch v.Type {
+		case "adhoc":
+			for _, f := range v.Filters {
+				vals.Add(key, f.param())
+			}
+		case "interval":
+			if values := v.Current.Values(); len(values) > 0 {
+				vals.Set(key, values[0])
+			}
+		}
+	}
+	return vals
+}
+
+// SetTemplating gives the client the variables of a dashboard it did not fetch itself, e.g.
+// one posted to the reporter, so its render requests encode them as for a fetched dashboard
+func SetTemplating(c Client, templating []TemplateVariable) {
+	if g, ok := c.(*client); ok {
+		g.templating = templating
+	}
+}
+
+// param is the filter as Grafana encodes it in URLs, e.g. host|=|web1
+func (f AdhocFilter) param() string {
+	return f.Key + "|" + f.Operator + "|" + f.Value
+}
+
+// adhocOperators are the operators of adhoc filters, those that start with another first
+var adhocOperators = []string{"=~", "!~", "!=", "<=", ">=", "=", "<", ">"}
+
+// adhocFilterParam encodes a requested adhoc filter as Grafana expects it. Filters already
+// encoded as key|operator|value are kept; others, like host=web1, are split at their operator.
+func adhocFilterParam(filter string) string {
+	if strings.Contains(filter, "|") {
+		return filter
+	}
+	op, at := "", -1
+	for _, o := range adhocOperators {
+		if i := strings.Index(filter, o); i > 0 && (at < 0 || i < at) {
+			op, at = o, i
+		}
+	}
+	if at < 0 {
+		return filter
+	}
+	return AdhocFilter{Key: filter[:at], Operator: op, Value: filter[at+len(op):]}.param()
+}
+
+// optionValues are the values of the variable's options, without the All option
+func (v TemplateVariable) optionValues() []string {
+	var values []string
+	for _, o := range v.Options {
+		if o.Value != allValue {
+			values = append(values, o.Value)
+		}
+	}
+	return values
+}
+
+// Values are the selected values of the variable, whichever form Grafana stored them in
+func (c CurrentVal) Values() []string {
+	return currentValues(c.Value)
+}
+
+// Texts are the display texts of the selected values, whichever form Grafana stored them in
+func (c CurrentVal) Texts() []string {
+	return currentValues(c.Text)
+}
+
+// hasAll reports whether the All sentinel is selected, in either the text or the value
+func (c CurrentVal) hasAll() bool {
+	for _, values := range [][]string{c.Values(), c.Texts()} {
+		for _, value := range values {
+			if value == allValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllSelected reports whether the variable has its All option selected
+func (v TemplateVariable) AllSelected() bool {
+	return v.IncludeAll && v.Current.hasAll()
+}
+
+// currentValues normalises a current text or value: a string, a list, or a list encoded as
+// a JSON array in a string
+func currentValues(x interface{}) []string {
+	switch x := x.(type) {
+	case nil:
+		return nil
+	case string:
+		if strings.HasPrefix(x, "[") && strings.HasSuffix(x, "]") {
+			var list []string
+			if json.Unmarshal([]byte(x), &list) == nil {
+				return list
+			}
+		}
+		if x == "" {
+			return nil
+		}
+		return []string{x}
+	case []string:
+		return x
+	case []interface{}:
+		values := make([]string, 0, len(x))
+		for _, item := range x {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(x)}
+}
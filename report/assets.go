@@ -0,0 +1,70 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// copyAssets copies the files of the asset directory, including subdirectories, into the
+// temporary directory, so templates can refer to logos and fonts relative to the tex file
+func (rep *report) copyAssets() error {
+	if rep.opts.AssetDir == "" {
+		return nil
+	}
+	slog.Debug("Copying template assets", "dir", rep.opts.AssetDir)
+	return filepath.WalkDir(rep.opts.AssetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rep.opts.AssetDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(rep.tmpDir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0777)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, dst)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening asset %v: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("error creating asset copy %v: %v", dst, err)
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error copying asset %v: %v", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+const imageCacheDir = "image-cache"
+
+// imageCache keeps rendered panel images on disk, so that reports generated again for the
+// same dashboard, time range and variables, by the same user and with the same render
+// settings, do not render them again while they are fresh.
+// Stale images are replaced when the panel is rendered again.
+type imageCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// newImageCache is the cache of the report's options, or nil if caching is disabled
+func newImageCache(opts Options) *imageCache {
+	if opts.ImageCacheTTL <= 0 {
+		return nil
+	}
+	return &imageCache{dir: filepath.Join(baseTempDir(opts), "reporter", imageCacheDir), ttl: opts.ImageCacheTTL}
+}
+
+// imageCacheKey identifies the image of a panel rendered for a time range and variable values,
+// with the client's render key, and whether it is trimmed
+func imageCacheKey(dashUID string, p grafana.Panel, t grafana.TimeRange, variables url.Values, renderKey string, trimmed bool) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%s\n%s\n%s\n%s\n%t", dashUID, p.Id, t.From, t.To, variables.Encode(), p.ScopedVars.Encode(), renderKey, trimmed)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *imageCache) path(key string) string {
+	return filepath.Join(c.dir, key+".png")
+}
+
+// get copies the cached image to dst and returns true, or returns false if there is no fresh one
+func (c *imageCache) get(key, dst string) bool {
+	info, err := os.Stat(c.path(key))
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return false
+	}
+	if err := copyFile(c.path(key), dst); err != nil {
+		slog.Warn("Could not use cached image", "path", c.path(key), "error", err)
+		return false
+	}
+	return true
+}
+
+// put adds the image at src to the cache. Reports rendering the same panel at once may both
+// put it, so it is written to a temporary file first and moved into place.
+func (c *imageCache) put(key, src string) {
+	err := os.MkdirAll(c.dir, 0777)
+	if err != nil {
+		slog.Warn("Could not create image cache directory", "dir", c.dir, "error", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(c.dir, key+".*.tmp")
+	if err != nil {
+		slog.Warn("Could not cache image", "path", src, "error", err)
+		return
+	}
+	tmp.Close()
+	if err = copyFile(src, tmp.Name()); err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Warn("Could not cache image", "path", src, "error", err)
+	}
+}
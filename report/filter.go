@@ -0,0 +1,98 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// PanelFilter selects the panels of the dashboard that are in the report. The zero value
+// selects all panels.
+type PanelFilter struct {
+	Include []int // Only these panel ids, if any
+	Exclude []int // Not these panel ids, even if included
+	// Tags limits the report to panels carrying any of these tags, leaving out untagged panels
+	Tags []string
+}
+
+// Active is true if the filter leaves out any panels
+func (f PanelFilter) Active() bool {
+	return len(f.Include) > 0 || len(f.Exclude) > 0 || len(f.Tags) > 0
+}
+
+// Keeps is true if the panel is in the report
+func (f PanelFilter) Keeps(p grafana.Panel) bool {
+	if len(f.Include) > 0 && !containsID(f.Include, p.Id) {
+		return false
+	}
+	if len(f.Tags) > 0 && !hasAnyTag(p, f.Tags) {
+		return false
+	}
+	return !containsID(f.Exclude, p.Id)
+}
+
+func hasAnyTag(p grafana.Panel, tags []string) bool {
+	for _, tag := range p.Tags {
+		for _, t := range tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseTags parses a comma separated list of tags, such as "exec-summary,sla". Empty is no tags.
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePanelIDs parses a comma separated list of panel ids, such as "2,5,8". Empty is no ids.
+func ParsePanelIDs(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid panel id %q in %q", field, s)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
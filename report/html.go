@@ -0,0 +1,103 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// generateHTML writes the report as an HTML page instead of running LaTeX. Custom TeX
+// templates and assets do not apply to it.
+func (rep *report) generateHTML(ctx context.Context, dash grafana.Dashboard, failedPanels []PanelError) (io.ReadCloser, error) {
+	err := rep.createHTML(ctx, dash, failedPanels)
+	if err != nil {
+		return nil, fmt.Errorf("error creating html file: %w (temp dir: %s)", err, rep.tmpDir)
+	}
+	rep.progress(StageDocument, 1, 1)
+	file, err := os.Open(rep.htmlPath())
+	if err != nil {
+		return nil, fmt.Errorf("error opening html file '%s': %v", rep.htmlPath(), err)
+	}
+	return file, nil
+}
+
+func (rep *report) createHTML(ctx context.Context, dash grafana.Dashboard, failedPanels []PanelError) error {
+	data, err := rep.newTemplData(dash, failedPanels)
+	if err != nil {
+		return err
+	}
+	tmpl, err := parsedHTMLTemplate.Clone()
+	if err != nil {
+		return fmt.Errorf("error cloning html template: %v", err)
+	}
+	tmpl.Funcs(htmlTemplateFuncs(ctx, rep.imgDirPath(), rep.opts, rep.statValues))
+
+	htmlPath := rep.htmlPath()
+	file, err := os.Create(htmlPath)
+	if err != nil {
+		return fmt.Errorf("error creating html file at %v : %v", htmlPath, err)
+	}
+	defer file.Close()
+
+	err = tmpl.Execute(file, data)
+	if err != nil {
+		return fmt.Errorf("error executing html template: %v", err)
+	}
+	slog.Info("Created HTML file", "path", htmlPath)
+	return nil
+}
+
+// parsedHTMLTemplate is the HTML template, parsed once. Reports execute a clone with their own functions.
+var parsedHTMLTemplate = template.Must(template.New(reportHTMLFile).Funcs(htmlTemplateFuncs(context.Background(), "", Options{}, nil)).Parse(htmlTemplate))
+
+// htmlTemplateFuncs are the functions available to the HTML template
+func htmlTemplateFuncs(ctx context.Context, imgDirPath string, opts Options, statValues map[int]string) template.FuncMap {
+	return template.FuncMap{
+		"VariableLabel": variableLabel,
+		"VariableValue": appendixValue,
+		// StatValue is the value of a stat panel shown as text, or "" if it is shown as an image
+		"StatValue": func(panelID int) string {
+			return statValues[panelID]
+		},
+		// PanelImage is the image of the panel as a data URL, or "" if it has none
+		"PanelImage": func(panelID int) template.URL {
+			return panelDataURL(ctx, fmt.Sprintf("%s/image%d.png", imgDirPath, panelID), opts.ImageFormat)
+		},
+		"CaptionTitle": func(p grafana.Panel) string {
+			switch opts.CaptionSource {
+			case CaptionDescription, CaptionNone:
+				return ""
+			}
+			return p.Title
+		},
+		"CaptionDescription": func(p grafana.Panel) string {
+			switch opts.CaptionSource {
+			case CaptionDescription, CaptionBoth:
+				return strings.TrimSpace(p.Description)
+			}
+			return ""
+		},
+	}
+}
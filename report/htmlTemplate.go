@@ -0,0 +1,120 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+// HTML template for the html output format, for both the grid and the row layout
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 1100px; }
+h1, h2 { color: #{{.AccentColor}}; }
+header, section { text-align: center; }
+section { border-top: 1px solid #{{.AccentColor}}; margin-top: 2em; }
+figure { display: inline-block; margin: 1em; vertical-align: top; }
+figure.panel img { width: 90%; }
+figure.stat { width: 30%; }
+figure.stat img { width: 100%; }
+figure.stat .value { font-size: 3em; font-weight: bold; }
+figcaption small { display: block; }
+.watermark { position: fixed; top: 45%; left: 0; right: 0; text-align: center; font-size: 8em; color: rgba(0, 0, 0, 0.1); transform: rotate(-45deg); pointer-events: none; }
+.swatch { display: inline-block; width: 1em; height: 1em; vertical-align: middle; }
+</style>
+</head>
+<body>
+{{with .Watermark}}<div class="watermark">{{.}}</div>{{end}}
+<header>
+<h1>{{.Title}}</h1>
+<p>{{.TimeRangeText}}</p>
+{{with .VariableValues}}<p><big>{{.}}</big></p>{{end}}
+{{with .Description}}<p><small>{{.}}</small></p>{{end}}
+{{with .DashboardURL}}<p><a href="{{.}}">Open this dashboard in Grafana</a></p>{{end}}
+</header>
+
+{{define "panel"}}
+{{with StatValue .Id}}
+<figure class="stat">
+<div class="value">{{.}}</div>
+<figcaption>{{with CaptionTitle $}}<strong>{{.}}</strong>{{end}}{{with CaptionDescription $}}<small>{{.}}</small>{{end}}</figcaption>
+</figure>
+{{else}}{{with PanelImage .Id}}
+<figure class="{{if $.IsSingleStat}}stat{{else}}panel{{end}}">
+<img src="{{.}}" alt="{{$.Title}}">
+<figcaption>{{with CaptionTitle $}}<strong>{{.}}</strong>{{end}}{{with CaptionDescription $}}<small>{{.}}</small>{{end}}</figcaption>
+</figure>
+{{end}}{{end}}
+{{end}}
+
+{{if .UseRowLayout}}
+{{if .ShowRowIntro}}<p>The following sections are taken from the Grafana dashboard.</p>{{end}}
+{{range .Rows}}
+<section>
+<h2>{{.Title}}</h2>
+{{range .ContentPanels}}{{template "panel" .}}{{end}}
+</section>
+{{end}}
+{{else}}
+<section>
+{{range .Panels}}{{template "panel" .}}{{end}}
+</section>
+{{end}}
+
+{{with .Timeline}}
+<section>
+<h2>Annotations</h2>
+<ul>
+{{range .}}<li>{{.Label}}</li>
+{{end}}
+</ul>
+</section>
+{{end}}
+
+{{with .Legend}}
+<section>
+<h2>Legend</h2>
+<ul>
+{{range .}}<li><span class="swatch" style="background: #{{.Color}}"></span> {{.Meaning}}</li>
+{{end}}
+</ul>
+</section>
+{{end}}
+
+{{with .Variables}}
+<section>
+<h2>Dashboard variables</h2>
+<table>
+{{range .}}<tr><th>{{VariableLabel .}}</th><td>{{VariableValue .}}</td></tr>
+{{end}}
+</table>
+</section>
+{{end}}
+
+{{with .FailedPanels}}
+<section>
+<h2>Failed panels</h2>
+<p>The following panels could not be rendered:</p>
+<ul>
+{{range .}}<li>Panel {{.Id}} ({{.Title}}): {{.Err}}</li>
+{{end}}
+</ul>
+</section>
+{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,100 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// ImageFormat is the format HTML reports embed panel images in
+type ImageFormat string
+
+// Image formats. Panels are rendered as PNG images, which the encoders of the other formats
+// convert, if they are installed.
+const (
+	ImagePNG  ImageFormat = "png"
+	ImageWebP ImageFormat = "webp"
+	ImageAVIF ImageFormat = "avif"
+)
+
+// Valid is true for the known image formats and empty
+func (f ImageFormat) Valid() bool {
+	switch f {
+	case "", ImagePNG, ImageWebP, ImageAVIF:
+		return true
+	}
+	return false
+}
+
+// ContentType is the MIME type of images in the format
+func (f ImageFormat) ContentType() string {
+	if f == "" {
+		return "image/png"
+	}
+	return "image/" + string(f)
+}
+
+// Encoder is the program that converts PNG images to the format: cwebp of libwebp for WebP and
+// avifenc of libavif for AVIF. PNG images need none.
+func (f ImageFormat) Encoder() string {
+	switch f {
+	case ImageWebP:
+		return "cwebp"
+	case ImageAVIF:
+		return "avifenc"
+	}
+	return ""
+}
+
+// panelDataURL is the PNG image at path as a data URL in the image format, or "" if there is no
+// image. The PNG is kept if the format's encoder is not installed or fails, or if it does not
+// give a smaller image.
+func panelDataURL(ctx context.Context, path string, format ImageFormat) template.URL {
+	img, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	contentType := ImagePNG.ContentType()
+	if format.Encoder() != "" {
+		if encoded, err := encodeImage(ctx, path, format); err == nil && len(encoded) < len(img) {
+			img, contentType = encoded, format.ContentType()
+		}
+	}
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(img))
+}
+
+// encodeImage converts the PNG image at path to the image format with the format's encoder.
+// WebP images are lossless, so that the text of panels stays sharp.
+func encodeImage(ctx context.Context, path string, format ImageFormat) ([]byte, error) {
+	out := strings.TrimSuffix(path, ".png") + "." + string(format)
+	args := []string{path, out}
+	if format == ImageWebP {
+		args = []string{"-quiet", "-lossless", path, "-o", out}
+	}
+	cmd := exec.CommandContext(ctx, format.Encoder(), args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", format.Encoder(), err, bytes.TrimSpace(output))
+	}
+	return ioutil.ReadFile(out)
+}
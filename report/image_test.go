@@ -0,0 +1,74 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPanelDataURL(t *testing.T) {
+	Convey("When embedding a panel image in an HTML report", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "image1.png")
+		png := "Not actually a png, but longer than its conversion"
+		So(ioutil.WriteFile(path, []byte(png), 0644), ShouldBeNil)
+		dataURL := func(contentType, img string) string {
+			return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString([]byte(img))
+		}
+		// Only the fake encoders installed in dir are found
+		t.Setenv("PATH", dir)
+		installEncoder := func(name, output string) {
+			script := "#!/bin/sh\nfor arg; do out=$arg; done\nprintf '" + output + "' > \"$out\"\n"
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755), ShouldBeNil)
+		}
+
+		Convey("PNG images should be embedded as they are", func() {
+			So(string(panelDataURL(context.Background(), path, ImagePNG)), ShouldEqual, dataURL("image/png", png))
+			So(string(panelDataURL(context.Background(), path, "")), ShouldEqual, dataURL("image/png", png))
+		})
+
+		Convey("Images should be converted by the encoder of the format", func() {
+			installEncoder("cwebp", "RIFF")
+			installEncoder("avifenc", "avif")
+			So(string(panelDataURL(context.Background(), path, ImageWebP)), ShouldEqual, dataURL("image/webp", "RIFF"))
+			So(string(panelDataURL(context.Background(), path, ImageAVIF)), ShouldEqual, dataURL("image/avif", "avif"))
+		})
+
+		Convey("Images should stay PNG without the encoder", func() {
+			So(string(panelDataURL(context.Background(), path, ImageWebP)), ShouldEqual, dataURL("image/png", png))
+		})
+
+		Convey("Images should stay PNG if the format is not smaller", func() {
+			installEncoder("cwebp", strings.Repeat("x", len(png)))
+			So(string(panelDataURL(context.Background(), path, ImageWebP)), ShouldEqual, dataURL("image/png", png))
+		})
+
+		Convey("Panels without an image should have none", func() {
+			So(panelDataURL(context.Background(), filepath.Join(dir, "image2.png"), ImageWebP), ShouldBeEmpty)
+		})
+	})
+}
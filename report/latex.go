@@ -0,0 +1,58 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// LaTeXRunner compiles the tex reports. Run runs one LaTeX pass over texFile, a file name in
+// dir, and returns the path of the PDF it writes and the output of the pass, also on failure.
+// Cancelling the context aborts the pass.
+type LaTeXRunner interface {
+	Run(ctx context.Context, dir, texFile string) (pdfPath string, log []byte, err error)
+}
+
+// execRunner runs a LaTeX engine installed on the machine. It is the default LaTeXRunner.
+type execRunner struct {
+	engine LaTeXEngine
+}
+
+func (r execRunner) Run(ctx context.Context, dir, texFile string) (string, []byte, error) {
+	cmd := exec.CommandContext(ctx, r.engine.Command(), "-interaction=nonstopmode", "-halt-on-error", texFile)
+	cmd.Dir = dir
+	slog.Debug("Running LaTeX", "command", cmd.String(), "dir", dir)
+	out, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		err = fmt.Errorf("%s not found; install TeX Live or use another LaTeX engine: %w", r.engine.Command(), err)
+	}
+	return filepath.Join(dir, strings.TrimSuffix(texFile, filepath.Ext(texFile))+".pdf"), out, err
+}
+
+// latexRunner is the runner of the options, or the configured engine
+func (rep *report) latexRunner() LaTeXRunner {
+	if rep.opts.LaTeXRunner != nil {
+		return rep.opts.LaTeXRunner
+	}
+	return execRunner{engine: rep.opts.LaTeXEngine}
+}
@@ -0,0 +1,68 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LegendEntry explains what a color means in the report's panels
+type LegendEntry struct {
+	Color   string // "#RRGGBB"
+	Meaning string
+}
+
+// ParseLegend reads legend entries, one "#RRGGBB: meaning" per line. Blank lines are skipped.
+func ParseLegend(r io.Reader) ([]LegendEntry, error) {
+	var legend []LegendEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		color, meaning, ok := strings.Cut(text, ":")
+		if !ok || strings.TrimSpace(meaning) == "" {
+			return nil, fmt.Errorf("legend line %d: expected \"#RRGGBB: meaning\", got %q", line, text)
+		}
+		entry := LegendEntry{Color: strings.TrimSpace(color), Meaning: strings.TrimSpace(meaning)}
+		if _, err := LaTeXColor(entry.Color); err != nil {
+			return nil, fmt.Errorf("legend line %d: %v", line, err)
+		}
+		legend = append(legend, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading legend: %v", err)
+	}
+	return legend, nil
+}
+
+// latexLegend converts the legend colors to xcolor HTML model values for the templates
+func latexLegend(legend []LegendEntry) ([]LegendEntry, error) {
+	var converted []LegendEntry
+	for _, entry := range legend {
+		color, err := LaTeXColor(entry.Color)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, LegendEntry{Color: color, Meaning: entry.Meaning})
+	}
+	return converted, nil
+}
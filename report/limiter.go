@@ -0,0 +1,121 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// maxConcurrencyRetries is how often a panel is retried after the renderer reported its
+// concurrency limit. Each time the number of concurrent renders is reduced first.
+const maxConcurrencyRetries = 5
+
+var concurrencyRetrySleepTime = time.Second
+
+// renderLimiter bounds the number of concurrent panel renders. The bound shrinks whenever
+// Grafana's renderer reports its concurrency limit.
+type renderLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	warned bool
+}
+
+func newRenderLimiter(limit int) *renderLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	l := &renderLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free render slot. It gives up, returning the context error, if the
+// context is done by the time a slot is free or a waiter is woken.
+func (l *renderLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.active++
+	return nil
+}
+
+func (l *renderLimiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// reduce halves the limit, relative to the renders in flight, down to a single render. The
+// first reduction is logged as a warning; there is no point repeating it for every panel.
+func (l *renderLimiter) reduce() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := (l.active + 1) / 2
+	if limit < 1 {
+		limit = 1
+	}
+	if limit >= l.limit {
+		return
+	}
+	l.limit = limit
+	if !l.warned {
+		l.warned = true
+		slog.Warn("Grafana's renderer is at its concurrency limit, reducing concurrent panel renders and retrying", "limit", limit)
+	} else {
+		slog.Debug("Reducing concurrent panel renders", "limit", limit)
+	}
+}
+
+// downloadPanelImageLimited downloads the panel image within the limiter's bound, retrying
+// with fewer concurrent renders when the renderer reports its concurrency limit
+func (rep *report) downloadPanelImageLimited(ctx context.Context, l *renderLimiter, p grafana.Panel, dashUID string) error {
+	for retries := 0; ; retries++ {
+		if err := l.acquire(ctx); err != nil {
+			return err
+		}
+		err := rep.downloadPanelImage(ctx, p, dashUID)
+		if !errors.Is(err, grafana.ErrConcurrentRenderLimit) || retries == maxConcurrencyRetries {
+			l.release()
+			return err
+		}
+		// Reduce while this render still counts as in flight, then make room for the others
+		l.reduce()
+		l.release()
+		select {
+		case <-time.After(concurrencyRetrySleepTime * time.Duration(retries+1)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+var (
+	headingRegExp     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	bulletRegExp      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numberedRegExp    = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	inlineRegExp      = regexp.MustCompile("\\*\\*(.+?)\\*\\*|__(.+?)__|\\*(.+?)\\*|`([^`]+)`|\\[([^\\]]*)\\]\\([^)]*\\)")
+	htmlBlockRegExp   = regexp.MustCompile(`(?i)<\s*(br|/?p|/?div|/?h[1-6]|/?ul|/?ol|/?table|/?tr)\b[^>]*>`)
+	htmlItemRegExp    = regexp.MustCompile(`(?i)<\s*li\b[^>]*>`)
+	htmlTagRegExp     = regexp.MustCompile(`<[^>]*>`)
+	htmlCommentRegExp = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// textPanelLaTeX renders the content of a text panel as LaTeX. Markdown keeps its headings,
+// bold and italic text, code and lists; other markup is dropped. HTML content is reduced to
+// its text, keeping paragraphs and list items.
+func textPanelLaTeX(p grafana.Panel) string {
+	content, mode := p.TextContent()
+	if mode == "html" {
+		return htmlToLaTeX(content)
+	}
+	return markdownToLaTeX(content)
+}
+
+// markdownToLaTeX converts basic markdown to escaped LaTeX
+func markdownToLaTeX(md string) string {
+	var out []string
+	list := "" // Environment of the open list, if any
+	closeList := func() {
+		if list != "" {
+			out = append(out, `\end{`+list+`}`)
+			list = ""
+		}
+	}
+	openList := func(env string) {
+		if list != env {
+			closeList()
+			out = append(out, `\begin{`+env+`}`)
+			list = env
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue // Code blocks are kept as plain text
+		}
+		if m := bulletRegExp.FindStringSubmatch(line); m != nil {
+			openList("itemize")
+			out = append(out, `\item `+inlineMarkdown(m[1]))
+			continue
+		}
+		if m := numberedRegExp.FindStringSubmatch(line); m != nil {
+			openList("enumerate")
+			out = append(out, `\item `+inlineMarkdown(m[1]))
+			continue
+		}
+		closeList()
+		if m := headingRegExp.FindStringSubmatch(line); m != nil {
+			out = append(out, markdownHeading(len(m[1]), inlineMarkdown(m[2])))
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			out = append(out, "")
+			continue
+		}
+		out = append(out, inlineMarkdown(strings.TrimSpace(line)))
+	}
+	closeList()
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// markdownHeading is a heading of the level, below the sections the templates use
+func markdownHeading(level int, text string) string {
+	switch level {
+	case 1:
+		return `\subsection*{` + text + `}`
+	case 2:
+		return `\subsubsection*{` + text + `}`
+	}
+	return `\paragraph*{` + text + `}`
+}
+
+// inlineMarkdown escapes a line of markdown, turning its emphasis and code into LaTeX. Links
+// are reduced to their text.
+func inlineMarkdown(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range inlineRegExp.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(grafana.SanitizeLaTexInput(s[last:m[0]]))
+		group := func(i int) string { return grafana.SanitizeLaTexInput(s[m[2*i]:m[2*i+1]]) }
+		switch {
+		case m[2] >= 0:
+			b.WriteString(`\textbf{` + group(1) + `}`)
+		case m[4] >= 0:
+			b.WriteString(`\textbf{` + group(2) + `}`)
+		case m[6] >= 0:
+			b.WriteString(`\emph{` + group(3) + `}`)
+		case m[8] >= 0:
+			b.WriteString(`\texttt{` + group(4) + `}`)
+		default:
+			b.WriteString(group(5))
+		}
+		last = m[1]
+	}
+	b.WriteString(grafana.SanitizeLaTexInput(s[last:]))
+	return b.String()
+}
+
+// htmlToLaTeX reduces HTML to escaped LaTeX paragraphs and list items
+func htmlToLaTeX(h string) string {
+	h = htmlCommentRegExp.ReplaceAllString(h, "")
+	h = htmlBlockRegExp.ReplaceAllString(h, "\n\n")
+	h = htmlItemRegExp.ReplaceAllString(h, "\n\n- ")
+	h = htmlTagRegExp.ReplaceAllString(h, "")
+	var paragraphs []string
+	for _, para := range strings.Split(html.UnescapeString(h), "\n\n") {
+		para = strings.Join(strings.Fields(para), " ")
+		if para == "" {
+			continue
+		}
+		if strings.HasPrefix(para, "- ") {
+			para = `\textbullet{} ` + grafana.SanitizeLaTexInput(strings.TrimPrefix(para, "- "))
+		} else {
+			para = grafana.SanitizeLaTexInput(para)
+		}
+		paragraphs = append(paragraphs, para)
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
@@ -0,0 +1,66 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics of report generation, registered with the default registry
+var (
+	reportsGenerated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grafana_reporter_reports_generated_total",
+		Help: "Reports generated successfully, by output format.",
+	}, []string{"format"})
+	reportsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grafana_reporter_reports_failed_total",
+		Help: "Reports that failed to generate, by output format.",
+	}, []string{"format"})
+	reportDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grafana_reporter_report_duration_seconds",
+		Help:    "Time taken to generate a report, successful or not, by output format.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10), // 0.5s to about 4m
+	}, []string{"format"})
+	reportPanelCount = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grafana_reporter_report_panels",
+		Help:    "Panel images rendered per report.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8), // 1 to 128
+	})
+	panelDownloadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "grafana_reporter_panel_download_failures_total",
+		Help: "Panel images that failed to render after all retries.",
+	})
+	latexDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grafana_reporter_latex_duration_seconds",
+		Help:    "Time taken by LaTeX to compile a PDF report, all passes included.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 8), // 0.25s to 32s
+	})
+)
+
+// observeReport records a finished report, err being the error it failed with, if any
+func observeReport(format OutputFormat, start time.Time, err error) {
+	label := format.Extension()
+	reportDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		reportsFailed.WithLabelValues(label).Inc()
+	} else {
+		reportsGenerated.WithLabelValues(label).Inc()
+	}
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/pborman/uuid"
+)
+
+// multiReport combines the PDF reports of several dashboards into one PDF. Each dashboard
+// starts on a new page and gets a bookmark in the PDF outline.
+type multiReport struct {
+	reports []Report
+	titles  []string
+	// combiner compiles the combining tex file in the temporary directory of the report
+	combiner *report
+}
+
+// NewMulti creates a report combining the reports, typically of different dashboards, in the
+// given order. Each report keeps its own layout and template. Only PDF reports can be combined.
+func NewMulti(reports []Report, opts Options) Report {
+	tmpDir := filepath.Join(baseTempDir(opts), "reporter", uuid.New())
+	slog.Debug("Combined report temporary directory", "dir", tmpDir)
+	return &multiReport{
+		reports:  reports,
+		combiner: &report{tmpDir: tmpDir, opts: opts},
+	}
+}
+
+func (m *multiReport) Title() string {
+	return strings.Join(m.titles, ", ")
+}
+
+func (m *multiReport) Clean() {
+	for _, rep := range m.reports {
+		rep.Clean()
+	}
+	m.combiner.Clean()
+}
+
+// dashboardPDF is the file name of the PDF of the i-th report, counting from 1
+func dashboardPDF(i int) string {
+	return fmt.Sprintf("dashboard%d.pdf", i)
+}
+
+func (m *multiReport) Generate(ctx context.Context) (pdf io.ReadCloser, err error) {
+	if m.combiner.opts.Format.Extension() != string(FormatPDF) {
+		return nil, fmt.Errorf("only pdf reports can combine several dashboards, got format %s", m.combiner.opts.Format)
+	}
+	if err := os.MkdirAll(m.combiner.tmpDir, 0777); err != nil {
+		return nil, fmt.Errorf("error creating temporary directory at %v: %v", m.combiner.tmpDir, err)
+	}
+
+	defer func() {
+		if err != nil {
+			m.Clean()
+		}
+	}()
+
+	m.titles = nil
+	for i, rep := range m.reports {
+		if err := m.generatePart(ctx, i+1, rep); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.createTex(); err != nil {
+		return nil, fmt.Errorf("%w: error creating tex file: %w (temp dir: %s)", ErrLaTeX, err, m.combiner.tmpDir)
+	}
+	pdfFile, err := m.combiner.runLaTeX(ctx)
+	if err != nil {
+		slog.Error("LaTeX failed", "dir", m.combiner.tmpDir)
+		return nil, fmt.Errorf("%w: error running LaTeX: %w", ErrLaTeX, err)
+	}
+	return &cleaningFile{ReadCloser: pdfFile, clean: m.Clean}, nil
+}
+
+// generatePart generates the i-th report and copies its PDF into the temporary directory
+func (m *multiReport) generatePart(ctx context.Context, i int, rep Report) error {
+	file, err := rep.Generate(ctx)
+	if err != nil {
+		return fmt.Errorf("dashboard %d of %d: %w", i, len(m.reports), err)
+	}
+	defer rep.Clean()
+	defer file.Close()
+	m.titles = append(m.titles, rep.Title())
+
+	dst, err := os.Create(filepath.Join(m.combiner.tmpDir, dashboardPDF(i)))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, file); err != nil {
+		return fmt.Errorf("error copying the report of dashboard %d: %v", i, err)
+	}
+	return nil
+}
+
+// multiTemplate includes the PDFs of the dashboards, each with a bookmark named by its title
+const multiTemplate = `\documentclass{article}
+\usepackage{pdfpages}
+\usepackage[hidelinks,bookmarks=true]{hyperref}
+\hypersetup{pdftitle={[[ EscapeLaTeX .Title ]]}, pdfauthor={[[ EscapeLaTeX .Author ]]}}
+\begin{document}
+[[range $i, $title := .Titles]]\includepdf[pages=-,addtotoc={1,section,1,{[[ EscapeLaTeX $title ]]},dashboard[[ Part $i ]]}]{[[ DashboardPDF $i ]]}
+[[end]]\end{document}
+`
+
+func (m *multiReport) createTex() error {
+	tmpl, err := template.New(reportTexFile).Delims("[[", "]]").Funcs(template.FuncMap{
+		"EscapeLaTeX":  grafana.SanitizeLaTexInput,
+		"Part":         func(i int) int { return i + 1 },
+		"DashboardPDF": func(i int) string { return dashboardPDF(i + 1) },
+	}).Parse(multiTemplate)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(m.combiner.texPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return tmpl.Execute(file, struct {
+		Title  string
+		Author string
+		Titles []string
+	}{m.Title(), pdfAuthor(m.combiner.opts.Author), m.titles})
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size and text layout of placeholder images
+const (
+	placeholderWidth    = 1000
+	placeholderHeight   = 500
+	placeholderMargin   = 20
+	placeholderMaxLines = 20
+)
+
+// writePlaceholderImage writes a placeholder in place of the image of a panel that could not
+// be downloaded, so the report still compiles and shows which panel failed and why
+func (rep *report) writePlaceholderImage(p grafana.Panel, renderErr error) {
+	if rep.opts.NoPlaceholderImages || rep.opts.Strict {
+		return
+	}
+	imgPath := rep.imgFilePath(p.Id)
+	file, err := os.Create(imgPath)
+	if err != nil {
+		slog.Warn("Could not create placeholder image", "path", imgPath, "panel", p.Id, "error", err)
+		return
+	}
+	defer file.Close()
+	if err := writePlaceholder(file, p, renderErr); err != nil {
+		slog.Warn("Could not write placeholder image", "path", imgPath, "panel", p.Id, "error", err)
+		return
+	}
+	slog.Debug("Wrote placeholder image", "panel", p.Id)
+}
+
+// writePlaceholder encodes a gray PNG with the panel title and the error text
+func writePlaceholder(w io.Writer, p grafana.Panel, renderErr error) error {
+	img := image.NewRGBA(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{0xdd}}, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(color.Gray{0x33}), Face: face}
+	lineHeight := face.Metrics().Height.Ceil() + 4
+	maxChars := (placeholderWidth - 2*placeholderMargin) / face.Advance
+
+	lines := []string{fmt.Sprintf("Panel %d could not be rendered", p.Id)}
+	if p.Title != "" {
+		lines = append(lines, wrapText(p.Title, maxChars)...)
+	}
+	lines = append(lines, "")
+	lines = append(lines, wrapText(renderErr.Error(), maxChars)...)
+	if len(lines) > placeholderMaxLines {
+		lines = append(lines[:placeholderMaxLines-1], "...")
+	}
+
+	y := placeholderMargin + face.Ascent
+	for _, line := range lines {
+		d.Dot = fixed.P(placeholderMargin, y)
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return png.Encode(w, img)
+}
+
+// wrapText breaks text into lines of at most width characters, at spaces where possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for len(word) > width { // Break words that do not fit on a line by themselves
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, word[:width])
+				word = word[width:]
+			}
+			switch {
+			case line == "":
+				line = word
+			case len(line)+1+len(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
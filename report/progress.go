@@ -0,0 +1,67 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"sync"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// ProgressFunc is called as the steps of generating a report complete: done of the total
+// steps of the stage, e.g. 3 of 12 panel images. Calls are not concurrent.
+type ProgressFunc func(stage string, done, total int)
+
+// Stages reported to a ProgressFunc. StageDocument is the tex, HTML or ZIP file being
+// created and StageLaTeX counts the LaTeX passes, which only PDF reports have.
+const (
+	StageDashboard = "dashboard"
+	StageImages    = "images"
+	StageDocument  = "document"
+	StageLaTeX     = "latex"
+)
+
+func (rep *report) progress(stage string, done, total int) {
+	if rep.opts.Progress != nil {
+		rep.opts.Progress(stage, done, total)
+	}
+}
+
+// imageProgress counts the panel image downloads that completed, successfully or not
+type imageProgress struct {
+	mu    sync.Mutex
+	rep   *report
+	done  int
+	total int
+}
+
+func (rep *report) newImageProgress(dash grafana.Dashboard) *imageProgress {
+	total := 0
+	for _, p := range rep.reportPanels(dash) {
+		if p.IsRenderable() {
+			total++
+		}
+	}
+	return &imageProgress{rep: rep, total: total}
+}
+
+func (p *imageProgress) add() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.rep.progress(StageImages, p.done, p.total)
+}
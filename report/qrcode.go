@@ -0,0 +1,34 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"path/filepath"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrCodeFile is the QR code of the dashboard link, next to the tex file
+const qrCodeFile = "qrcode.png"
+
+// qrCodeSize is the pixel size of the QR code image, enough to print it sharply at a few cm
+const qrCodeSize = 512
+
+// writeQRCode writes the QR code of the link to qrCodeFile in the temporary directory
+func (rep *report) writeQRCode(link string) error {
+	return qrcode.WriteFile(link, qrcode.Medium, qrCodeSize, filepath.Join(rep.tmpDir, qrCodeFile))
+}
@@ -17,28 +17,280 @@
 package report
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"math"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/pborman/uuid"
 )
 
-// Report interface (keep as is)
+// Report interface. Closing the file returned by Generate cleans the report, removing its
+// temporary directory unless KeepTemp is set. Generate cleans up itself when it fails.
 type Report interface {
-	Generate() (pdf io.ReadCloser, err error)
+	Generate(ctx context.Context) (pdf io.ReadCloser, err error)
 	Title() string
 	Clean()
 }
 
+// Options holds optional report settings. The zero value gives the default report.
+type Options struct {
+	HideRowIntro bool // Omit the explanatory paragraph of the row-based template
+	// ImageFormat is the format HTML reports embed panel images in, to keep pages small. Images
+	// stay PNG where the encoder is not installed or gives no smaller image. Empty is PNG.
+	ImageFormat ImageFormat
+	// TableOfContents adds a hyperlinked table of contents of the rows to the row-based template
+	TableOfContents bool
+	// MaxLaTeXRecoveries is how often a failed LaTeX run may be retried after replacing the
+	// image that caused the error with a placeholder. Zero fails on the first error.
+	MaxLaTeXRecoveries int
+	// LaTeXPasses is how many times LaTeX compiles PDF reports. Further passes, up to
+	// maxLaTeXPasses, follow while LaTeX asks for a rerun to get cross-references right.
+	// Zero is two passes.
+	LaTeXPasses int
+	// AccentColor is the "#RRGGBB" color of the title, section headers and rules of the
+	// built-in templates. Empty is black.
+	AccentColor string
+	// Author is the author in the metadata of PDF reports. Empty is "Grafana Reporter".
+	Author string
+	// FooterText is printed in the center of the page footer. Empty is "Generated by Grafana
+	// Reporter".
+	FooterText string
+	// HeaderImage is the absolute path of an image shown in the page header of the row-based
+	// template. Empty shows no header.
+	HeaderImage string
+	// Timezone is the IANA zone the time range is shown in, as panels are rendered in it.
+	// grafana.DashboardTimezone uses the dashboard's timezone. Empty is UTC.
+	Timezone string
+	// CoverPage opens PDF reports with a page of the dashboard title, the time range and the
+	// generation date
+	CoverPage bool
+	// QRCode adds a QR code of the dashboard link to PDF reports, on the cover page if there is
+	// one and under the title otherwise. It needs the PublicURL for the link.
+	QRCode bool
+	// CoverLogo is the absolute path of an image shown above the title on the cover page. Empty
+	// shows no logo.
+	CoverLogo string
+	// Watermark is text, e.g. CONFIDENTIAL, printed diagonally across every page. Empty
+	// prints none.
+	Watermark string
+	// Legend adds a page explaining what colors mean. Empty omits the page.
+	Legend []LegendEntry
+	// AssetDir is a directory of logos, fonts and other files that is copied next to the tex
+	// file, so templates can include them by relative path. Empty copies nothing.
+	AssetDir string
+	// CaptionSource selects what is shown under each panel image. Empty shows the title.
+	CaptionSource CaptionSource
+	// AnnotationTimeline adds a figure marking the dashboard's annotations, such as deploys
+	// and incidents, on the report's time range
+	AnnotationTimeline bool
+	// PublicURL is the Grafana base URL users can reach, used for links in the report. It may
+	// differ from the URL the client uses for API calls. Empty leaves links out.
+	PublicURL string
+	// Panels selects the panels in the report. The zero value selects all panels.
+	Panels PanelFilter
+	// MaxRenders is how many panels may be rendered at once. Zero does not limit renders,
+	// other than to the concurrency limit the renderer reports.
+	MaxRenders int
+	// TempDir is the base directory of the reports' temporary directories. Empty is the
+	// system's temporary directory.
+	TempDir string
+	// ImageCacheTTL is how long rendered panel images are kept in an image-cache directory
+	// next to the temporary directories, for reports of the same dashboard, time range,
+	// variables, credentials and render settings to use instead of rendering them again. Zero
+	// disables the cache.
+	ImageCacheTTL time.Duration
+	// KeepTemp keeps the temporary directory, with the tex file, images and LaTeX log, when
+	// the report is cleaned, for diagnosing failed reports
+	KeepTemp bool
+	// Progress, if set, is called as the stages of generating the report complete
+	Progress ProgressFunc
+	// PaperSize is the paper of PDF reports. Empty is letter.
+	PaperSize PaperSize
+	// Margin is the page margin of PDF reports as a TeX length, e.g. 2cm. Empty is 1in for the
+	// grid layout and 0.5in for the row layout.
+	Margin string
+	// LaTeXEngine compiles PDF reports. Empty is pdflatex.
+	LaTeXEngine LaTeXEngine
+	// LaTeXRunner, if set, compiles PDF reports instead of running LaTeXEngine, e.g. a fake
+	// runner in tests that have no TeX installation
+	LaTeXRunner LaTeXRunner
+	// Format is the output format of the report. Empty is PDF.
+	Format OutputFormat
+	// NoPlaceholderImages leaves out the image of panels that fail to render, instead of
+	// showing a placeholder with the error
+	NoPlaceholderImages bool
+	// Strict fails the report if any panel fails to render, instead of generating it without
+	// the panel
+	Strict bool
+	// VariablesAppendix adds a page listing the dashboard variables with their full selected
+	// values, which the line of variables under the title may not fit
+	VariablesAppendix bool
+	// StatAsText shows singlestat, stat and gauge panels as their current value in large text
+	// instead of a rendered image. Panels whose value cannot be queried are still rendered.
+	StatAsText bool
+	// DashboardImage renders the whole dashboard as one image instead of each panel, for a
+	// quick overview of dashboards with many small panels. Only PDF reports support it.
+	DashboardImage bool
+	// TrimImages crops the uniform border, such as the padding of Grafana's solo renders, from
+	// downloaded panel images so that they stack without uneven gaps
+	TrimImages bool
+}
+
+// CaptionSource selects the text shown under panel images
+type CaptionSource string
+
+// Caption sources. CaptionBoth shows the title in bold with the description beneath it.
+const (
+	CaptionTitle       CaptionSource = "title"
+	CaptionDescription CaptionSource = "description"
+	CaptionBoth        CaptionSource = "both"
+	CaptionNone        CaptionSource = "none"
+)
+
+// Valid is true for the known caption sources and empty
+func (c CaptionSource) Valid() bool {
+	switch c {
+	case "", CaptionTitle, CaptionDescription, CaptionBoth, CaptionNone:
+		return true
+	}
+	return false
+}
+
+// OutputFormat is the kind of document a report is generated as
+type OutputFormat string
+
+// Output formats. HTML reports inline the panel images, so they are a single file and do not
+// need LaTeX. ZIP reports are an archive of the panel images only.
+const (
+	FormatPDF  OutputFormat = "pdf"
+	FormatHTML OutputFormat = "html"
+	FormatZIP  OutputFormat = "zip"
+)
+
+// Valid is true for the known output formats and empty
+func (f OutputFormat) Valid() bool {
+	switch f {
+	case "", FormatPDF, FormatHTML, FormatZIP:
+		return true
+	}
+	return false
+}
+
+// Extension is the file name extension of reports in the format, without the dot
+func (f OutputFormat) Extension() string {
+	if f == "" {
+		return string(FormatPDF)
+	}
+	return string(f)
+}
+
+// ContentType is the MIME type of reports in the format
+func (f OutputFormat) ContentType() string {
+	switch f {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatZIP:
+		return "application/zip"
+	}
+	return "application/pdf"
+}
+
+// LaTeXEngine is the LaTeX program that compiles PDF reports
+type LaTeXEngine string
+
+// LaTeX engines. XeLaTeX and LuaLaTeX typeset Unicode, e.g. CJK titles, with system fonts.
+const (
+	EnginePDFLaTeX LaTeXEngine = "pdflatex"
+	EngineXeLaTeX  LaTeXEngine = "xelatex"
+	EngineLuaLaTeX LaTeXEngine = "lualatex"
+)
+
+// Valid is true for the known engines and empty
+func (e LaTeXEngine) Valid() bool {
+	switch e {
+	case "", EnginePDFLaTeX, EngineXeLaTeX, EngineLuaLaTeX:
+		return true
+	}
+	return false
+}
+
+// Command is the name of the engine's executable
+func (e LaTeXEngine) Command() string {
+	if e == "" {
+		return string(EnginePDFLaTeX)
+	}
+	return string(e)
+}
+
+// Unicode is true for engines that read Unicode input natively, which load fonts with
+// fontspec rather than inputenc
+func (e LaTeXEngine) Unicode() bool {
+	return e == EngineXeLaTeX || e == EngineLuaLaTeX
+}
+
+// PaperSize is the paper PDF reports are typeset on
+type PaperSize string
+
+// Paper sizes
+const (
+	PaperLetter PaperSize = "letter"
+	PaperA4     PaperSize = "a4"
+)
+
+// Valid is true for the known paper sizes and empty
+func (p PaperSize) Valid() bool {
+	switch p {
+	case "", PaperLetter, PaperA4:
+		return true
+	}
+	return false
+}
+
+// Geometry is the name of the paper in the options of the geometry package
+func (p PaperSize) Geometry() string {
+	if p == "" {
+		return string(PaperLetter) + "paper"
+	}
+	return string(p) + "paper"
+}
+
+var marginRegExp = regexp.MustCompile(`^\d*\.?\d+(in|cm|mm|pt|bp|em)$`)
+
+// ValidMargin is true for TeX lengths in the units margins are usually given in, e.g. 2cm, and empty
+func ValidMargin(margin string) bool {
+	return margin == "" || marginRegExp.MatchString(margin)
+}
+
+// pageMargin is the margin of the options, or the default of the layout
+func pageMargin(margin string, useRowLayout bool) (string, error) {
+	if !ValidMargin(margin) {
+		return "", fmt.Errorf("invalid margin %q: expected a length such as 2cm or 0.75in", margin)
+	}
+	if margin != "" {
+		return margin, nil
+	}
+	if useRowLayout {
+		return "0.5in", nil
+	}
+	return "1in", nil
+}
+
 // report struct (keep as is)
 type report struct {
 	gClient      grafana.Client
@@ -48,27 +300,57 @@ type report struct {
 	tmpDir       string
 	dashTitle    string
 	useRowLayout bool
+	opts         Options
+	annotations  []grafana.Annotation
+	cache        *imageCache // nil without image caching
+	variables    url.Values  // Requested variable values, part of the image cache key
+	statMu       sync.Mutex
+	statValues   map[int]string // Formatted values of the panels shown as text, by panel id
+}
+
+// PanelError is a panel that could not be rendered
+type PanelError struct {
+	Id    int
+	Title string
+	Err   error
+}
+
+func (e PanelError) Error() string {
+	return fmt.Sprintf("panel %d ('%s'): %v", e.Id, e.Title, e.Err)
 }
 
+func (e PanelError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returned by Generate wrap one of these, so callers can tell which stage failed
+var (
+	ErrDashboard = errors.New("dashboard fetch failed")
+	ErrRender    = errors.New("panel rendering failed")
+	ErrLaTeX     = errors.New("LaTeX compilation failed")
+)
+
 // Constants (keep as is)
 const (
-	imgDir        = "images"
-	reportTexFile = "report.tex"
-	reportPdfFile = "report.pdf"
-	logFile       = "pdflatex.log"
+	imgDir         = "images"
+	reportTexFile  = "report.tex"
+	reportPdfFile  = "report.pdf"
+	reportHTMLFile = "report.html"
+	reportZIPFile  = "report.zip"
+	logFile        = "pdflatex.log"
 )
 
 // New function (keep as is)
-func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplatePath string, useRowLayout bool) Report {
-	tmpDir := filepath.Join(os.TempDir(), "reporter", uuid.New())
-	log.Println("Report temporary directory:", tmpDir)
+func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplatePath string, useRowLayout bool, opts Options) Report {
+	tmpDir := filepath.Join(baseTempDir(opts), "reporter", uuid.New())
+	slog.Debug("Report temporary directory", "dir", tmpDir)
 
 	var templateContent string
 	if texTemplatePath != "" {
-		log.Println("Using custom template:", texTemplatePath)
+		slog.Info("Using custom template", "template", texTemplatePath)
 		content, err := ioutil.ReadFile(texTemplatePath)
 		if err != nil {
-			log.Printf("Warning: Failed to read custom template '%s': %v. Falling back to default.", texTemplatePath, err)
+			slog.Warn("Failed to read custom template, falling back to the default", "template", texTemplatePath, "error", err)
 			if useRowLayout {
 				templateContent = rowBasedTemplate
 			} else {
@@ -79,10 +361,10 @@ func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplateP
 		}
 	} else {
 		if useRowLayout {
-			log.Println("Using built-in row-based template.")
+			slog.Debug("Using built-in row-based template")
 			templateContent = rowBasedTemplate
 		} else {
-			log.Println("Using built-in grid-based template.")
+			slog.Debug("Using built-in grid-based template")
 			templateContent = defaultTemplate
 		}
 	}
@@ -94,56 +376,121 @@ func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplateP
 		dashName:     dashName,
 		tmpDir:       tmpDir,
 		useRowLayout: useRowLayout,
+		opts:         opts,
+		cache:        newImageCache(opts),
 	}
 }
 
+// baseTempDir is the directory the temporary directories of reports are created in
+func baseTempDir(opts Options) string {
+	if opts.TempDir != "" {
+		return opts.TempDir
+	}
+	return os.TempDir()
+}
+
 // Title function (keep as is)
 func (rep *report) Title() string {
 	return rep.dashTitle
 }
 
 // Generate function (keep as is)
-func (rep *report) Generate() (pdf io.ReadCloser, err error) {
-	dash, err := rep.gClient.GetDashboard(rep.dashName)
+func (rep *report) Generate(ctx context.Context) (pdf io.ReadCloser, err error) {
+	start := time.Now()
+	defer func() { observeReport(rep.opts.Format, start, err) }()
+	defer func() {
+		if err != nil {
+			rep.Clean() // A failed report leaves nothing behind, unless KeepTemp is set
+		}
+	}()
+	dash, err := rep.gClient.GetDashboard(ctx, rep.dashName)
 	if err != nil {
-		rep.Clean()
-		return nil, fmt.Errorf("error getting dashboard: %v", err)
+		return nil, fmt.Errorf("%w: error getting dashboard: %w", ErrDashboard, err)
 	}
 	rep.dashTitle = dash.Title
+	rep.variables = dash.Variables()
+	rep.progress(StageDashboard, 1, 1)
+	if rep.opts.Panels.Active() {
+		dash = dash.FilterPanels(rep.opts.Panels.Keeps)
+	}
 	dashUID := dash.Uid
 	if dashUID == "" {
-		log.Printf("Warning: Dashboard UID is empty after fetching '%s'. Rendering might fail.", rep.dashName)
+		slog.Warn("Dashboard UID is empty after fetching it, rendering might fail", "dashboard", rep.dashName)
 		dashUID = rep.dashName
 	}
 
-	err = rep.fetchImages(dash, dashUID)
+	var failedPanels []PanelError
+	if rep.opts.DashboardImage {
+		err = rep.downloadDashboardImage(ctx, dashUID)
+	} else {
+		failedPanels, err = rep.fetchImages(ctx, dash, dashUID)
+	}
 	if err != nil {
-		rep.Clean()
-		return nil, fmt.Errorf("error fetching panel images: %v", err)
+		return nil, fmt.Errorf("%w: error fetching panel images: %w", ErrRender, err)
+	}
+
+	if rep.opts.Format == FormatZIP {
+		return rep.cleanOnClose(rep.generateZIP(dash))
+	}
+
+	rep.fetchAnnotations(ctx, dashUID)
+
+	if rep.opts.Format == FormatHTML {
+		return rep.cleanOnClose(rep.generateHTML(ctx, dash, failedPanels))
 	}
 
-	err = rep.createTex(dash)
+	err = rep.createTex(dash, failedPanels)
 	if err != nil {
-		rep.Clean()
-		return nil, fmt.Errorf("error creating tex file: %v (temp dir: %s)", err, rep.tmpDir)
+		return nil, fmt.Errorf("%w: error creating tex file: %w (temp dir: %s)", ErrLaTeX, err, rep.tmpDir)
 	}
+	rep.progress(StageDocument, 1, 1)
 
-	pdfFile, err := rep.runLaTeX()
+	if err = rep.checkImgDir(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLaTeX, err)
+	}
+	pdfFile, err := rep.runLaTeX(ctx)
 	if err != nil {
-		log.Printf("LaTeX failed. Temporary files are in %s", rep.tmpDir)
-		return nil, fmt.Errorf("error running LaTeX: %v", err)
+		slog.Error("LaTeX failed", "dir", rep.tmpDir)
+		return nil, fmt.Errorf("%w: error running LaTeX: %w", ErrLaTeX, err)
 	}
 
-	return pdfFile, nil
+	return rep.cleanOnClose(pdfFile, nil)
+}
+
+// cleaningFile is a generated report that cleans up after the report when it is closed, so
+// that its temporary directory does not outlive the reader
+type cleaningFile struct {
+	io.ReadCloser
+	clean func()
+	once  sync.Once
+}
+
+func (f *cleaningFile) Close() error {
+	err := f.ReadCloser.Close()
+	f.once.Do(f.clean)
+	return err
+}
+
+// cleanOnClose makes the generated file clean the report when it is closed
+func (rep *report) cleanOnClose(file io.ReadCloser, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &cleaningFile{ReadCloser: file, clean: rep.Clean}, nil
 }
 
-// Clean function (keep as is)
+// Clean removes the temporary directory of the report, unless KeepTemp is set. It may be
+// called more than once.
 func (rep *report) Clean() {
+	if rep.opts.KeepTemp {
+		slog.Info("Keeping temporary directory", "dir", rep.tmpDir)
+		return
+	}
 	err := os.RemoveAll(rep.tmpDir)
 	if err != nil {
-		log.Printf("Warning: Could not clean up temporary directory '%s': %v", rep.tmpDir, err)
+		slog.Warn("Could not clean up temporary directory", "dir", rep.tmpDir, "error", err)
 	} else {
-		log.Println("Cleaned up temporary directory:", rep.tmpDir)
+		slog.Debug("Cleaned up temporary directory", "dir", rep.tmpDir)
 	}
 }
 
@@ -162,6 +509,12 @@ func (rep *report) rowImgFilePath(rowID int) string {
 func (rep *report) texPath() string {
 	return filepath.Join(rep.tmpDir, reportTexFile)
 }
+func (rep *report) htmlPath() string {
+	return filepath.Join(rep.tmpDir, reportHTMLFile)
+}
+func (rep *report) zipPath() string {
+	return filepath.Join(rep.tmpDir, reportZIPFile)
+}
 func (rep *report) pdfPath() string {
 	return filepath.Join(rep.tmpDir, reportPdfFile)
 }
@@ -170,91 +523,138 @@ func (rep *report) logPath() string {
 }
 
 // fetchImages function (keep as is)
-func (rep *report) fetchImages(dash grafana.Dashboard, dashUID string) error {
+// fetchImages downloads the panel images concurrently. When the context is cancelled, no
+// further downloads are started and the error of the context is returned. Panels that fail
+// to download are returned by id for the report to list, unless the report is strict: then
+// their errors fail it.
+func (rep *report) fetchImages(ctx context.Context, dash grafana.Dashboard, dashUID string) ([]PanelError, error) {
 	imgDirPath := rep.imgDirPath()
 	err := os.MkdirAll(imgDirPath, 0777)
 	if err != nil {
-		return fmt.Errorf("error creating image directory at %v: %v", imgDirPath, err)
+		return nil, fmt.Errorf("error creating image directory at %v: %v", imgDirPath, err)
 	}
 
 	var wg sync.WaitGroup
-	errorChannel := make(chan error, 100)
-	log.Println("Downloading images...")
+	var mu sync.Mutex
+	var failed []PanelError // Guarded by mu, as any number of panels may fail
+	fail := func(panel grafana.Panel, err error) {
+		slog.Warn("Failed to download panel image", "panel", panel.Id, "title", panel.Title, "error", err)
+		rep.writePlaceholderImage(panel, err)
+		mu.Lock()
+		failed = append(failed, PanelError{Id: panel.Id, Title: panel.Title, Err: err})
+		mu.Unlock()
+	}
+	limiter := newRenderLimiter(rep.maxRenders())
+	progress := rep.newImageProgress(dash)
+	slog.Debug("Downloading images")
 
 	if rep.useRowLayout {
 		rowsToProcess := dash.GetRows()
 		if len(rowsToProcess) == 0 {
-			log.Println("Warning: Row layout selected, but no rows found to process.")
-			return nil
+			slog.Warn("Row layout selected, but the dashboard has no rows")
+			return nil, nil
 		}
-		log.Printf("Fetching images for panels within %d rows...", len(rowsToProcess))
+		slog.Debug("Fetching images of the panels in rows", "rows", len(rowsToProcess))
 		panelCount := 0
+	rows:
 		for _, row := range rowsToProcess {
-			log.Printf("Processing panels for row %d ('%s')", row.Id, row.Title)
+			slog.Debug("Processing panels of row", "row", row.Id, "title", row.Title)
 			for _, p := range row.ContentPanels {
-				if p.Type == "text" {
-					log.Printf("Skipping image download for text panel in row %d: %d (%s)", row.Id, p.Id, p.Title)
+				if ctx.Err() != nil {
+					break rows
+				}
+				if !p.IsRenderable() {
+					slog.Debug("Skipping image download for text panel", "row", row.Id, "panel", p.Id, "title", p.Title)
 					continue
 				}
 				panelCount++
 				wg.Add(1)
 				go func(panel grafana.Panel) {
 					defer wg.Done()
-					err := rep.downloadPanelImage(panel, dashUID)
-					if err != nil {
-						log.Printf("Warning: Failed to download image for panel %d ('%s'): %v", panel.Id, panel.Title, err)
-						errorChannel <- fmt.Errorf("panel %d ('%s'): %w", panel.Id, panel.Title, err)
+					defer progress.add()
+					if err := rep.fetchPanel(ctx, limiter, panel, dashUID); err != nil {
+						fail(panel, err)
 					}
 				}(p)
 			}
 		}
-		log.Printf("Scheduled downloads for %d panels across rows.", panelCount)
+		slog.Debug("Scheduled panel downloads", "panels", panelCount)
 	} else {
 		panelsToFetch := dash.GetGridPanels()
 		if len(panelsToFetch) == 0 {
-			log.Println("Warning: Grid layout selected, but no panels found to process.")
-			return nil
+			slog.Warn("The dashboard has no panels to render")
+			return nil, nil
 		}
-		log.Printf("Fetching images for %d panels (grid layout)...", len(panelsToFetch))
+		slog.Debug("Fetching panel images", "panels", len(panelsToFetch))
 		for _, p := range panelsToFetch {
-			if p.Type == "text" {
-				log.Printf("Skipping image download for text panel: %d (%s)", p.Id, p.Title)
+			if ctx.Err() != nil {
+				break
+			}
+			if !p.IsRenderable() {
+				slog.Debug("Skipping image download for text panel", "panel", p.Id, "title", p.Title)
 				continue
 			}
 			wg.Add(1)
 			go func(panel grafana.Panel) {
 				defer wg.Done()
-				err := rep.downloadPanelImage(panel, dashUID)
-				if err != nil {
-					log.Printf("Warning: Failed to download image for panel %d ('%s'): %v", panel.Id, panel.Title, err)
-					errorChannel <- fmt.Errorf("panel %d ('%s'): %w", panel.Id, panel.Title, err)
+				defer progress.add()
+				if err := rep.fetchPanel(ctx, limiter, panel, dashUID); err != nil {
+					fail(panel, err)
 				}
 			}(p)
 		}
 	}
 
 	wg.Wait()
-	close(errorChannel)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("image downloads cancelled: %w", err)
+	}
+	reportPanelCount.Observe(float64(progress.total))
 
 	var downloadErrors []string
-	for err := range errorChannel {
-		downloadErrors = append(downloadErrors, err.Error())
+	var errs []error
+	for _, panelErr := range failed {
+		downloadErrors = append(downloadErrors, panelErr.Error())
+		errs = append(errs, panelErr)
+	}
+	panelDownloadFailures.Add(float64(len(errs)))
+	if len(errs) > 0 && rep.opts.Strict {
+		return nil, fmt.Errorf("%d panel(s) failed to render: %w", len(errs), errors.Join(errs...))
 	}
 	if len(downloadErrors) > 0 {
-		log.Printf("Finished downloading images with %d error(s). Report generation will continue.\n- %s",
-			len(downloadErrors), strings.Join(downloadErrors, "\n- "))
+		slog.Warn("Finished downloading images with errors, report generation will continue",
+			"errors", len(downloadErrors), "details", strings.Join(downloadErrors, "; "))
 	} else {
-		log.Println("Finished downloading images successfully.")
+		slog.Info("Finished downloading images")
 	}
-	return nil
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Id < failed[j].Id })
+	return failed, nil
+}
+
+// maxRenders is the initial bound of concurrent panel renders. The limiter lowers it further
+// if the renderer reports its limit.
+func (rep *report) maxRenders() int {
+	if rep.opts.MaxRenders > 0 {
+		return rep.opts.MaxRenders
+	}
+	return math.MaxInt32
 }
 
 // downloadPanelImage function (keep as is)
-func (rep *report) downloadPanelImage(p grafana.Panel, dashUID string) error {
+func (rep *report) downloadPanelImage(ctx context.Context, p grafana.Panel, dashUID string) error {
 	imgPath := rep.imgFilePath(p.Id)
-	log.Printf("Downloading panel %d ('%s') image to %s...", p.Id, p.Title, imgPath)
+	t := p.TimeRange(rep.time)
+	var cacheKey string
+	if rep.cache != nil {
+		cacheKey = imageCacheKey(dashUID, p, t, rep.variables, rep.gClient.RenderKey(p, t), rep.opts.TrimImages)
+		if rep.cache.get(cacheKey, imgPath) {
+			slog.Debug("Using cached panel image", "panel", p.Id, "title", p.Title)
+			return nil
+		}
+	}
+	slog.Debug("Downloading panel image", "panel", p.Id, "title", p.Title, "path", imgPath)
 
-	body, err := rep.gClient.GetPanelPng(p, dashUID, rep.time)
+	body, err := rep.gClient.GetPanelPng(ctx, p, dashUID, t)
 	if err != nil {
 		return err
 	}
@@ -264,95 +664,430 @@ func (rep *report) downloadPanelImage(p grafana.Panel, dashUID string) error {
 	if err != nil {
 		return fmt.Errorf("error creating image file %v: %v", imgPath, err)
 	}
-	defer file.Close()
 
 	_, err = io.Copy(file, body)
+	file.Close()
 	if err != nil {
 		_ = os.Remove(imgPath)
 		return fmt.Errorf("error writing image file %v: %v", imgPath, err)
 	}
-	log.Printf("Done downloading panel %d.", p.Id)
+	if rep.opts.TrimImages {
+		if err := trimImage(imgPath); err != nil {
+			slog.Warn("Could not trim panel image, keeping it untrimmed", "panel", p.Id, "error", err)
+		}
+	}
+	if rep.cache != nil {
+		rep.cache.put(cacheKey, imgPath)
+	}
+	slog.Debug("Done downloading panel image", "panel", p.Id)
+	return nil
+}
+
+// dashboardImageFile is the image of the whole dashboard in the image directory
+const dashboardImageFile = "dashboard.png"
+
+// downloadDashboardImage renders the whole dashboard as one image, instead of each panel
+func (rep *report) downloadDashboardImage(ctx context.Context, dashUID string) error {
+	imgDirPath := rep.imgDirPath()
+	if err := os.MkdirAll(imgDirPath, 0777); err != nil {
+		return fmt.Errorf("error creating image directory at %v: %v", imgDirPath, err)
+	}
+	imgPath := filepath.Join(imgDirPath, dashboardImageFile)
+	slog.Debug("Downloading dashboard image", "dashboard", dashUID, "path", imgPath)
+
+	body, err := rep.gClient.GetDashboardPng(ctx, dashUID, rep.time)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(imgPath)
+	if err != nil {
+		return fmt.Errorf("error creating image file %v: %v", imgPath, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, body); err != nil {
+		return fmt.Errorf("error writing image file %v: %v", imgPath, err)
+	}
+	rep.progress(StageImages, 1, 1)
 	return nil
 }
 
-// formatVariables function (keep as is)
+// formatVariables lists the visible variables with their selected values, for the report subtitle
 func formatVariables(variables []grafana.TemplateVariable) string {
 	var parts []string
 	for _, v := range variables {
 		if v.Hide == 2 { continue }
-		currentValStr := ""
-		if v.Current.Text != nil {
-			switch text := v.Current.Text.(type) {
-			case string: currentValStr = text
-			case []interface{}:
-				var vals []string
-				for _, item := range text { vals = append(vals, fmt.Sprintf("%v", item)) }
-				if v.IncludeAll && v.Current.Value == "$__all" || (len(vals) > 1 && v.Current.Text == "All") {
-					currentValStr = "All"
-				} else { currentValStr = strings.Join(vals, ", ") }
-			default: currentValStr = fmt.Sprintf("%v", v.Current.Text)
-			}
-		} else if v.Current.Value != "" {
-			if strings.HasPrefix(v.Current.Value, "[") && strings.HasSuffix(v.Current.Value, "]") && v.Multi {
-				vals := strings.TrimSuffix(strings.TrimPrefix(v.Current.Value, "["), "]")
-				currentValStr = strings.ReplaceAll(vals, "\",\"", ", ")
-				currentValStr = strings.ReplaceAll(currentValStr, "\"", "")
-			} else { currentValStr = v.Current.Value }
-		}
+		currentValStr := variableValue(v)
 		if v.Hide == 1 { currentValStr = "" }
-		label := v.Name
-		if v.Label != "" { label = v.Label }
+		label := variableLabel(v)
 		if currentValStr != "" { parts = append(parts, fmt.Sprintf("%s: %s", label, currentValStr))
 		} else { parts = append(parts, label) }
 	}
 	return strings.Join(parts, "; ")
 }
 
-// createTex function - **MODIFIED templData and data population**
-func (rep *report) createTex(dash grafana.Dashboard) error {
-	// Define functions for the template (keep EscapeLaTeX and PanelImagePath)
-	funcMap := template.FuncMap{
+// variableValue is the selected value of the variable: "All" when its All option is
+// selected, else its selected values joined by commas
+func variableValue(v grafana.TemplateVariable) string {
+	if v.AllSelected() {
+		return "All"
+	}
+	values := v.Current.Texts()
+	if len(values) == 0 {
+		values = v.Current.Values()
+	}
+	return strings.Join(values, ", ")
+}
+
+// variableLabel is the label of the variable, or its name if it has none
+func variableLabel(v grafana.TemplateVariable) string {
+	if v.Label != "" {
+		return v.Label
+	}
+	return v.Name
+}
+
+// thresholdCaption describes the threshold steps of stat-like panels in LaTeX, e.g. "green $<$ 80, red $\geq$ 90"
+func thresholdCaption(p grafana.Panel) string {
+	if !p.HasThresholds() {
+		return ""
+	}
+	th := p.FieldConfig.Defaults.Thresholds
+	unit := ""
+	if th.Mode == "percentage" {
+		unit = "\\%"
+	}
+	var parts []string
+	for i, step := range th.Steps {
+		color := grafana.SanitizeLaTexInput(step.Color)
+		if step.Value != nil {
+			parts = append(parts, fmt.Sprintf("%s $\\geq$ %s%s", color, strconv.FormatFloat(*step.Value, 'f', -1, 64), unit))
+		} else if i+1 < len(th.Steps) && th.Steps[i+1].Value != nil {
+			parts = append(parts, fmt.Sprintf("%s $<$ %s%s", color, strconv.FormatFloat(*th.Steps[i+1].Value, 'f', -1, 64), unit))
+		}
+	}
+	return "Thresholds: " + strings.Join(parts, ", ")
+}
+
+// dashboardURL links to the dashboard and time range on the public Grafana URL, or is "" without one
+func dashboardURL(publicURL string, dash grafana.Dashboard, dashName string, t grafana.TimeRange) string {
+	if publicURL == "" {
+		return ""
+	}
+	path := "/dashboard/db/" + url.PathEscape(dashName)
+	if dash.Uid != "" {
+		path = "/d/" + url.PathEscape(dash.Uid)
+	}
+	vals := url.Values{}
+	vals.Add("from", t.From)
+	vals.Add("to", t.To)
+	return strings.TrimSuffix(publicURL, "/") + path + "?" + vals.Encode()
+}
+
+// footerText is the text in the center of the page footer
+func footerText(text string) string {
+	if text == "" {
+		return "Generated by Grafana Reporter"
+	}
+	return text
+}
+
+// pdfAuthor is the author in the PDF metadata
+func pdfAuthor(author string) string {
+	if author == "" {
+		return "Grafana Reporter"
+	}
+	return author
+}
+
+// escapeURL escapes the characters of a URL that \href does not accept as is
+func escapeURL(u string) string {
+	return strings.NewReplacer("%", `\%`, "#", `\#`).Replace(u)
+}
+
+// panelCaption is the LaTeX-escaped text under a panel image, or "" if there is none
+func panelCaption(p grafana.Panel, source CaptionSource) string {
+	title := grafana.SanitizeLaTexInput(p.Title)
+	description := grafana.SanitizeLaTexInput(strings.TrimSpace(p.Description))
+	switch source {
+	case CaptionNone:
+		return ""
+	case CaptionDescription:
+		if description == "" {
+			return ""
+		}
+		return fmt.Sprintf("{ \\small %s }", description)
+	case CaptionBoth:
+		if description == "" {
+			return fmt.Sprintf("{ \\small \\textbf{%s} }", title)
+		}
+		return fmt.Sprintf("{ \\small \\textbf{%s} } \\par { \\footnotesize %s }", title, description)
+	}
+	return fmt.Sprintf("{ \\small %s }", title)
+}
+
+// templateFuncs are the functions available to TeX templates
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
 		"EscapeLaTeX": grafana.SanitizeLaTexInput,
 		"PanelImagePath": func(panelID int) string {
 			return fmt.Sprintf("%s/image%d.png", imgDir, panelID)
 		},
-		// Remove other helpers if not needed or ensure they work without funcMap context
+		"ThresholdCaption": thresholdCaption,
+		"EscapeURL":        escapeURL,
+		"PanelCaption":     panelCaptionFunc(Options{}),
+		"PanelText":        textPanelLaTeX,
+		"PackPanel":        packPanel,
+		"PanelWidth":       panelWidth,
+		"VariableLabel":    variableLabel,
+		"VariableValue":    appendixValue,
+	}
+}
+
+// maxPackedWidth is the widest panel, in grid units of the 24 unit wide dashboard, that the grid
+// layout places side by side with other panels
+const maxPackedWidth = 16
+
+// packPanel is true for panels the grid layout places side by side with the panels next to them:
+// stat-like panels and panels up to maxPackedWidth wide
+func packPanel(p grafana.Panel) bool {
+	return p.IsSingleStat() || (p.GridPos.W > 0 && p.GridPos.W <= maxPackedWidth)
+}
+
+// panelWidth is the width of a packed panel as a fraction of the text width, e.g. 0.48 for a
+// panel half as wide as the dashboard. Stat panels without a grid position get 0.3.
+func panelWidth(p grafana.Panel) string {
+	if p.GridPos.W <= 0 {
+		return "0.30"
+	}
+	return fmt.Sprintf("%.2f", math.Min(p.Width(), 0.96))
+}
+
+// panelCaptionFunc is the PanelCaption template function, the only one depending on the options
+func panelCaptionFunc(opts Options) func(grafana.Panel) string {
+	return func(p grafana.Panel) string {
+		return panelCaption(p, opts.CaptionSource)
+	}
+}
+
+var hexColorRegExp = regexp.MustCompile(`^#?([0-9a-fA-F]{6})$`)
+
+// LaTeXColor converts a "#RRGGBB" color to a value of xcolor's HTML color model. Empty is black.
+func LaTeXColor(hex string) (string, error) {
+	if hex == "" {
+		return "000000", nil
+	}
+	matches := hexColorRegExp.FindStringSubmatch(hex)
+	if matches == nil {
+		return "", fmt.Errorf("invalid color %q: expected #RRGGBB", hex)
+	}
+	return strings.ToUpper(matches[1]), nil
+}
+
+// parsedTemplates caches the parsed TeX templates by name and content, so the built-in and
+// custom templates are parsed once rather than for every report
+var parsedTemplates = struct {
+	sync.Mutex
+	m map[string]*template.Template
+}{m: map[string]*template.Template{}}
+
+// parseTemplate parses TeX template content with the template functions and delimiters. The
+// parsed template is cached and each caller gets a clone, with PanelCaption bound to opts.
+func parseTemplate(name, content string, opts Options) (*template.Template, error) {
+	key := name + "\x00" + content
+	parsedTemplates.Lock()
+	tmpl, ok := parsedTemplates.m[key]
+	if !ok {
+		var err error
+		tmpl, err = template.New(name).Funcs(templateFuncs()).Delims("[[", "]]").Parse(content)
+		if err != nil {
+			parsedTemplates.Unlock()
+			return nil, err
+		}
+		parsedTemplates.m[key] = tmpl
+	}
+	parsedTemplates.Unlock()
+
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
 	}
+	return clone.Funcs(template.FuncMap{"PanelCaption": panelCaptionFunc(opts)}), nil
+}
+
+// templData is the data model TeX templates are executed against
+type templData struct {
+	// Keep essential top-level info
+	Title          string
+	Description    string
+	VariableValues string
+	ImgDir         string
+	FromFormatted  string // The time range as requested, e.g. now-6h, not escaped
+	ToFormatted    string
+	FromResolved   string // The absolute times of the time range in the report's timezone, empty if not recognised
+	ToResolved     string
+	TimeRangeText  string // The resolved time range, with the relative one it was resolved from, not escaped
+	UseRowLayout   bool
+	ShowRowIntro   bool
+	ShowTOC        bool   // Table of contents of the rows, for the row-based template
+	UnicodeEngine  bool   // Compiled by xelatex or lualatex, which load fonts with fontspec instead of inputenc
+	PaperSize      string // Paper name of the geometry package, e.g. a4paper
+	Margin         string // Page margin as a TeX length, e.g. 1in
+	AccentColor    string // xcolor HTML model value, e.g. 1F77B4
+	Watermark      string // Printed diagonally across every page, empty for none
+	HeaderImage    string // Absolute path of the page header image of the row-based template, empty for none
+	CoverPage      bool   // Open with a cover page
+	CoverLogo      string // Absolute path of the logo on the cover page, empty for none
+	GeneratedAt    string // When the report was generated, for the cover page
+	FooterText     string // Center of the page footer
+	Author         string // PDF metadata, like Subject and Keywords
+	Subject        string
+	Keywords       string // The dashboard's tags, comma separated
+	Legend         []LegendEntry
+	DashboardURL   string                     // Link to the dashboard and time range for readers, empty without a public URL
+	QRCode         string                     // Path of the QR code image of DashboardURL, empty without one
+	DashboardImage string                     // Path of the image of the whole dashboard, shown instead of the panels; empty without one
+	Timeline       []TimelineMark             // Annotations on the time range, empty without -annotation-timeline
+	FailedPanels   []PanelError               // Panels that could not be rendered, listed at the end of the report
+	StatValues     map[int]string             // Values of the stat panels shown as text, by panel id, not escaped
+	Variables      []grafana.TemplateVariable // Listed in the variables appendix, empty without it
+	// Add explicit fields for Rows and Panels
+	Rows   []grafana.GrafanaRow
+	Panels []grafana.Panel
+	// RowStarts are the rows of the grid layout by the id of their first panel, for bookmarks
+	RowStarts map[int]*grafana.GrafanaRow
+}
+
+// resolvedTimeLayout formats the absolute times of the time range
+const resolvedTimeLayout = "2 Jan 2006 15:04 MST"
 
-	// **MODIFIED templData struct:**
-	type templData struct {
-		// Keep essential top-level info
-		Title          string
-		Description    string
-		VariableValues string
-		ImgDir         string
-		FromFormatted  string
-		ToFormatted    string
-		UseRowLayout   bool
-		// Add explicit fields for Rows and Panels
-		Rows   []grafana.GrafanaRow
-		Panels []grafana.Panel
+// location is where the time range is resolved: the configured timezone, or the dashboard's
+func (rep *report) location(dash grafana.Dashboard) *time.Location {
+	tz := rep.opts.Timezone
+	if tz == grafana.DashboardTimezone {
+		tz = dash.RenderTimezone()
+	}
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		slog.Warn("Unknown timezone, showing the time range in UTC", "timezone", tz, "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// timeRangeText is the time range for readers: its absolute times, followed by the relative
+// range they were resolved from, e.g. "2 Jan 2006 09:04 UTC to 2 Jan 2006 15:04 UTC (now-6h to
+// now)". Time ranges that cannot be resolved are shown as requested.
+func timeRangeText(tr grafana.TimeRange, from, to string) string {
+	if from == "" {
+		return tr.From + " to " + tr.To
+	}
+	text := from + " to " + to
+	if tr.IsRelative() {
+		text += " (" + tr.From + " to " + tr.To + ")"
+	}
+	return text
+}
+
+// newTemplData collects the data of the report for its template
+func (rep *report) newTemplData(dash grafana.Dashboard, failedPanels []PanelError) (templData, error) {
+	accentColor, err := LaTeXColor(rep.opts.AccentColor)
+	if err != nil {
+		return templData{}, err
+	}
+
+	legend, err := latexLegend(rep.opts.Legend)
+	if err != nil {
+		return templData{}, err
+	}
+
+	margin, err := pageMargin(rep.opts.Margin, rep.useRowLayout)
+	if err != nil {
+		return templData{}, err
+	}
+
+	link := dashboardURL(rep.opts.PublicURL, dash, rep.dashName, rep.time)
+	var qrCode string
+	if rep.opts.QRCode && link != "" {
+		qrCode = qrCodeFile
+	}
+
+	var dashboardImage string
+	if rep.opts.DashboardImage {
+		dashboardImage = dashboardImageFile
+	}
+
+	var fromResolved, toResolved string
+	if from, to, ok := rep.time.BoundsIn(rep.location(dash)); ok {
+		fromResolved, toResolved = from.Format(resolvedTimeLayout), to.Format(resolvedTimeLayout)
 	}
 
 	// **Populate the explicit fields:**
-	data := templData{
+	return templData{
 		Title:          dash.Title,       // Use title from dashboard struct
 		Description:    dash.Description, // Use description from dashboard struct
 		VariableValues: formatVariables(dash.Templating.List),
 		ImgDir:         imgDir,
 		FromFormatted:  rep.time.From,
 		ToFormatted:    rep.time.To,
+		FromResolved:   fromResolved,
+		ToResolved:     toResolved,
+		TimeRangeText:  timeRangeText(rep.time, fromResolved, toResolved),
 		UseRowLayout:   rep.useRowLayout,
+		ShowRowIntro:   !rep.opts.HideRowIntro,
+		ShowTOC:        rep.opts.TableOfContents,
+		UnicodeEngine:  rep.opts.LaTeXEngine.Unicode(),
+		PaperSize:      rep.opts.PaperSize.Geometry(),
+		Margin:         margin,
+		AccentColor:    accentColor,
+		Watermark:      rep.opts.Watermark,
+		HeaderImage:    rep.opts.HeaderImage,
+		CoverPage:      rep.opts.CoverPage,
+		CoverLogo:      rep.opts.CoverLogo,
+		GeneratedAt:    time.Now().Format("2 January 2006 15:04 MST"),
+		FooterText:     footerText(rep.opts.FooterText),
+		Author:         pdfAuthor(rep.opts.Author),
+		Subject:        fmt.Sprintf("Grafana dashboard report from %s to %s", rep.time.From, rep.time.To),
+		Keywords:       strings.Join(dash.Tags, ", "),
+		Legend:         legend,
+		DashboardURL:   link,
+		QRCode:         qrCode,
+		DashboardImage: dashboardImage,
+		Timeline:       timelineMarks(rep.annotations, rep.time),
+		FailedPanels:   failedPanels,
+		StatValues:     rep.statValues,
+		Variables:      rep.appendixVariables(dash),
 		// Call the methods on the dash object to get the processed data
-		Rows:   dash.GetRows(),
-		Panels: dash.GetGridPanels(),
+		Rows:      dash.GetRows(),
+		Panels:    dash.GetGridPanels(),
+		RowStarts: dash.RowStarts(),
+	}, nil
+}
+
+// createTex function - **MODIFIED templData and data population**
+func (rep *report) createTex(dash grafana.Dashboard, failedPanels []PanelError) error {
+	data, err := rep.newTemplData(dash, failedPanels)
+	if err != nil {
+		return err
 	}
 
 	// Create directory if it doesn't exist
-	err := os.MkdirAll(rep.tmpDir, 0777)
+	err = os.MkdirAll(rep.tmpDir, 0777)
 	if err != nil {
 		return fmt.Errorf("error creating temporary directory at %v: %v", rep.tmpDir, err)
 	}
+	if err = rep.copyAssets(); err != nil {
+		return fmt.Errorf("error copying template assets: %v", err)
+	}
+	if data.QRCode != "" {
+		if err = rep.writeQRCode(data.DashboardURL); err != nil {
+			return fmt.Errorf("error writing the QR code of the dashboard link: %v", err)
+		}
+	}
 
 	// Create the .tex file
 	texPath := rep.texPath()
@@ -364,7 +1099,7 @@ func (rep *report) createTex(dash grafana.Dashboard) error {
 
 	// Parse the template content
 	tmplName := filepath.Base(texPath)
-	tmpl, err := template.New(tmplName).Funcs(funcMap).Delims("[[", "]]").Parse(rep.texTemplate)
+	tmpl, err := parseTemplate(tmplName, rep.texTemplate, rep.opts)
 	if err != nil {
 		templateSample := rep.texTemplate
 		maxSampleLength := 500
@@ -380,40 +1115,131 @@ func (rep *report) createTex(dash grafana.Dashboard) error {
 		return fmt.Errorf("error executing tex template (%s): %v (temp dir: %s)", tmplName, err, rep.tmpDir)
 	}
 
-	log.Println("Created LaTeX file:", texPath)
+	slog.Debug("Created LaTeX file", "path", texPath)
 	return nil
 }
 
+var (
+	latexErrorLineRegExp  = regexp.MustCompile(`(?m)^l\.(\d+) `)
+	panelImageRegExp      = regexp.MustCompile(regexp.QuoteMeta(imgDir) + `/image\d+\.png`)
+	includeGraphicsRegExp = regexp.MustCompile(`\\includegraphics(\[[^\]]*\])?\{[^}]*\}`)
+)
+
+// excludeFailedImage finds the panel image that caused the first error in the LaTeX output,
+// either by name or by the line the error occurred on, and replaces its \includegraphics in
+// the tex file with a placeholder note. It returns the name of the excluded image.
+func (rep *report) excludeFailedImage(latexOutput []byte) (string, error) {
+	out := string(latexOutput)
+	errStart := strings.Index(out, "\n! ")
+	if errStart < 0 {
+		return "", fmt.Errorf("no LaTeX error found in output")
+	}
+	errBlock := out[errStart:]
+
+	tex, err := ioutil.ReadFile(rep.texPath())
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(string(tex), "\n")
+
+	failedLine, failedImg := -1, ""
+	if img := panelImageRegExp.FindString(errBlock); img != "" {
+		for n, line := range lines {
+			if strings.Contains(line, img) && includeGraphicsRegExp.MatchString(line) {
+				failedLine, failedImg = n, img
+				break
+			}
+		}
+	}
+	if failedLine < 0 {
+		if m := latexErrorLineRegExp.FindStringSubmatch(errBlock); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if n > 0 && n <= len(lines) && includeGraphicsRegExp.MatchString(lines[n-1]) {
+				failedLine = n - 1
+				failedImg = panelImageRegExp.FindString(lines[n-1])
+			}
+		}
+	}
+	if failedLine < 0 {
+		return "", fmt.Errorf("the LaTeX error is not caused by a panel image")
+	}
+	if failedImg == "" {
+		failedImg = "line " + strconv.Itoa(failedLine+1)
+	}
+
+	placeholder := "\\fbox{Image " + grafana.SanitizeLaTexInput(failedImg) + " could not be included}"
+	original := lines[failedLine]
+	lines[failedLine] = includeGraphicsRegExp.ReplaceAllLiteralString(original, placeholder) + "\n% excluded after LaTeX error: " + original
+
+	err = ioutil.WriteFile(rep.texPath(), []byte(strings.Join(lines, "\n")), 0666)
+	if err != nil {
+		return "", err
+	}
+	return failedImg, nil
+}
 
 // runLaTeX function (Keep as is)
-func (rep *report) runLaTeX() (pdf *os.File, err error) {
+// checkImgDir checks that the images LaTeX includes were downloaded
+func (rep *report) checkImgDir() error {
 	imgDirPath := rep.imgDirPath()
 	if _, errStat := os.Stat(imgDirPath); os.IsNotExist(errStat) {
-		return nil, fmt.Errorf("image directory '%s' not found before running LaTeX. Check fetchImages logs.", imgDirPath)
-	} else {
-		files, _ := ioutil.ReadDir(imgDirPath)
-		if len(files) == 0 {
-			log.Printf("Warning: Image directory '%s' exists but is empty. LaTeX compilation might fail to find images.", imgDirPath)
-		}
+		return fmt.Errorf("image directory '%s' not found before running LaTeX. Check fetchImages logs.", imgDirPath)
+	}
+	files, _ := ioutil.ReadDir(imgDirPath)
+	if len(files) == 0 {
+		slog.Warn("Image directory is empty, LaTeX might fail to find images", "dir", imgDirPath)
 	}
+	return nil
+}
 
-	texPath := rep.texPath()
-	texFileBase := filepath.Base(texPath)
-	pdfPath := rep.pdfPath()
-	logPath := rep.logPath()
+// maxLaTeXPasses is how many passes LaTeX may run when it keeps asking for a rerun
+const maxLaTeXPasses = 5
 
-	for i := 1; i <= 2; i++ {
-		args := []string{"-interaction=nonstopmode", "-halt-on-error", texFileBase}
-		cmd := exec.Command("pdflatex", args...)
-		cmd.Dir = rep.tmpDir
-		log.Printf("Running LaTeX command (pass %d)... Command: %s, Dir: %s", i, cmd.String(), cmd.Dir)
+// latexRerunRegExp matches the warnings of LaTeX and its packages asking for another pass, as
+// for changed labels, tables of contents and PDF outlines
+var latexRerunRegExp = regexp.MustCompile(`Rerun to get|Please rerun LaTeX`)
 
-		outBytes, errCmd := cmd.CombinedOutput()
+// latexPasses is the configured number of LaTeX passes
+func (rep *report) latexPasses() int {
+	if rep.opts.LaTeXPasses > 0 {
+		return rep.opts.LaTeXPasses
+	}
+	return 2
+}
+
+func (rep *report) runLaTeX(ctx context.Context) (pdf *os.File, err error) {
+	start := time.Now()
+	defer func() { latexDuration.Observe(time.Since(start).Seconds()) }()
+	texFileBase := filepath.Base(rep.texPath())
+	logPath := rep.logPath()
+	runner := rep.latexRunner()
+	var pdfPath string
+
+	recoveries := 0
+	passes := rep.latexPasses()
+	for i := 1; i <= passes; i++ {
+		var outBytes []byte
+		var errCmd error
+		pdfPath, outBytes, errCmd = runner.Run(ctx, rep.tmpDir, texFileBase)
 		logErr := ioutil.WriteFile(logPath, outBytes, 0666)
 		if logErr != nil {
-			log.Printf("Warning: Failed to write pdflatex output log to %s: %v", logPath, logErr)
+			slog.Warn("Failed to write LaTeX output log", "path", logPath, "error", logErr)
+		}
+		if errors.Is(errCmd, exec.ErrNotFound) {
+			return nil, errCmd
 		}
 
+		if errCmd != nil && recoveries < rep.opts.MaxLaTeXRecoveries {
+			panelImg, recErr := rep.excludeFailedImage(outBytes)
+			if recErr != nil {
+				slog.Warn("Could not recover from LaTeX error", "error", recErr)
+			} else {
+				recoveries++
+				slog.Warn("LaTeX failed on a panel image, replaced it with a placeholder and recompiling", "pass", i, "image", panelImg, "recovery", recoveries, "maxRecoveries", rep.opts.MaxLaTeXRecoveries)
+				i = 0
+				continue
+			}
+		}
 		if errCmd != nil {
 			outputHint := string(outBytes)
 			maxLogTail := 2000
@@ -422,7 +1248,12 @@ func (rep *report) runLaTeX() (pdf *os.File, err error) {
 			}
 			return nil, fmt.Errorf("error running LaTeX (pass %d): %v. Output logged to %s\n-- LaTeX Output Tail --\n%s\n-- LaTeX Output End --", i, errCmd, logPath, outputHint)
 		}
-		log.Printf("LaTeX pass %d completed successfully.", i)
+		slog.Debug("LaTeX pass completed", "pass", i)
+		if i == passes && i < maxLaTeXPasses && latexRerunRegExp.Match(outBytes) {
+			slog.Debug("LaTeX asks for a rerun", "pass", i)
+			passes++
+		}
+		rep.progress(StageLaTeX, i, passes)
 	}
 
 	if _, errStat := os.Stat(pdfPath); os.IsNotExist(errStat) {
@@ -435,7 +1266,7 @@ func (rep *report) runLaTeX() (pdf *os.File, err error) {
 		return nil, fmt.Errorf("error: LaTeX completed but PDF file '%s' not found. Check LaTeX logs in %s\nLog Content Tail:\n%s", pdfPath, rep.tmpDir, logContentStr)
 	}
 
-	log.Println("Created PDF file:", pdfPath)
+	slog.Info("Created PDF file", "path", pdfPath)
 	pdfFile, err := os.Open(pdfPath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening PDF file '%s': %v", pdfPath, err)
@@ -17,15 +17,33 @@
 package report
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/IzakMarais/reporter/grafana"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -33,6 +51,7 @@ const dashJSON = `
 {"Dashboard":
 	{
 		"Title":"My first dashboard",
+		"Templating":{"List":[{"Name":"test","Current":{"Text":"testvarvalue","Value":"testvarvalue"}}]},
 		"Rows":
 		[{"Panels":
 			[{"Type":"singlestat", "Id":1},
@@ -54,17 +73,52 @@ const dashJSON = `
 	{"Slug":"testDash"}
 }`
 
+func parseDashboard(dashJSON string) grafana.Dashboard {
+	var fullDash grafana.FullDashboard
+	err := json.Unmarshal([]byte(dashJSON), &fullDash)
+	So(err, ShouldBeNil)
+	return fullDash.Dashboard
+}
+
 type mockGrafanaClient struct {
-	getPanelCallCount int
+	getPanelCallCount int64 // Counted atomically, as panels are fetched concurrently
 	variables         url.Values
 }
 
-func (m *mockGrafanaClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
-	return grafana.NewDashboard([]byte(dashJSON), m.variables), nil
+func (m *mockGrafanaClient) GetDashboardPng(ctx context.Context, dashUID string, t grafana.TimeRange) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png of the dashboard"))), nil
+}
+
+func (m *mockGrafanaClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	return parseDashboard(dashJSON), nil
+}
+
+func (m *mockGrafanaClient) UsesGridLayout() bool { return false }
+
+func (m *mockGrafanaClient) RenderKey(p grafana.Panel, t grafana.TimeRange) string { return "" }
+
+func (m *mockGrafanaClient) GetAnnotations(ctx context.Context, dashUID string, t grafana.TimeRange) ([]grafana.Annotation, error) {
+	return []grafana.Annotation{{Time: 1453208247000, TimeEnd: 1453208247000, Text: "Deploy v1.2"}}, nil
+}
+
+func (m *mockGrafanaClient) GetPanelCSV(ctx context.Context, p grafana.Panel, dashUID string, t grafana.TimeRange) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBufferString("time,value\n")), nil
+}
+
+func (m *mockGrafanaClient) GetPanelValue(ctx context.Context, p grafana.Panel, dashUID string, t grafana.TimeRange) (float64, error) {
+	return 99.5, nil
+}
+
+func (m *mockGrafanaClient) FindDashboardUID(ctx context.Context, folder, title string) (string, error) {
+	return "testDash", nil
+}
+
+func (m *mockGrafanaClient) GetLibraryPanel(ctx context.Context, uid string) (grafana.Panel, error) {
+	return grafana.Panel{}, nil
 }
 
-func (m *mockGrafanaClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
-	m.getPanelCallCount++
+func (m *mockGrafanaClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	atomic.AddInt64(&m.getPanelCallCount, 1)
 	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
 }
 
@@ -73,12 +127,12 @@ func TestReport(t *testing.T) {
 		variables := url.Values{}
 		variables.Add("var-test", "testvarvalue")
 		gClient := &mockGrafanaClient{0, variables}
-		rep := new(gClient, "testDash", grafana.TimeRange{"1453206447000", "1453213647000"}, "", false)
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", false, Options{}).(*report)
 		defer rep.Clean()
 
 		Convey("When rendering images", func() {
-			dashboard, _ := gClient.GetDashboard("")
-			rep.renderPNGsParallel(dashboard)
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.fetchImages(context.Background(), dashboard, "testDash")
 
 			Convey("It should create a temporary folder", func() {
 				_, err := os.Stat(rep.tmpDir)
@@ -104,8 +158,8 @@ func TestReport(t *testing.T) {
 		})
 
 		Convey("When genereting the Tex file", func() {
-			dashboard, _ := gClient.GetDashboard("")
-			rep.generateTeXFile(dashboard)
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.createTex(dashboard, nil)
 			f, err := os.Open(rep.texPath())
 			defer f.Close()
 
@@ -139,10 +193,10 @@ func TestReport(t *testing.T) {
 					So(s, ShouldContainSubstring, "image99")
 				})
 				Convey("and the time range", func() {
-					//server time zone by shift hours timestamp
-					//so just test for day and year
-					So(s, ShouldContainSubstring, "Tue Jan 19")
-					So(s, ShouldContainSubstring, "2016")
+					So(s, ShouldContainSubstring, "from 1453206447000 to 1453213647000")
+				})
+				Convey("and the time range resolved to dates in the report's timezone", func() {
+					So(s, ShouldContainSubstring, "19 Jan 2016 12:27 UTC to 19 Jan 2016 14:27 UTC")
 				})
 			})
 		})
@@ -155,21 +209,102 @@ func TestReport(t *testing.T) {
 		})
 	})
 
+	Convey("When generating a report with a base temporary folder", t, func() {
+		base, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(base)
+		rep := New(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{}, "", false, Options{TempDir: base}).(*report)
+
+		Convey("Its temporary folder should be created in it", func() {
+			So(filepath.Dir(rep.tmpDir), ShouldEqual, filepath.Join(base, "reporter"))
+		})
+	})
+
+	Convey("When generating a report that keeps its temporary folder", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{KeepTemp: true}).(*report)
+		defer os.RemoveAll(rep.tmpDir)
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		So(rep.createTex(dashboard, nil), ShouldBeNil)
+
+		Convey("Clean() should leave the temporary folder", func() {
+			rep.Clean()
+
+			_, err := os.Stat(rep.texPath())
+			So(err, ShouldBeNil)
+		})
+	})
+
+	for _, format := range []OutputFormat{FormatPDF, FormatHTML, FormatZIP} {
+		Convey("When closing a generated "+string(format)+" report", t, func() {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			opts := Options{Format: format, LaTeXRunner: &fakeLaTeX{}}
+
+			Convey("Its temporary folder should be removed", func() {
+				rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, opts).(*report)
+				file, err := rep.Generate(context.Background())
+				So(err, ShouldBeNil)
+				_, err = os.Stat(rep.tmpDir)
+				So(err, ShouldBeNil)
+				So(file.Close(), ShouldBeNil)
+				_, err = os.Stat(rep.tmpDir)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+
+			Convey("Its temporary folder should be kept with KeepTemp", func() {
+				opts.KeepTemp = true
+				rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, opts).(*report)
+				defer os.RemoveAll(rep.tmpDir)
+				file, err := rep.Generate(context.Background())
+				So(err, ShouldBeNil)
+				So(file.Close(), ShouldBeNil)
+				_, err = os.Stat(rep.tmpDir)
+				So(err, ShouldBeNil)
+			})
+		})
+	}
 }
 
 type errClient struct {
-	getPanelCallCount int
+	getPanelCallCount int64 // Counted atomically, as panels are fetched concurrently
 	variables         url.Values
 }
 
-func (e *errClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
-	return grafana.NewDashboard([]byte(dashJSON), e.variables), nil
+func (e *errClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	return parseDashboard(dashJSON), nil
+}
+
+func (e *errClient) UsesGridLayout() bool { return false }
+
+func (e *errClient) RenderKey(p grafana.Panel, t grafana.TimeRange) string { return "" }
+
+func (e *errClient) GetAnnotations(ctx context.Context, dashUID string, t grafana.TimeRange) ([]grafana.Annotation, error) {
+	return []grafana.Annotation{{Time: 1453208247000, TimeEnd: 1453208247000, Text: "Deploy v1.2"}}, nil
+}
+
+func (e *errClient) GetPanelCSV(ctx context.Context, p grafana.Panel, dashUID string, t grafana.TimeRange) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBufferString("time,value\n")), nil
+}
+
+func (e *errClient) GetPanelValue(ctx context.Context, p grafana.Panel, dashUID string, t grafana.TimeRange) (float64, error) {
+	return 0, errors.New("no numeric data")
+}
+
+func (e *errClient) FindDashboardUID(ctx context.Context, folder, title string) (string, error) {
+	return "testDash", nil
+}
+
+func (e *errClient) GetLibraryPanel(ctx context.Context, uid string) (grafana.Panel, error) {
+	return grafana.Panel{}, nil
+}
+
+func (e *errClient) GetDashboardPng(ctx context.Context, dashUID string, t grafana.TimeRange) (io.ReadCloser, error) {
+	return nil, errors.New("The dashboard has some problem")
 }
 
 //Produce an error on the 2nd panel fetched
-func (e *errClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
-	e.getPanelCallCount++
-	if e.getPanelCallCount == 2 {
+func (e *errClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	if atomic.AddInt64(&e.getPanelCallCount, 1) == 2 {
 		return nil, errors.New("The second panel has some problem")
 	}
 	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
@@ -179,17 +314,22 @@ func TestReportErrorHandling(t *testing.T) {
 	Convey("When generating a report where one panels gives an error", t, func() {
 		variables := url.Values{}
 		gClient := &errClient{0, variables}
-		rep := new(gClient, "testDash", grafana.TimeRange{"1453206447000", "1453213647000"}, "", false)
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", false, Options{NoPlaceholderImages: true}).(*report)
 		defer rep.Clean()
 
-		Convey("When rendering images", func() {
-			dashboard, _ := gClient.GetDashboard("")
-			err := rep.renderPNGsParallel(dashboard)
+		Convey("When rendering images without placeholders", func() {
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			failed, err := rep.fetchImages(context.Background(), dashboard, "testDash")
 
 			Convey("It shoud call getPanelPng once per panel", func() {
 				So(gClient.getPanelCallCount, ShouldEqual, 9)
 			})
 
+			Convey("It should return the failed panel", func() {
+				So(failed, ShouldHaveLength, 1)
+				So(failed[0].Err.Error(), ShouldEqual, "The second panel has some problem")
+			})
+
 			Convey("It should create one less image file than the total number of panels", func() {
 				f, err := os.Open(rep.imgDirPath())
 				defer f.Close()
@@ -198,9 +338,8 @@ func TestReportErrorHandling(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 
-			Convey("If any panels return errors, renderPNGsParralel should return the error message from one panel", func() {
-				So(err, ShouldNotBeNil)
-				So(err.Error(), ShouldContainSubstring, "The second panel has some problem")
+			Convey("If any panels return errors, fetchImages should only log them and continue", func() {
+				So(err, ShouldBeNil)
 			})
 		})
 
@@ -213,3 +352,1813 @@ func TestReportErrorHandling(t *testing.T) {
 	})
 
 }
+
+// failingClient fails to render any panel
+type failingClient struct {
+	mockGrafanaClient
+}
+
+func (f *failingClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("panel %d has some problem", p.Id)
+}
+
+func TestManyFailedPanels(t *testing.T) {
+	Convey("When more panels fail than a report usually has", t, func() {
+		panels := make([]string, 150)
+		for i := range panels {
+			panels[i] = fmt.Sprintf(`{"Type":"graph", "Id":%d, "gridPos":{"x":0, "y":%d, "w":24, "h":1}}`, i+1, i)
+		}
+		dashboard := parseDashboard(`{"Dashboard":{"Title":"Many panels", "Panels":[` + strings.Join(panels, ",") + `]}}`)
+		rep := New(&failingClient{}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{NoPlaceholderImages: true}).(*report)
+		defer rep.Clean()
+
+		done := make(chan struct{})
+		var failed []PanelError
+		var err error
+		go func() {
+			defer close(done)
+			failed, err = rep.fetchImages(context.Background(), dashboard, "testDash")
+		}()
+
+		Convey("fetchImages should return all of them without blocking", func() {
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("fetchImages blocked on the failed panels")
+			}
+			So(err, ShouldBeNil)
+			So(failed, ShouldHaveLength, 150)
+			So(failed[0].Id, ShouldEqual, 1)
+			So(failed[149].Id, ShouldEqual, 150)
+		})
+	})
+}
+
+func TestStrictReport(t *testing.T) {
+	Convey("When generating a strict report where one panel gives an error", t, func() {
+		gClient := &errClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", false, Options{Strict: true}).(*report)
+		defer rep.Clean()
+
+		Convey("fetchImages should return the error of the panel", func() {
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "The second panel has some problem")
+		})
+
+		Convey("Generate should fail with a render error", func() {
+			pdf, err := rep.Generate(context.Background())
+			So(pdf, ShouldBeNil)
+			So(errors.Is(err, ErrRender), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPlaceholderImage(t *testing.T) {
+	Convey("When a panel fails to render", t, func() {
+		gClient := &errClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", false, Options{}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+		So(err, ShouldBeNil)
+
+		Convey("A placeholder PNG should be written in place of its image", func() {
+			files, err := ioutil.ReadDir(rep.imgDirPath())
+			So(err, ShouldBeNil)
+			So(files, ShouldHaveLength, 9)
+			placeholders := 0
+			for _, file := range files {
+				data, _ := ioutil.ReadFile(filepath.Join(rep.imgDirPath(), file.Name()))
+				if img, err := png.Decode(bytes.NewReader(data)); err == nil {
+					So(img.Bounds().Dx(), ShouldEqual, placeholderWidth)
+					placeholders++
+				}
+			}
+			So(placeholders, ShouldEqual, 1) // The mock's other images are not PNGs
+		})
+	})
+
+	Convey("When wrapping placeholder text", t, func() {
+		So(wrapText("error rendering panel 22", 10), ShouldResemble, []string{"error", "rendering", "panel 22"})
+		So(wrapText("abcdefghijkl", 5), ShouldResemble, []string{"abcde", "fghij", "kl"})
+	})
+}
+
+func TestTrimImage(t *testing.T) {
+	Convey("When trimming a panel image", t, func() {
+		dir, err := ioutil.TempDir("", "trim")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "image.png")
+		writePNG := func(img image.Image) {
+			f, err := os.Create(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			So(png.Encode(f, img), ShouldBeNil)
+		}
+		readPNG := func() image.Image {
+			f, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			img, err := png.Decode(f)
+			So(err, ShouldBeNil)
+			return img
+		}
+		red := color.RGBA{0xff, 0, 0, 0xff}
+		img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+		Convey("The border around the content should be cropped", func() {
+			draw.Draw(img, image.Rect(10, 20, 60, 50), &image.Uniform{red}, image.Point{}, draw.Src)
+			writePNG(img)
+			So(trimImage(path), ShouldBeNil)
+			trimmed := readPNG()
+			So(trimmed.Bounds().Dx(), ShouldEqual, 50)
+			So(trimmed.Bounds().Dy(), ShouldEqual, 30)
+			So(color.RGBAModel.Convert(trimmed.At(trimmed.Bounds().Min.X, trimmed.Bounds().Min.Y)), ShouldResemble, red)
+		})
+
+		Convey("An image of a single color should be left as it is", func() {
+			writePNG(img)
+			So(trimImage(path), ShouldBeNil)
+			So(readPNG().Bounds(), ShouldResemble, img.Bounds())
+		})
+
+		Convey("Images that are not PNGs should fail to trim", func() {
+			So(ioutil.WriteFile(path, []byte("Not actually a png"), 0644), ShouldBeNil)
+			So(trimImage(path), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When generating a report trimming its images", t, func() {
+		rep := New(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{TrimImages: true, LaTeXRunner: &fakeLaTeX{}}).(*report)
+		defer rep.Clean()
+
+		Convey("Images that cannot be trimmed should be kept as downloaded", func() {
+			pdf, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer pdf.Close()
+			data, err := ioutil.ReadFile(rep.imgFilePath(44))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "Not actually a png")
+		})
+	})
+}
+
+func TestThresholdCaption(t *testing.T) {
+	Convey("When describing panel thresholds", t, func() {
+		const thresholdsDashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"stat", "Id":1, "fieldConfig":{"defaults":{"thresholds":{"mode":"absolute","steps":[
+				{"color":"green","value":null},{"color":"orange","value":80},{"color":"red","value":90}]}}}},
+			{"Type":"gauge", "Id":2, "fieldConfig":{"defaults":{"thresholds":{"mode":"percentage","steps":[
+				{"color":"#73BF69","value":null},{"color":"red","value":75.5}]}}}},
+			{"Type":"graph", "Id":3}]
+	}
+}`
+		dash := parseDashboard(thresholdsDashJSON)
+		panels := dash.GetGridPanels()
+
+		Convey("Each step should be listed with its lower bound", func() {
+			So(thresholdCaption(panels[0]), ShouldEqual, "Thresholds: green $<$ 80, orange $\\geq$ 80, red $\\geq$ 90")
+		})
+
+		Convey("Percentage thresholds and hex colors should be escaped", func() {
+			So(thresholdCaption(panels[1]), ShouldEqual, "Thresholds: \\#73BF69 $<$ 75.5\\%, red $\\geq$ 75.5\\%")
+		})
+
+		Convey("Panels without thresholds should have no caption", func() {
+			So(thresholdCaption(panels[2]), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestRowIntro(t *testing.T) {
+	Convey("When generating a row-based report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		intro := "The following pages contain sections from the Grafana dashboard"
+
+		Convey("The explanatory paragraph should be included by default", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, intro)
+		})
+
+		Convey("The explanatory paragraph should be omitted when HideRowIntro is set", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{HideRowIntro: true}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, intro)
+		})
+	})
+}
+
+func TestFailedPanelsPage(t *testing.T) {
+	Convey("When generating a report where panels failed to render", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		failed := []PanelError{{Id: 22, Title: "CPU_load", Err: errors.New("render timed out after 30s")}}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("With failed panels (row layout: %v) they should be listed at the end", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, failed), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, "Failed panels")
+				So(string(tex), ShouldContainSubstring, `\item Panel 22 (CPU\_load): render timed out after 30s`)
+			})
+
+			Convey(fmt.Sprintf("Without failed panels (row layout: %v) there should be no such page", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldNotContainSubstring, "Failed panels")
+			})
+		}
+	})
+}
+
+func TestHTMLReport(t *testing.T) {
+	Convey("When generating an HTML report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{Format: FormatHTML}).(*report)
+		defer rep.Clean()
+		file, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer file.Close()
+		html, err := ioutil.ReadAll(file)
+		So(err, ShouldBeNil)
+
+		Convey("It should not need LaTeX", func() {
+			_, err := os.Stat(rep.texPath())
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("It should include the title and the panel images inline", func() {
+			So(string(html), ShouldContainSubstring, "<title>My first dashboard</title>")
+			img := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("Not actually a png"))
+			So(strings.Count(string(html), img), ShouldEqual, 9)
+		})
+	})
+
+	Convey("When choosing an output format", t, func() {
+		So(OutputFormat("").Extension(), ShouldEqual, "pdf")
+		So(FormatHTML.Extension(), ShouldEqual, "html")
+		So(OutputFormat("docx").Valid(), ShouldBeFalse)
+	})
+}
+
+func TestImageCache(t *testing.T) {
+	Convey("When generating the same report twice with an image cache", t, func() {
+		base, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(base)
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		opts := Options{Format: FormatZIP, TempDir: base, ImageCacheTTL: time.Minute}
+		for i := 0; i < 2; i++ {
+			rep := New(gClient, "testDash", tr, "", false, opts)
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			file.Close()
+			rep.Clean()
+		}
+
+		Convey("The second report should use the cached images", func() {
+			So(gClient.getPanelCallCount, ShouldEqual, 9)
+		})
+
+		Convey("A report over another time range should render them again", func() {
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "now-2h", To: "now"}, "", false, opts)
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			file.Close()
+			rep.Clean()
+			So(gClient.getPanelCallCount, ShouldEqual, 18)
+		})
+	})
+
+	Convey("When caching images", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		src := filepath.Join(dir, "image.png")
+		So(ioutil.WriteFile(src, []byte("image"), 0666), ShouldBeNil)
+		cache := &imageCache{dir: filepath.Join(dir, "cache"), ttl: time.Minute}
+		cache.put("key", src)
+
+		Convey("Fresh images should be copied from the cache", func() {
+			dst := filepath.Join(dir, "copy.png")
+			So(cache.get("key", dst), ShouldBeTrue)
+			data, _ := ioutil.ReadFile(dst)
+			So(string(data), ShouldEqual, "image")
+		})
+
+		Convey("Stale and unknown images should not", func() {
+			old := time.Now().Add(-2 * time.Minute)
+			So(os.Chtimes(cache.path("key"), old, old), ShouldBeNil)
+			So(cache.get("key", filepath.Join(dir, "copy.png")), ShouldBeFalse)
+			So(cache.get("other", filepath.Join(dir, "copy.png")), ShouldBeFalse)
+		})
+
+		Convey("Variable values, the render key and trimming should be part of the key", func() {
+			p := grafana.Panel{Id: 1}
+			tr := grafana.TimeRange{From: "now-1h", To: "now"}
+			So(imageCacheKey("uid", p, tr, url.Values{"var-a": {"1"}}, "", false), ShouldNotEqual, imageCacheKey("uid", p, tr, url.Values{"var-a": {"2"}}, "", false))
+			So(imageCacheKey("uid", p, tr, nil, "a", false), ShouldNotEqual, imageCacheKey("uid", p, tr, nil, "b", false))
+			So(imageCacheKey("uid", p, tr, nil, "", false), ShouldNotEqual, imageCacheKey("uid", p, tr, nil, "", true))
+			So(imageCacheKey("uid", p, tr, nil, "a", false), ShouldEqual, imageCacheKey("uid", p, tr, nil, "a", false))
+		})
+	})
+}
+
+func TestProgress(t *testing.T) {
+	Convey("When generating a report with a progress function", t, func() {
+		var stages []string
+		var images []int
+		progress := func(stage string, done, total int) {
+			stages = append(stages, fmt.Sprintf("%s %d/%d", stage, done, total))
+			if stage == StageImages {
+				images = append(images, done)
+			}
+		}
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{Format: FormatHTML, Progress: progress}).(*report)
+		defer rep.Clean()
+		file, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		file.Close()
+
+		Convey("It should be called as each stage completes", func() {
+			So(stages, ShouldHaveLength, 11)
+			So(stages[0], ShouldEqual, "dashboard 1/1")
+			So(stages[9], ShouldEqual, "images 9/9")
+			So(stages[10], ShouldEqual, "document 1/1")
+		})
+
+		Convey("Image downloads should be counted up", func() {
+			So(images, ShouldResemble, []int{1, 2, 3, 4, 5, 6, 7, 8, 9})
+		})
+	})
+}
+
+func TestZIPReport(t *testing.T) {
+	Convey("When generating a ZIP report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{Format: FormatZIP}).(*report)
+		defer rep.Clean()
+		file, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		So(err, ShouldBeNil)
+
+		Convey("It should contain one image per panel, in report order", func() {
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			So(err, ShouldBeNil)
+			So(archive.File, ShouldHaveLength, 9)
+			So(archive.File[0].Name, ShouldEqual, "panel 1.png")
+			So(archive.File[1].Name, ShouldEqual, "panel 22.png")
+		})
+
+		Convey("It should not need LaTeX", func() {
+			_, err := os.Stat(rep.texPath())
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("When naming panel images", t, func() {
+		names := map[string]bool{}
+		So(imageFileName(grafana.Panel{Id: 1, Title: "CPU / Memory: 95%"}, names), ShouldEqual, "CPU _ Memory_ 95.png")
+		So(imageFileName(grafana.Panel{Id: 2, Title: "Latency"}, names), ShouldEqual, "Latency.png")
+		So(imageFileName(grafana.Panel{Id: 3, Title: "Latency"}, names), ShouldEqual, "Latency 3.png")
+		So(imageFileName(grafana.Panel{Id: 4, Title: "../"}, names), ShouldEqual, "panel 4.png")
+	})
+}
+
+func TestTableOfContents(t *testing.T) {
+	Convey("When generating a row-based report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		Convey("With a table of contents, each row should be registered in it", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{TableOfContents: true}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\tableofcontents`)
+			So(strings.Count(string(tex), `\addcontentsline{toc}{section}`), ShouldEqual, 2) // One per row of the dashboard
+		})
+
+		Convey("Without it, there should be no table of contents", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, `\tableofcontents`)
+			So(string(tex), ShouldNotContainSubstring, `\addcontentsline`)
+		})
+	})
+}
+
+func TestLaTeXEngine(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("For xelatex (row layout: %v) fonts should be loaded with fontspec", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{LaTeXEngine: EngineXeLaTeX}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\usepackage{fontspec}`)
+				So(string(tex), ShouldNotContainSubstring, `{inputenc}`)
+			})
+
+			Convey(fmt.Sprintf("For pdflatex (row layout: %v) input should be read with inputenc", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\usepackage[utf8]{inputenc}`)
+				So(string(tex), ShouldNotContainSubstring, `fontspec`)
+			})
+		}
+	})
+
+	Convey("When choosing a LaTeX engine", t, func() {
+		So(LaTeXEngine("").Command(), ShouldEqual, "pdflatex")
+		So(EngineLuaLaTeX.Unicode(), ShouldBeTrue)
+		So(LaTeXEngine("context").Valid(), ShouldBeFalse)
+	})
+}
+
+func TestMissingLaTeXEngine(t *testing.T) {
+	Convey("When the LaTeX engine is not installed", t, func() {
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		t.Setenv("PATH", dir)
+		rep := New(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{}).(*report)
+		defer rep.Clean()
+
+		Convey("Generate should say which engine is missing", func() {
+			_, err := rep.Generate(context.Background())
+			So(errors.Is(err, ErrLaTeX), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "pdflatex not found; install TeX Live")
+		})
+	})
+}
+
+// fakeLaTeX is a LaTeXRunner that writes a fake PDF, or fails with the output of its failures
+// as long as there are any left
+type fakeLaTeX struct {
+	runs     int
+	failures []string
+	reruns   int // Successful passes that ask for a rerun
+}
+
+func (f *fakeLaTeX) Run(ctx context.Context, dir, texFile string) (string, []byte, error) {
+	f.runs++
+	if len(f.failures) > 0 {
+		out := f.failures[0]
+		f.failures = f.failures[1:]
+		return "", []byte(out), errors.New("exit status 1")
+	}
+	out := "Output written on report.pdf"
+	if f.reruns > 0 {
+		f.reruns--
+		out = "LaTeX Warning: Label(s) may have changed. Rerun to get cross-references right.\n" + out
+	}
+	pdfPath := filepath.Join(dir, strings.TrimSuffix(texFile, ".tex")+".pdf")
+	return pdfPath, []byte(out), ioutil.WriteFile(pdfPath, []byte("%PDF-fake"), 0666)
+}
+
+func TestLaTeXRunner(t *testing.T) {
+	Convey("When generating a PDF report with an injected LaTeX runner", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		Convey("It should return the runner's PDF after two passes", func() {
+			runner := &fakeLaTeX{}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			pdf, _ := ioutil.ReadAll(file)
+			So(string(pdf), ShouldEqual, "%PDF-fake")
+			So(runner.runs, ShouldEqual, 2)
+			tex, _ := ioutil.ReadFile(rep.texPath())
+			So(string(tex), ShouldContainSubstring, `\includegraphics[width=0.9\textwidth]{images/image22.png}`)
+		})
+
+		Convey("It should run the configured number of passes", func() {
+			runner := &fakeLaTeX{}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner, LaTeXPasses: 1}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(runner.runs, ShouldEqual, 1)
+		})
+
+		Convey("It should rerun while LaTeX asks for it, up to the maximum", func() {
+			runner := &fakeLaTeX{reruns: 2}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner, LaTeXPasses: 1}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(runner.runs, ShouldEqual, 3)
+
+			runner = &fakeLaTeX{reruns: 10}
+			rep = New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner}).(*report)
+			defer rep.Clean()
+			file, err = rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(runner.runs, ShouldEqual, maxLaTeXPasses)
+		})
+
+		Convey("It should recover from a failed image and compile again", func() {
+			runner := &fakeLaTeX{failures: []string{"This is pdfTeX\n! LaTeX Error: File `images/image22.png' not found.\n"}}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner, MaxLaTeXRecoveries: 1}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(runner.runs, ShouldEqual, 3)
+			tex, _ := ioutil.ReadFile(rep.texPath())
+			So(string(tex), ShouldContainSubstring, `\fbox{Image images/image22.png could not be included}`)
+		})
+
+		Convey("It should fail with the runner's output", func() {
+			runner := &fakeLaTeX{failures: []string{"! Undefined control sequence."}}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner}).(*report)
+			defer rep.Clean()
+			_, err := rep.Generate(context.Background())
+			So(errors.Is(err, ErrLaTeX), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "! Undefined control sequence.")
+			_, err = os.Stat(rep.tmpDir)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("It should keep the temporary directory of a failed run if asked to", func() {
+			runner := &fakeLaTeX{failures: []string{"! Undefined control sequence."}}
+			rep := New(gClient, "testDash", tr, "", false, Options{LaTeXRunner: runner, KeepTemp: true}).(*report)
+			defer os.RemoveAll(rep.tmpDir)
+			_, err := rep.Generate(context.Background())
+			So(err, ShouldNotBeNil)
+			_, err = os.Stat(rep.texPath())
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata with the current output")
+
+func TestCreateTexGolden(t *testing.T) {
+	Convey("When creating the tex file of the test dashboard", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		for _, layout := range []struct {
+			golden       string
+			useRowLayout bool
+		}{{"testdata/grid.tex.golden", false}, {"testdata/row.tex.golden", true}} {
+			Convey("It should match "+layout.golden, func() {
+				// An absolute time range keeps the resolved times in the tex file stable
+				tr := grafana.TimeRange{From: "1500000000000", To: "1500021600000"}
+				rep := New(gClient, "testDash", tr, "", layout.useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				if *updateGolden {
+					So(ioutil.WriteFile(layout.golden, tex, 0666), ShouldBeNil)
+				}
+				golden, err := ioutil.ReadFile(layout.golden)
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldEqual, string(golden))
+			})
+		}
+	})
+}
+
+func TestParseTemplateCache(t *testing.T) {
+	Convey("When parsing the same template with different options", t, func() {
+		const content = `[[ PanelCaption . ]]`
+		p := grafana.Panel{Title: "Title", Description: "Description"}
+		var wg sync.WaitGroup
+		captions := make([]string, 2)
+		for i, source := range []CaptionSource{CaptionTitle, CaptionDescription} {
+			wg.Add(1)
+			go func(i int, source CaptionSource) {
+				defer wg.Done()
+				tmpl, err := parseTemplate("cached", content, Options{CaptionSource: source})
+				if err != nil {
+					return
+				}
+				var b bytes.Buffer
+				if tmpl.Execute(&b, p) == nil {
+					captions[i] = b.String()
+				}
+			}(i, source)
+		}
+		wg.Wait()
+
+		Convey("Each clone should use its own options", func() {
+			So(captions[0], ShouldContainSubstring, "Title")
+			So(captions[1], ShouldContainSubstring, "Description")
+			So(captions[1], ShouldNotContainSubstring, "Title")
+		})
+
+		Convey("The template should be parsed once", func() {
+			parsedTemplates.Lock()
+			defer parsedTemplates.Unlock()
+			So(parsedTemplates.m, ShouldContainKey, "cached\x00"+content)
+		})
+	})
+}
+
+func TestPaperSize(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+
+		Convey("By default it should be on letter paper with the layout's margin", func() {
+			for _, layout := range []struct {
+				useRowLayout bool
+				geometry     string
+			}{
+				{false, `\usepackage[paper=letterpaper, margin=1in]{geometry}`},
+				{true, `\usepackage[paper=letterpaper, landscape, margin=0.5in]{geometry}`},
+			} {
+				rep := New(gClient, "testDash", tr, "", layout.useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, layout.geometry)
+			}
+		})
+
+		Convey("With A4 paper and a margin it should use them", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{PaperSize: PaperA4, Margin: "2cm"}).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\usepackage[paper=a4paper, landscape, margin=2cm]{geometry}`)
+		})
+
+		Convey("With an invalid margin it should fail", func() {
+			rep := New(gClient, "testDash", tr, "", false, Options{Margin: "2cm]{x}"}).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dashboard, nil), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Paper sizes should be validated", t, func() {
+		So(PaperSize("").Valid(), ShouldBeTrue)
+		So(PaperA4.Valid(), ShouldBeTrue)
+		So(PaperSize("legal").Valid(), ShouldBeFalse)
+		So(ValidMargin("0.75in"), ShouldBeTrue)
+		So(ValidMargin("2"), ShouldBeFalse)
+	})
+}
+
+func TestBookmarks(t *testing.T) {
+	Convey("When generating a report of a dashboard with rows", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dash := parseDashboard(`{"Dashboard": {"Title": "Rows", "panels": [
+			{"type": "graph", "id": 1, "gridPos": {"y": 0, "w": 24}},
+			{"type": "row", "id": 10, "title": "Web & API", "gridPos": {"y": 1}},
+			{"type": "graph", "id": 2, "gridPos": {"y": 2, "w": 24}},
+			{"type": "row", "id": 11, "title": "DB", "collapsed": true, "gridPos": {"y": 3}, "panels": [
+				{"type": "graph", "id": 3, "gridPos": {"y": 4, "w": 24}}]}]}}`)
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("Each row (row layout: %v) should get an escaped bookmark", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dash, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\pdfbookmark[1]{Web \& API}{row10}`)
+				So(string(tex), ShouldContainSubstring, `\pdfbookmark[1]{DB}{row11}`)
+				So(strings.Count(string(tex), `\pdfbookmark`), ShouldEqual, 2)
+			})
+		}
+
+		Convey("With a table of contents the rows should be bookmarked by it instead", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{TableOfContents: true}).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dash, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\addcontentsline{toc}{section}{Web \& API}`)
+			So(string(tex), ShouldNotContainSubstring, `\pdfbookmark`)
+		})
+	})
+}
+
+func TestPackedPanels(t *testing.T) {
+	Convey("When generating a grid layout report of small panels", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dash := parseDashboard(`{"Dashboard": {"Title": "Small", "panels": [
+			{"type": "graph", "id": 1, "gridPos": {"y": 0, "x": 0, "w": 12}},
+			{"type": "graph", "id": 2, "gridPos": {"y": 0, "x": 12, "w": 12}},
+			{"type": "stat", "id": 3, "gridPos": {"y": 1, "x": 0, "w": 6}},
+			{"type": "stat", "id": 4, "gridPos": {"y": 1, "x": 6, "w": 6}},
+			{"type": "graph", "id": 5, "gridPos": {"y": 2, "w": 24}}]}}`)
+		rep := New(gClient, "testDash", tr, "", false, Options{}).(*report)
+		defer rep.Clean()
+		So(rep.createTex(dash, nil), ShouldBeNil)
+		texBytes, err := ioutil.ReadFile(rep.texPath())
+		So(err, ShouldBeNil)
+		tex := string(texBytes)
+
+		Convey("Panels should be in minipages of their grid width", func() {
+			So(strings.Count(tex, `\begin{minipage}{0.48\textwidth}`), ShouldEqual, 2)
+			So(strings.Count(tex, `\begin{minipage}{0.24\textwidth}`), ShouldEqual, 2)
+		})
+
+		Convey("Packed panels should not be separated by paragraph breaks", func() {
+			// Only comment lines may come between the minipages of panels 1 to 4
+			So(regexp.MustCompile(`end\{minipage\}%\n(\s*%[^\n]*\n)*\s*\\begin\{minipage\}`).FindAllString(tex, -1), ShouldHaveLength, 3)
+		})
+
+		Convey("A full width panel should get its own line", func() {
+			So(tex, ShouldContainSubstring, `\includegraphics[width=0.9\textwidth]{images/image5.png}`)
+		})
+	})
+
+	Convey("Panel widths should follow the grid", t, func() {
+		So(packPanel(grafana.Panel{Type: "graph", GridPos: grafana.GridPos{W: 16}}), ShouldBeTrue)
+		So(packPanel(grafana.Panel{Type: "graph", GridPos: grafana.GridPos{W: 18}}), ShouldBeFalse)
+		So(packPanel(grafana.Panel{Type: "graph"}), ShouldBeFalse)
+		So(packPanel(grafana.Panel{Type: "stat"}), ShouldBeTrue)
+		So(panelWidth(grafana.Panel{Type: "stat"}), ShouldEqual, "0.30")
+		So(panelWidth(grafana.Panel{GridPos: grafana.GridPos{W: 8}}), ShouldEqual, "0.32")
+	})
+}
+
+func TestCoverPage(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("With a cover page (row layout: %v) it should open the report", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{CoverPage: true, CoverLogo: "/etc/logo.png"}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				texBytes, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				tex := string(texBytes)
+				So(tex, ShouldContainSubstring, `\includegraphics[width=0.4\textwidth,height=4cm,keepaspectratio]{/etc/logo.png}`)
+				So(tex, ShouldContainSubstring, `{\color{accent}\Huge\textbf{My first dashboard}}`)
+				So(tex, ShouldContainSubstring, `to now)}`)
+				So(tex, ShouldContainSubstring, `{\Large `+time.Now().UTC().Add(-time.Hour).Format("2 Jan 2006")+` `)
+				So(tex, ShouldContainSubstring, `Generated on `+time.Now().Format("2 January 2006"))
+				So(strings.Index(tex, `\newpage`), ShouldBeLessThan, strings.Index(tex, `\maketitle`))
+			})
+		}
+
+		Convey("Without a cover page there should be none", func() {
+			rep := New(gClient, "testDash", tr, "", false, Options{}).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, `Generated on`)
+		})
+	})
+}
+
+func TestTimeRangeText(t *testing.T) {
+	Convey("When showing the time range of a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+
+		Convey("An absolute range should be shown in the report's timezone", func() {
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "1500000000000", To: "1500021600000"}, "", false, Options{Timezone: "Europe/Berlin"}).(*report)
+			data, err := rep.newTemplData(dashboard, nil)
+			So(err, ShouldBeNil)
+			So(data.FromResolved, ShouldEqual, "14 Jul 2017 04:40 CEST")
+			So(data.TimeRangeText, ShouldEqual, "14 Jul 2017 04:40 CEST to 14 Jul 2017 10:40 CEST")
+		})
+
+		Convey("A relative range should be resolved and shown with the relative range", func() {
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "now/d", To: "now/d"}, "", false, Options{}).(*report)
+			data, err := rep.newTemplData(dashboard, nil)
+			So(err, ShouldBeNil)
+			today := time.Now().UTC().Format("2 Jan 2006")
+			So(data.FromResolved, ShouldEqual, today+" 00:00 UTC")
+			So(data.TimeRangeText, ShouldEndWith, " (now/d to now/d)")
+		})
+
+		Convey("An unrecognised range should be shown as requested", func() {
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "yesterday", To: "now"}, "", false, Options{}).(*report)
+			data, err := rep.newTemplData(dashboard, nil)
+			So(err, ShouldBeNil)
+			So(data.FromResolved, ShouldBeEmpty)
+			So(data.TimeRangeText, ShouldEqual, "yesterday to now")
+		})
+	})
+}
+
+func TestQRCode(t *testing.T) {
+	Convey("When generating a report with a QR code of the dashboard link", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		opts := Options{QRCode: true, PublicURL: "https://grafana.example.com"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("The QR code (row layout: %v) should link to the dashboard", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, opts).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `{\includegraphics[width=2.5cm]{qrcode.png}}`)
+
+				f, err := os.Open(filepath.Join(rep.tmpDir, qrCodeFile))
+				So(err, ShouldBeNil)
+				defer f.Close()
+				img, err := png.Decode(f)
+				So(err, ShouldBeNil)
+				So(img.Bounds().Dx(), ShouldEqual, qrCodeSize)
+			})
+		}
+
+		Convey("With a cover page it should only be on the cover", func() {
+			opts.CoverPage = true
+			rep := New(gClient, "testDash", tr, "", false, opts).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(strings.Count(string(tex), `qrcode.png`), ShouldEqual, 1)
+			So(string(tex), ShouldContainSubstring, `{\includegraphics[width=3cm]{qrcode.png}}`)
+		})
+
+		Convey("Without a public URL there should be none", func() {
+			opts.PublicURL = ""
+			rep := New(gClient, "testDash", tr, "", false, opts).(*report)
+			defer rep.Clean()
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, `qrcode.png`)
+			_, err = os.Stat(filepath.Join(rep.tmpDir, qrCodeFile))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDashboardImage(t *testing.T) {
+	Convey("When generating a report of the whole dashboard as one image", t, func() {
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		opts := Options{DashboardImage: true, LaTeXRunner: &fakeLaTeX{}}
+
+		Convey("It should embed the dashboard image instead of rendering each panel", func() {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			rep := New(gClient, "testDash", tr, "", false, opts).(*report)
+			defer rep.Clean()
+			pdf, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer pdf.Close()
+			So(gClient.getPanelCallCount, ShouldEqual, 0)
+
+			img, err := ioutil.ReadFile(filepath.Join(rep.imgDirPath(), dashboardImageFile))
+			So(err, ShouldBeNil)
+			So(string(img), ShouldEqual, "Not actually a png of the dashboard")
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `keepaspectratio]{dashboard.png}`)
+			So(string(tex), ShouldNotContainSubstring, `image44`)
+		})
+
+		Convey("A failed render should fail the report", func() {
+			rep := New(&errClient{0, url.Values{}}, "testDash", tr, "", false, opts).(*report)
+			_, err := rep.Generate(context.Background())
+			So(errors.Is(err, ErrRender), ShouldBeTrue)
+		})
+	})
+}
+
+func TestWatermark(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("With a watermark (row layout: %v) it should be set up escaped", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{Watermark: "R&D only"}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\usepackage{draftwatermark}`)
+				So(string(tex), ShouldContainSubstring, `\SetWatermarkText{R\&D only}`)
+			})
+
+			Convey(fmt.Sprintf("Without a watermark (row layout: %v) there should be none", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldNotContainSubstring, `draftwatermark`)
+			})
+		}
+	})
+}
+
+func TestHeaderImage(t *testing.T) {
+	Convey("When generating a row layout report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		Convey("With a header image it should be in the page header", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{HeaderImage: "/srv/reporter/header.png"}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\lhead{\includegraphics[width=0.9\paperwidth,height=2cm,keepaspectratio]{/srv/reporter/header.png}}`)
+		})
+
+		Convey("Without a header image there should be no header", func() {
+			rep := New(gClient, "testDash", tr, "", true, Options{}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, `\lhead`)
+			So(string(tex), ShouldNotContainSubstring, `reporter-images-DO-NOT-DELETE`)
+		})
+	})
+}
+
+func TestFooterText(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("The footer text (row layout: %v) should be escaped in the footer", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{FooterText: "R&D Inc."}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\fancyfoot[C]{R\&D Inc.}`)
+			})
+
+			Convey(fmt.Sprintf("Without footer text (row layout: %v) the footer should name the reporter", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\fancyfoot[C]{Generated by Grafana Reporter}`)
+				So(string(tex), ShouldNotContainSubstring, "Splitpoint")
+			})
+		}
+	})
+}
+
+func TestPDFMetadata(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("The PDF metadata (row layout: %v) should be set escaped", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{Author: "Ops & SRE"}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				dashboard.Tags = []string{"prod", "web_tier"}
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `pdftitle={My first dashboard}`)
+				So(string(tex), ShouldContainSubstring, `pdfauthor={Ops \& SRE}`)
+				So(string(tex), ShouldContainSubstring, `pdfsubject={Grafana dashboard report from now-1h to now}`)
+				So(string(tex), ShouldContainSubstring, `pdfkeywords={prod, web\_tier}`)
+			})
+		}
+
+		Convey("Without an author, the reporter should be the author", func() {
+			So(pdfAuthor(""), ShouldEqual, "Grafana Reporter")
+		})
+	})
+}
+
+func TestExcludeFailedImage(t *testing.T) {
+	Convey("When LaTeX fails on a panel image", t, func() {
+		rep := New(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{}, "", false, Options{}).(*report)
+		defer rep.Clean()
+		So(os.MkdirAll(rep.tmpDir, 0777), ShouldBeNil)
+		tex := "\\begin{document}\n\\includegraphics[width=0.9\\textwidth]{images/image1.png}\n\\includegraphics[width=0.9\\textwidth]{images/image2.png}\n\\end{document}"
+		So(ioutil.WriteFile(rep.texPath(), []byte(tex), 0666), ShouldBeNil)
+
+		Convey("It should replace the image named in the error with a placeholder", func() {
+			img, err := rep.excludeFailedImage([]byte("This is pdfTeX\n! LaTeX Error: File `images/image2.png' not found.\n\nl.3 ...0.9\\textwidth]{images/image2.png}\n"))
+			So(err, ShouldBeNil)
+			So(img, ShouldEqual, "images/image2.png")
+			out, _ := ioutil.ReadFile(rep.texPath())
+			So(string(out), ShouldContainSubstring, "{images/image1.png}")
+			So(string(out), ShouldContainSubstring, "\\fbox{Image images/image2.png could not be included}")
+			So(string(out), ShouldContainSubstring, "% excluded after LaTeX error: \\includegraphics[width=0.9\\textwidth]{images/image2.png}")
+		})
+
+		Convey("It should use the error line number if the image is not named", func() {
+			img, err := rep.excludeFailedImage([]byte("This is pdfTeX\n! Dimension too large.\n\nl.2 ...\n"))
+			So(err, ShouldBeNil)
+			So(img, ShouldEqual, "images/image1.png")
+		})
+
+		Convey("It should give up on errors that are not caused by an image", func() {
+			_, err := rep.excludeFailedImage([]byte("This is pdfTeX\n! Undefined control sequence.\n\nl.4 \\end{document}\n"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPanelFilter(t *testing.T) {
+	Convey("When filtering panels by id", t, func() {
+		filter := PanelFilter{Include: []int{1, 22, 33}, Exclude: []int{22}}
+
+		Convey("Included panels that are not excluded should be kept", func() {
+			So(filter.Keeps(grafana.Panel{Id: 1}), ShouldBeTrue)
+			So(filter.Keeps(grafana.Panel{Id: 22}), ShouldBeFalse)
+			So(filter.Keeps(grafana.Panel{Id: 44}), ShouldBeFalse)
+		})
+
+		Convey("With tags, only panels carrying any of them should be kept", func() {
+			tagged := PanelFilter{Tags: []string{"exec-summary", "sla"}}
+			So(tagged.Active(), ShouldBeTrue)
+			So(tagged.Keeps(grafana.Panel{Id: 1, Tags: []string{"sla"}}), ShouldBeTrue)
+			So(tagged.Keeps(grafana.Panel{Id: 2, Tags: []string{"debug"}}), ShouldBeFalse)
+			So(tagged.Keeps(grafana.Panel{Id: 3}), ShouldBeFalse)
+			So(ParseTags(" exec-summary,,sla "), ShouldResemble, []string{"exec-summary", "sla"})
+		})
+
+		Convey("An empty filter should keep all panels", func() {
+			So(PanelFilter{}.Active(), ShouldBeFalse)
+			So(PanelFilter{}.Keeps(grafana.Panel{Id: 44}), ShouldBeTrue)
+		})
+
+		Convey("Only the kept panels should be rendered and in the report", func() {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", false, Options{Panels: filter, LaTeXRunner: &fakeLaTeX{}}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(gClient.getPanelCallCount, ShouldEqual, 2)
+
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, "image1.png")
+			So(string(tex), ShouldContainSubstring, "image33.png")
+			So(string(tex), ShouldNotContainSubstring, "image22.png")
+		})
+
+		Convey("Panel ids should be parsed from comma separated lists", func() {
+			ids, err := ParsePanelIDs(" 2, 5,8,")
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []int{2, 5, 8})
+			ids, err = ParsePanelIDs("")
+			So(err, ShouldBeNil)
+			So(ids, ShouldBeEmpty)
+			_, err = ParsePanelIDs("2,five")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestValidateTemplate(t *testing.T) {
+	Convey("When validating templates", t, func() {
+		Convey("The built-in templates should be valid", func() {
+			So(ValidateTemplate(defaultTemplate), ShouldBeNil)
+			So(ValidateTemplate(rowBasedTemplate), ShouldBeNil)
+		})
+
+		Convey("Parse errors should be reported", func() {
+			err := ValidateTemplate(`\title{[[ .Title ]}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "parsing")
+		})
+
+		Convey("Execution errors such as unknown fields should be reported", func() {
+			err := ValidateTemplate(`[[range .Panels]][[ .NoSuchField ]][[end]]`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "executing")
+		})
+	})
+}
+
+func TestAccentColor(t *testing.T) {
+	Convey("When converting an accent color for LaTeX", t, func() {
+		Convey("A #RRGGBB color should become an xcolor HTML value", func() {
+			c, err := LaTeXColor("#1f77b4")
+			So(err, ShouldBeNil)
+			So(c, ShouldEqual, "1F77B4")
+		})
+
+		Convey("No color should be black", func() {
+			c, err := LaTeXColor("")
+			So(err, ShouldBeNil)
+			So(c, ShouldEqual, "000000")
+		})
+
+		Convey("Malformed colors should be rejected", func() {
+			for _, hex := range []string{"red", "#fff", "#12345G", "#1234567"} {
+				_, err := LaTeXColor(hex)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("When generating a report with an accent color", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", true, Options{AccentColor: "#C8102E"}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		So(rep.createTex(dashboard, nil), ShouldBeNil)
+		tex, err := ioutil.ReadFile(rep.texPath())
+		So(err, ShouldBeNil)
+
+		Convey("The template should define it as the accent color", func() {
+			So(string(tex), ShouldContainSubstring, `\definecolor{accent}{HTML}{C8102E}`)
+		})
+	})
+}
+
+func TestLegend(t *testing.T) {
+	Convey("When parsing a legend", t, func() {
+		Convey("It should read one color and meaning per line", func() {
+			legend, err := ParseLegend(strings.NewReader("#73BF69: Healthy\n\n  #F2495C : Needs attention: call ops\n"))
+			So(err, ShouldBeNil)
+			So(legend, ShouldResemble, []LegendEntry{{"#73BF69", "Healthy"}, {"#F2495C", "Needs attention: call ops"}})
+		})
+
+		Convey("It should reject lines without a meaning or with an invalid color", func() {
+			_, err := ParseLegend(strings.NewReader("#73BF69\n"))
+			So(err, ShouldNotBeNil)
+			_, err = ParseLegend(strings.NewReader("green: Healthy\n"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("With a legend (row layout: %v) it should add a legend page", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{Legend: []LegendEntry{{"#73bf69", "Healthy & fine"}}}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\section*{\color{accent}Legend}`)
+				So(string(tex), ShouldContainSubstring, `\textcolor[HTML]{73BF69}{\rule{1em}{1em}} & Healthy \& fine`)
+			})
+
+			Convey(fmt.Sprintf("Without a legend (row layout: %v) it should not", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldNotContainSubstring, "Legend")
+			})
+		}
+	})
+}
+
+func TestAssets(t *testing.T) {
+	Convey("When generating a report with an asset directory", t, func() {
+		assetDir, err := ioutil.TempDir("", "assets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(assetDir)
+		So(os.MkdirAll(filepath.Join(assetDir, "fonts"), 0777), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(assetDir, "logo.png"), []byte("logo"), 0666), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(assetDir, "fonts", "brand.otf"), []byte("font"), 0666), ShouldBeNil)
+
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{AssetDir: assetDir}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		So(rep.createTex(dashboard, nil), ShouldBeNil)
+
+		Convey("Its files should be copied next to the tex file", func() {
+			logo, err := ioutil.ReadFile(filepath.Join(rep.tmpDir, "logo.png"))
+			So(err, ShouldBeNil)
+			So(string(logo), ShouldEqual, "logo")
+			font, err := ioutil.ReadFile(filepath.Join(rep.tmpDir, "fonts", "brand.otf"))
+			So(err, ShouldBeNil)
+			So(string(font), ShouldEqual, "font")
+		})
+	})
+}
+
+func TestPanelCaption(t *testing.T) {
+	Convey("When captioning a panel", t, func() {
+		p := grafana.Panel{Id: 1, Type: "graph", Title: "Disk_usage", Description: "Free space in % "}
+
+		Convey("The title should be shown by default", func() {
+			So(panelCaption(p, ""), ShouldEqual, `{ \small Disk\_usage }`)
+			So(panelCaption(p, CaptionTitle), ShouldEqual, `{ \small Disk\_usage }`)
+		})
+
+		Convey("The description should be shown when chosen", func() {
+			So(panelCaption(p, CaptionDescription), ShouldEqual, `{ \small Free space in \% }`)
+			So(panelCaption(grafana.Panel{Title: "t"}, CaptionDescription), ShouldEqual, "")
+		})
+
+		Convey("Both should show the title in bold with the description beneath", func() {
+			So(panelCaption(p, CaptionBoth), ShouldEqual, `{ \small \textbf{Disk\_usage} } \par { \footnotesize Free space in \% }`)
+			So(panelCaption(grafana.Panel{Title: "t"}, CaptionBoth), ShouldEqual, `{ \small \textbf{t} }`)
+		})
+
+		Convey("Nothing should be shown for none", func() {
+			So(panelCaption(p, CaptionNone), ShouldEqual, "")
+		})
+	})
+}
+
+func TestAnnotationTimeline(t *testing.T) {
+	Convey("When placing annotations on a time range", t, func() {
+		tr := grafana.TimeRange{From: "1453206447000", To: "1453213647000"}
+		annotations := []grafana.Annotation{
+			{Time: 1453211247000, TimeEnd: 1453213647000, Text: "Incident\n  #42"},
+			{Time: 1453208247000, Text: "Deploy v1.2"},
+			{Time: 1453200000000, Text: strings.Repeat("x", 50)},
+		}
+		marks := timelineMarks(annotations, tr)
+
+		Convey("They should be ordered by time with positions as fractions of the range", func() {
+			So(len(marks), ShouldEqual, 3)
+			So(marks[0].Start, ShouldEqual, 0)
+			So(marks[1].Start, ShouldEqual, 0.25)
+			So(marks[1].End, ShouldEqual, 0.25)
+			So(marks[2].Start, ShouldEqual, 0.667)
+			So(marks[2].End, ShouldEqual, 1)
+		})
+
+		Convey("Labels should have the time and the shortened text", func() {
+			So(marks[1].Label, ShouldEndWith, "Deploy v1.2")
+			So(marks[2].Label, ShouldEndWith, "Incident #42")
+			So(marks[0].Label, ShouldEndWith, strings.Repeat("x", 40)+"...")
+		})
+
+		Convey("Unrecognised time ranges should give no marks", func() {
+			So(timelineMarks(annotations, grafana.TimeRange{From: "yesterday", To: "now"}), ShouldBeEmpty)
+		})
+	})
+
+	Convey("When generating a report", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "1453206447000", To: "1453213647000"}
+
+		Convey("With the annotation timeline it should draw the annotations", func() {
+			rep := New(gClient, "testDash", tr, "", false, Options{AnnotationTimeline: true}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.fetchAnnotations(context.Background(), dashboard.Uid)
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\begin{tikzpicture}`)
+			So(string(tex), ShouldContainSubstring, `\draw[accent,line width=2pt] (0.25,0) -- (0.25,0);`)
+		})
+
+		Convey("Without it there should be no timeline", func() {
+			rep := New(gClient, "testDash", tr, "", false, Options{}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.fetchAnnotations(context.Background(), dashboard.Uid)
+			So(rep.createTex(dashboard, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, `\begin{tikzpicture}`)
+		})
+	})
+}
+
+func TestFetchImagesCancellation(t *testing.T) {
+	Convey("When the context is cancelled before images are fetched", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{}).(*report)
+		defer rep.Clean()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		dashboard, _ := gClient.GetDashboard(ctx, "")
+		_, err := rep.fetchImages(ctx, dashboard, "testDash")
+
+		Convey("No downloads should be started", func() {
+			So(gClient.getPanelCallCount, ShouldEqual, 0)
+		})
+
+		Convey("The context error should be returned", func() {
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDashboardURL(t *testing.T) {
+	Convey("When linking to the dashboard", t, func() {
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		Convey("It should use the public URL and the dashboard uid", func() {
+			u := dashboardURL("https://grafana.example.com/", grafana.Dashboard{Uid: "abc"}, "my-dash", tr)
+			So(u, ShouldEqual, "https://grafana.example.com/d/abc?from=now-1h&to=now")
+		})
+
+		Convey("It should fall back to the slug without a uid", func() {
+			u := dashboardURL("https://grafana.example.com", grafana.Dashboard{}, "my-dash", tr)
+			So(u, ShouldEqual, "https://grafana.example.com/dashboard/db/my-dash?from=now-1h&to=now")
+		})
+
+		Convey("There should be no link without a public URL", func() {
+			So(dashboardURL("", grafana.Dashboard{Uid: "abc"}, "my-dash", tr), ShouldEqual, "")
+		})
+
+		Convey("The link should be escaped for LaTeX", func() {
+			So(escapeURL("https://g/d/a?var-x=a%20b#p"), ShouldEqual, `https://g/d/a?var-x=a\%20b\#p`)
+		})
+	})
+
+	Convey("When generating a report with a public URL", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{PublicURL: "https://grafana.example.com"}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		So(rep.createTex(dashboard, nil), ShouldBeNil)
+		tex, err := ioutil.ReadFile(rep.texPath())
+		So(err, ShouldBeNil)
+
+		Convey("The tex file should link to the dashboard on it", func() {
+			So(string(tex), ShouldContainSubstring, `\href{https://grafana.example.com/dashboard/db/testDash?from=now-1h&to=now}`)
+		})
+	})
+}
+
+// limitedClient fails renders beyond a number of concurrent ones, like Grafana's renderer
+type limitedClient struct {
+	mockGrafanaClient
+	mu       sync.Mutex
+	limit    int
+	active   int
+	refusals int
+}
+
+func (l *limitedClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	l.mu.Lock()
+	if l.active >= l.limit {
+		l.refusals++
+		l.mu.Unlock()
+		return nil, fmt.Errorf("panel %d: %w", p.Id, grafana.ErrConcurrentRenderLimit)
+	}
+	l.active++
+	l.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+}
+
+func TestRenderConcurrencyLimit(t *testing.T) {
+	concurrencyRetrySleepTime = time.Millisecond
+
+	Convey("When Grafana's renderer refuses renders beyond its concurrency limit", t, func() {
+		gClient := &limitedClient{limit: 2}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+
+		Convey("The refused panels should be retried with fewer concurrent renders", func() {
+			So(err, ShouldBeNil)
+			So(gClient.refusals, ShouldBeGreaterThan, 0)
+			for _, p := range dashboard.GetGridPanels() {
+				if p.Type == "text" {
+					continue
+				}
+				_, err := os.Stat(rep.imgFilePath(p.Id))
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("When the number of concurrent renders is bounded by MaxRenders", t, func() {
+		gClient := &limitedClient{limit: 2}
+		rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false, Options{MaxRenders: 2}).(*report)
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+
+		Convey("No render should exceed the bound", func() {
+			So(err, ShouldBeNil)
+			So(gClient.refusals, ShouldEqual, 0)
+		})
+	})
+
+	Convey("When reducing the render limit", t, func() {
+		l := newRenderLimiter(math.MaxInt32)
+		for i := 0; i < 6; i++ {
+			So(l.acquire(context.Background()), ShouldBeNil)
+		}
+
+		Convey("It should halve the renders in flight, down to one", func() {
+			l.reduce()
+			So(l.limit, ShouldEqual, 3)
+			for i := 0; i < 5; i++ {
+				l.release()
+			}
+			l.reduce()
+			So(l.limit, ShouldEqual, 1)
+			l.reduce()
+			So(l.limit, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	Convey("When generating reports", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		generated := testutil.ToFloat64(reportsGenerated.WithLabelValues("html"))
+		failed := testutil.ToFloat64(reportsFailed.WithLabelValues("html"))
+
+		Convey("A successful report should be counted as generated", func() {
+			rep := New(gClient, "testDash", tr, "", false, Options{Format: FormatHTML}).(*report)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			file.Close()
+			So(testutil.ToFloat64(reportsGenerated.WithLabelValues("html")), ShouldEqual, generated+1)
+			So(testutil.ToFloat64(reportsFailed.WithLabelValues("html")), ShouldEqual, failed)
+		})
+
+		Convey("A cancelled report should be counted as failed", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			rep := New(gClient, "testDash", tr, "", false, Options{Format: FormatHTML}).(*report)
+			defer rep.Clean()
+			_, err := rep.Generate(ctx)
+			So(err, ShouldNotBeNil)
+			So(testutil.ToFloat64(reportsFailed.WithLabelValues("html")), ShouldEqual, failed+1)
+			So(testutil.ToFloat64(reportsGenerated.WithLabelValues("html")), ShouldEqual, generated)
+		})
+	})
+}
+
+// stubReport is a report of a fixed PDF, counting how often it is cleaned
+type stubReport struct {
+	title   string
+	err     error
+	cleaned int
+}
+
+func (s *stubReport) Generate(ctx context.Context) (io.ReadCloser, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return ioutil.NopCloser(strings.NewReader("%PDF " + s.title)), nil
+}
+
+func (s *stubReport) Title() string { return s.title }
+
+func (s *stubReport) Clean() { s.cleaned++ }
+
+func TestMultiReport(t *testing.T) {
+	Convey("When combining the reports of several dashboards", t, func() {
+		// A stand-in for pdflatex that only creates the PDF of the tex file it is given
+		dir, err := ioutil.TempDir("", "reporter-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		script := "#!/bin/sh\nfor a; do f=$a; done\n: > \"${f%.tex}.pdf\"\n"
+		So(ioutil.WriteFile(filepath.Join(dir, "pdflatex"), []byte(script), 0755), ShouldBeNil)
+		t.Setenv("PATH", dir)
+
+		first, second := &stubReport{title: "Web & API"}, &stubReport{title: "Database"}
+		rep := NewMulti([]Report{first, second}, Options{}).(*multiReport)
+		defer rep.Clean()
+
+		Convey("Each dashboard's PDF should be included with a bookmark, in order", func() {
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			So(rep.Title(), ShouldEqual, "Web & API, Database")
+
+			pdf, err := ioutil.ReadFile(filepath.Join(rep.combiner.tmpDir, "dashboard2.pdf"))
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldEqual, "%PDF Database")
+			tex, err := ioutil.ReadFile(rep.combiner.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\includepdf[pages=-,addtotoc={1,section,1,{Web \& API},dashboard1}]{dashboard1.pdf}`)
+			So(string(tex), ShouldContainSubstring, `\includepdf[pages=-,addtotoc={1,section,1,{Database},dashboard2}]{dashboard2.pdf}`)
+			So(strings.Index(string(tex), "dashboard1.pdf"), ShouldBeLessThan, strings.Index(string(tex), "dashboard2.pdf"))
+		})
+
+		Convey("The reports of the dashboards should be cleaned once combined", func() {
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			file.Close()
+			So(first.cleaned, ShouldBeGreaterThan, 0)
+			So(second.cleaned, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("A failing dashboard should fail the report with its error", func() {
+			second.err = fmt.Errorf("%w: not found", ErrDashboard)
+			_, err := rep.Generate(context.Background())
+			So(errors.Is(err, ErrDashboard), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "dashboard 2 of 2")
+			So(first.cleaned, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Formats other than pdf should be refused", func() {
+			rep := NewMulti([]Report{first, second}, Options{Format: FormatHTML})
+			defer rep.Clean()
+			_, err := rep.Generate(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTextPanels(t *testing.T) {
+	Convey("When converting the markdown of a text panel", t, func() {
+		Convey("Headings, emphasis, code and lists should become LaTeX", func() {
+			tex := markdownToLaTeX("# On call\n\nCall **#ops** or *Jo* at `100%`.\nSee [the wiki](http://wiki).\n\n- web_01\n- web_02\n\n1. First\n2. Second")
+			So(tex, ShouldEqual, `\subsection*{On call}
+
+Call \textbf{\#ops} or \emph{Jo} at \texttt{100\%}.
+See the wiki.
+
+\begin{itemize}
+\item web\_01
+\item web\_02
+\end{itemize}
+
+\begin{enumerate}
+\item First
+\item Second
+\end{enumerate}`)
+		})
+
+		Convey("HTML should be reduced to escaped paragraphs and list items", func() {
+			tex := htmlToLaTeX("<h2>R&amp;D</h2><p>Costs in $</p><ul><li>one</li><li>two</li></ul><!-- hidden -->")
+			So(tex, ShouldEqual, "R\\&D\n\nCosts in \\$\n\n\\textbullet{} one\n\n\\textbullet{} two")
+		})
+	})
+
+	Convey("When generating a report of a dashboard with a text panel", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dash := parseDashboard(`{"Dashboard": {"Title": "Notes", "panels": [
+			{"type": "row", "id": 1, "title": "Overview", "collapsed": true, "panels": [
+				{"type": "text", "id": 2, "title": "Runbook", "gridPos": {"y": 1, "w": 24}, "options": {"content": "## Steps\n- restart _all_"}},
+				{"type": "graph", "id": 3, "gridPos": {"y": 2, "w": 24}}]}]}}`)
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("Its content (row layout: %v) should be written instead of an image", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dash, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `{\large\textbf{Runbook}}`)
+				So(string(tex), ShouldContainSubstring, `\subsubsection*{Steps}`)
+				So(string(tex), ShouldContainSubstring, `\item restart \_all\_`)
+				So(string(tex), ShouldNotContainSubstring, `images/image2.png`)
+				So(string(tex), ShouldContainSubstring, `images/image3.png`)
+			})
+		}
+	})
+}
+
+func TestStatAsText(t *testing.T) {
+	Convey("When formatting the value of a stat panel", t, func() {
+		two := 2
+		percent := grafana.Panel{FieldConfig: grafana.FieldConfig{Defaults: grafana.FieldDefaults{Unit: "percentunit"}}}
+		decimals := grafana.Panel{FieldConfig: grafana.FieldConfig{Defaults: grafana.FieldDefaults{Unit: "ms", Decimals: &two}}}
+		So(formatStatValue(0.98765, percent), ShouldEqual, "98.77%")
+		So(formatStatValue(12, decimals), ShouldEqual, "12.00 ms")
+		So(formatStatValue(1234.5, grafana.Panel{}), ShouldEqual, "1234.5")
+	})
+
+	Convey("When generating a report with stat panels shown as text", t, func() {
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("Their values (row layout: %v) should be written instead of images", useRowLayout), func() {
+				gClient := &mockGrafanaClient{0, url.Values{}}
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{StatAsText: true}).(*report)
+				defer rep.Clean()
+				dashboard, _ := gClient.GetDashboard(context.Background(), "")
+				_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+				So(err, ShouldBeNil)
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\Huge\textbf{99.5}`)
+				So(string(tex), ShouldNotContainSubstring, `images/image1.png`)
+				So(string(tex), ShouldContainSubstring, `images/image22.png`)
+				So(gClient.getPanelCallCount, ShouldEqual, 7)
+			})
+		}
+
+		Convey("Panels whose value cannot be queried should be rendered", func() {
+			gClient := &errClient{0, url.Values{}}
+			rep := New(gClient, "testDash", tr, "", false, Options{StatAsText: true}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+			So(err, ShouldBeNil)
+			So(rep.statValues, ShouldBeEmpty)
+			So(gClient.getPanelCallCount, ShouldEqual, 9)
+		})
+
+		Convey("ZIP reports should keep the images", func() {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			rep := New(gClient, "testDash", tr, "", false, Options{StatAsText: true, Format: FormatZIP}).(*report)
+			defer rep.Clean()
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			_, err := rep.fetchImages(context.Background(), dashboard, "testDash")
+			So(err, ShouldBeNil)
+			So(gClient.getPanelCallCount, ShouldEqual, 9)
+		})
+	})
+}
+
+func TestFormatVariables(t *testing.T) {
+	Convey("When formatting the selected value of a variable", t, func() {
+		cases := []struct {
+			name    string
+			current grafana.CurrentVal
+			want    string
+		}{
+			{"a string", grafana.CurrentVal{Text: "web1", Value: "web1"}, "web1"},
+			{"lists", grafana.CurrentVal{Text: []interface{}{"web1", "web2"}, Value: []interface{}{"web1", "web2"}}, "web1, web2"},
+			{"a value list only", grafana.CurrentVal{Value: []interface{}{"web1", "web2"}}, "web1, web2"},
+			{"a JSON array string", grafana.CurrentVal{Value: `["web1","web2"]`}, "web1, web2"},
+			{"All as strings", grafana.CurrentVal{Text: "All", Value: "$__all"}, "All"},
+			{"All as lists", grafana.CurrentVal{Text: []interface{}{"All"}, Value: []interface{}{"$__all"}}, "All"},
+			{"All in the text", grafana.CurrentVal{Text: "$__all"}, "All"},
+			{"All as a JSON array string", grafana.CurrentVal{Text: "All", Value: `["$__all"]`}, "All"},
+		}
+		for _, c := range cases {
+			Convey("Given "+c.name, func() {
+				v := grafana.TemplateVariable{Name: "host", Multi: true, IncludeAll: true, Current: c.current}
+				So(variableValue(v), ShouldEqual, c.want)
+				So(formatVariables([]grafana.TemplateVariable{v}), ShouldEqual, "host: "+c.want)
+			})
+		}
+
+		Convey("It should show the text, or else the values, of a selection other than All", func() {
+			v := grafana.TemplateVariable{Name: "host", Current: grafana.CurrentVal{Text: "All", Value: []interface{}{"a", "b"}}}
+			So(variableValue(v), ShouldEqual, "All")
+			v.Current.Text = nil
+			So(variableValue(v), ShouldEqual, "a, b")
+		})
+	})
+}
+
+func TestVariablesAppendix(t *testing.T) {
+	Convey("When listing the values of a variable in the appendix", t, func() {
+		all := grafana.TemplateVariable{Name: "host", IncludeAll: true, Current: grafana.CurrentVal{Text: []interface{}{"All"}, Value: "$__all"},
+			Options: []grafana.OptionVal{{Text: "All", Value: "$__all"}, {Text: "web1", Value: "web1"}, {Text: "web2", Value: "web2"}}}
+		So(appendixValue(all), ShouldEqual, "All (web1, web2)")
+		all.Options = nil
+		So(appendixValue(all), ShouldEqual, "All")
+		So(appendixValue(grafana.TemplateVariable{Current: grafana.CurrentVal{Text: []interface{}{"a", "b"}}}), ShouldEqual, "a, b")
+	})
+
+	Convey("When generating a report of a dashboard with variables", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		tr := grafana.TimeRange{From: "now-1h", To: "now"}
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		dashboard.Templating.List = append(dashboard.Templating.List,
+			grafana.TemplateVariable{Name: "env", Label: "Environment_name", Current: grafana.CurrentVal{Text: "prod & dev"}},
+			grafana.TemplateVariable{Name: "secret", Hide: 2, Current: grafana.CurrentVal{Text: "hidden"}})
+
+		for _, useRowLayout := range []bool{false, true} {
+			Convey(fmt.Sprintf("With the appendix (row layout: %v) they should be listed in a table", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{VariablesAppendix: true}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\section*{\color{accent}Dashboard variables}`)
+				So(string(tex), ShouldContainSubstring, `\textbf{test} & testvarvalue \\`)
+				So(string(tex), ShouldContainSubstring, `\textbf{Environment\_name} & prod \& dev \\`)
+				So(string(tex), ShouldNotContainSubstring, `hidden`)
+			})
+
+			Convey(fmt.Sprintf("Without the appendix (row layout: %v) there should be none", useRowLayout), func() {
+				rep := New(gClient, "testDash", tr, "", useRowLayout, Options{}).(*report)
+				defer rep.Clean()
+				So(rep.createTex(dashboard, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldNotContainSubstring, `Dashboard variables`)
+			})
+		}
+	})
+}
+
+func BenchmarkCreateTex(b *testing.B) {
+	gClient := &mockGrafanaClient{0, url.Values{}}
+	var fullDash grafana.FullDashboard
+	if err := json.Unmarshal([]byte(dashJSON), &fullDash); err != nil {
+		b.Fatal(err)
+	}
+	for _, layout := range []struct {
+		name         string
+		useRowLayout bool
+	}{{"grid", false}, {"row", true}} {
+		b.Run(layout.name, func(b *testing.B) {
+			rep := New(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", layout.useRowLayout, Options{}).(*report)
+			defer rep.Clean()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := rep.createTex(fullDash.Dashboard, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTexTemplate(b *testing.B) {
+	for _, layout := range []struct {
+		name         string
+		texTemplate  string
+		useRowLayout bool
+	}{{"grid", defaultTemplate, false}, {"row", rowBasedTemplate, true}} {
+		b.Run(layout.name, func(b *testing.B) {
+			data := sampleTemplData(layout.useRowLayout)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tmpl, err := parseTemplate(reportTexFile, layout.texTemplate, Options{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := tmpl.Execute(ioutil.Discard, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
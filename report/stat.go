@@ -0,0 +1,81 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// statUnits are the suffixes of common Grafana units. Values in other units are shown bare.
+var statUnits = map[string]string{
+	"percent":  "%",
+	"s":        " s",
+	"ms":       " ms",
+	"bytes":    " B",
+	"decbytes": " B",
+	"reqps":    " req/s",
+	"celsius":  " °C",
+}
+
+// formatStatValue formats the value of a stat panel with the panel's decimals and unit
+func formatStatValue(v float64, p grafana.Panel) string {
+	unit := p.FieldConfig.Defaults.Unit
+	if unit == "percentunit" {
+		v, unit = v*100, "percent"
+	}
+	var s string
+	if d := p.FieldConfig.Defaults.Decimals; d != nil && *d >= 0 {
+		s = strconv.FormatFloat(v, 'f', *d, 64)
+	} else {
+		s = strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+	}
+	return s + statUnits[unit]
+}
+
+// statAsText is whether stat-like panels are shown as their value rather than an image. ZIP
+// reports are archives of images, so they keep the images.
+func (rep *report) statAsText() bool {
+	return rep.opts.StatAsText && rep.opts.Format != FormatZIP
+}
+
+// fetchPanel gets the value of a stat-like panel shown as text, falling back to its image if
+// the value cannot be queried, and downloads the image of other panels
+func (rep *report) fetchPanel(ctx context.Context, l *renderLimiter, p grafana.Panel, dashUID string) error {
+	if rep.statAsText() && p.IsSingleStat() {
+		v, err := rep.gClient.GetPanelValue(ctx, p, dashUID, p.TimeRange(rep.time))
+		if err == nil {
+			rep.setStatValue(p.Id, formatStatValue(v, p))
+			return nil
+		}
+		slog.Warn("Could not query the value of the panel, rendering it instead", "panel", p.Id, "title", p.Title, "error", err)
+	}
+	return rep.downloadPanelImageLimited(ctx, l, p, dashUID)
+}
+
+func (rep *report) setStatValue(panelID int, value string) {
+	rep.statMu.Lock()
+	defer rep.statMu.Unlock()
+	if rep.statValues == nil {
+		rep.statValues = map[int]string{}
+	}
+	rep.statValues[panelID] = value
+}
@@ -20,59 +20,146 @@ package report
 const defaultTemplate = `
 %use square brackets as golang text templating delimiters
 \documentclass{article}
+[[if .UnicodeEngine]]\usepackage{fontspec}[[else]]\usepackage[utf8]{inputenc}[[end]] % Unicode input
 \usepackage{graphicx}
-\usepackage[margin=1in]{geometry}
+\usepackage[paper=[[.PaperSize]], margin=[[.Margin]]]{geometry}
 \usepackage{amsmath} % For text formatting options if needed
 \usepackage{fancyhdr} % For headers/footers
+\usepackage{xcolor}
+\usepackage{tikz} % For the annotation timeline
+\definecolor{accent}{HTML}{[[.AccentColor]]} % Brand color of title, headers and rules
 \pagestyle{fancy}
+[[with .Watermark]] \usepackage{draftwatermark} \SetWatermarkText{[[ EscapeLaTeX . ]]} \SetWatermarkScale{0.6} [[end]] % Diagonal text across every page
 
 % Footer configuration
 \fancyfoot[L]{[[ EscapeLaTeX .Title ]]} % Escape title
-\fancyfoot[C]{Generated by Grafana Reporter}
+\fancyfoot[C]{[[ EscapeLaTeX .FooterText ]]}
 \fancyfoot[R]{Page \thepage}
 
 % Header configuration (Example - might need image or different text)
 % \fancyhead[C]{[[ EscapeLaTeX .Title ]]} % Example Header
 \renewcommand{\headrulewidth}{0pt} % Remove header rule if header is empty
+\usepackage[hidelinks,bookmarks=true,bookmarksopen=true]{hyperref} % For the link to the dashboard and the PDF outline
+\hypersetup{pdftitle={[[ EscapeLaTeX .Title ]]}, pdfauthor={[[ EscapeLaTeX .Author ]]}, pdfsubject={[[ EscapeLaTeX .Subject ]]}, pdfkeywords={[[ EscapeLaTeX .Keywords ]]}} % PDF metadata
 
 \graphicspath{ {[[.ImgDir]]/} } % Use ImgDir variable - Single braces
 
 \begin{document}
+[[if .CoverPage]] % Cover page, set by -cover-page
+\thispagestyle{empty} % No header or footer on the cover
+\begin{center}
+\vspace*{3cm}
+[[with .CoverLogo]] \includegraphics[width=0.4\textwidth,height=4cm,keepaspectratio]{[[.]]} \par \vspace{2cm} [[end]] % Set by -cover-logo
+{\color{accent}\Huge\textbf{[[ EscapeLaTeX .Title ]]}} \par
+\vspace{1cm}
+{\Large [[ EscapeLaTeX .TimeRangeText ]]} \par
+[[with .QRCode]] \vspace{2cm} \href{[[ EscapeURL $.DashboardURL ]]}{\includegraphics[width=3cm]{[[.]]}} \par [[end]] % Set by -qr-code
+\vfill
+{\small Generated on [[ EscapeLaTeX .GeneratedAt ]]} \par
+\end{center}
+\newpage
+[[end]]
 % Simple \title, \date, \author for maketitle
-\title{[[ EscapeLaTeX .Title ]]}
-\date{From: [[.FromFormatted]] To: [[.ToFormatted]]} % Uses explicit fields
+\title{\color{accent}[[ EscapeLaTeX .Title ]]}
+\date{[[ EscapeLaTeX .TimeRangeText ]]} % Resolved in the report's timezone
 \author{Grafana Reporter} % Added Author
 
 \maketitle % Generate title block
+{\color{accent}\rule{\textwidth}{0.4pt}}
 
 % Display VariableValues and Description below the main title if they exist
 \begin{center}
 [[if .VariableValues]] \large [[ EscapeLaTeX .VariableValues ]] \par \vspace{2mm} [[end]]
 [[if .Description]] \small [[ EscapeLaTeX .Description ]] \par \vspace{4mm} [[end]]
+[[if .DashboardURL]] \small \href{[[ EscapeURL .DashboardURL ]]}{\color{accent}Open this dashboard in Grafana} \par \vspace{2mm} [[end]]
+[[if and .QRCode (not .CoverPage)]] \href{[[ EscapeURL .DashboardURL ]]}{\includegraphics[width=2.5cm]{[[.QRCode]]}} \par \vspace{2mm} [[end]] % Set by -qr-code
 \end{center}
 
 \thispagestyle{fancy} % Apply fancy style to first page too
 
 \begin{center}
-% Use explicit Panels field
-[[range .Panels]]
-    % Check panel type using helper function if needed, or directly
-    [[if (eq .Type "singlestat")]] % Example direct check
-        \begin{minipage}{0.3\textwidth} % Adjust width as needed
-            \includegraphics[width=\textwidth]{[[ PanelImagePath .Id ]]} % Use PanelImagePath helper
-            % Use simple text formatting for title instead of caption
-            \par { \small [[ EscapeLaTeX .Title ]] } \par
+% Panels up to two thirds of the dashboard wide are packed side by side, in minipages of their
+% grid width. Wider panels and text panels get a line of their own. Lines only holding template
+% actions end in % so they add no paragraph breaks between packed panels. The first panel of each
+% row adds the row to the PDF outline.
+[[if .DashboardImage]] % The whole dashboard as one image, instead of its panels
+    \includegraphics[width=\textwidth,height=0.75\textheight,keepaspectratio]{[[.DashboardImage]]}
+[[else]][[range .Panels]]%
+    [[with index $.RowStarts .Id]] \pdfbookmark[1]{[[ EscapeLaTeX .Title ]]}{row[[.Id]]} [[end]]% Check panel type using helper function if needed, or directly
+    [[if .IsText]] % Text panels are written out instead of rendered
+        \par
+        \vspace{0.5cm}
+        \begin{minipage}{0.9\textwidth} \raggedright
+            [[ with .Title ]] {\large\textbf{[[ EscapeLaTeX . ]]}} \par \vspace{2mm} [[ end ]]
+            [[ PanelText . ]]
         \end{minipage}
+        \par
+        \vspace{0.5cm}
+    [[else if PackPanel .]] % Stat panels and panels sharing their line on the dashboard
+        \begin{minipage}{[[ PanelWidth . ]]\textwidth} \centering
+            [[with index $.StatValues .Id]] {\Huge\textbf{[[ EscapeLaTeX . ]]} \par} \vspace{2mm} % Shown as text by -stat-as-text
+            [[else]] \includegraphics[width=0.95\linewidth]{[[ PanelImagePath .Id ]]} % Use PanelImagePath helper
+            [[end]]
+            % Use simple text formatting instead of caption, as chosen by -caption-source
+            [[ with PanelCaption . ]] \par [[ . ]] \par [[ end ]]
+            [[ with ThresholdCaption . ]] { \footnotesize [[ . ]] } \par [[ end ]]
+            \vspace{2mm}
+        \end{minipage}%
     [[else]] % Handle other panel types (graph, table etc.)
         \par % Ensure block starts on new line
         \vspace{0.5cm}
         \includegraphics[width=0.9\textwidth]{[[ PanelImagePath .Id ]]} % Use PanelImagePath helper
-        % Use simple text formatting for title instead of caption
-        \par { \small [[ EscapeLaTeX .Title ]] } \par
+        % Use simple text formatting instead of caption, as chosen by -caption-source
+        [[ with PanelCaption . ]] \par [[ . ]] \par [[ end ]]
+        [[ with ThresholdCaption . ]] { \footnotesize [[ . ]] } \par [[ end ]]
+        \par
         \vspace{0.5cm}
-    [[end]]
-[[end]] % End range Panels
+    [[end]]%
+[[end]][[end]] % End range Panels
+\end{center}
+
+% Annotations, such as deploys and incidents, on the report's time range
+[[with .Timeline]]
+\begin{center}
+{\small Annotations} \par \vspace{2mm}
+\begin{tikzpicture}[x=0.9\linewidth]
+\draw[->] (0,0) -- (1.02,0);
+\node[below,font=\tiny] at (0,0) {[[or $.FromResolved $.FromFormatted]]};
+\node[below,font=\tiny] at (1,0) {[[or $.ToResolved $.ToFormatted]]};
+[[range .]]\draw[accent,line width=2pt] ([[.Start]],0) -- ([[.End]],0);
+\draw[accent] ([[.Start]],0) -- ([[.Start]],0.3) node[anchor=south west,rotate=45,font=\tiny,inner sep=1pt] {[[ EscapeLaTeX .Label ]]};
+[[end]]
+\end{tikzpicture}
 \end{center}
+[[end]]
+
+[[if .Legend]]
+\newpage % The legend gets a page of its own
+\section*{\color{accent}Legend}
+\begin{tabular}{ll}
+[[range .Legend]] \textcolor[HTML]{[[.Color]]}{\rule{1em}{1em}} & [[ EscapeLaTeX .Meaning ]] \\
+[[end]]
+\end{tabular}
+[[end]]
+
+[[if .Variables]]
+\newpage % The variables get a page of their own
+\section*{\color{accent}Dashboard variables}
+\begin{tabular}{p{0.3\textwidth}p{0.65\textwidth}} % Long values wrap in the second column
+[[range .Variables]] \textbf{[[ EscapeLaTeX (VariableLabel .) ]]} & [[ EscapeLaTeX (VariableValue .) ]] \\
+[[end]]
+\end{tabular}
+[[end]]
+
+[[if .FailedPanels]]
+\newpage % Reviewers should see that the report is incomplete
+\section*{\color{accent}Failed panels}
+The following panels could not be rendered:
+\begin{itemize}
+[[range .FailedPanels]] \item Panel [[.Id]] ([[ EscapeLaTeX .Title ]]): [[ EscapeLaTeX .Err.Error ]]
+[[end]]
+\end{itemize}
+[[end]]
 
 \end{document}
 `
@@ -81,33 +168,53 @@ const defaultTemplate = `
 const rowBasedTemplate = `
 %use square brackets as golang text templating delimiters
 \documentclass[landscape]{article}
-\usepackage[utf8]{inputenc}
+[[if .UnicodeEngine]]\usepackage{fontspec}[[else]]\usepackage[utf8]{inputenc}[[end]] % Unicode input
 \usepackage{graphicx}
-% Adjust paper size and margins for landscape
-\usepackage[paperwidth=11in, paperheight=8.5in, margin=0.5in]{geometry}
+% Paper size and margins, in landscape
+\usepackage[paper=[[.PaperSize]], landscape, margin=[[.Margin]]]{geometry}
 \usepackage{amsmath} % For text formatting options if needed
 \usepackage{fancyhdr} % For headers/footers
+\usepackage{xcolor}
+\usepackage{tikz} % For the annotation timeline
+\definecolor{accent}{HTML}{[[.AccentColor]]} % Brand color of title, headers and rules
 \pagestyle{fancy}
+[[with .Watermark]] \usepackage{draftwatermark} \SetWatermarkText{[[ EscapeLaTeX . ]]} \SetWatermarkScale{0.6} [[end]] % Diagonal text across every page
 
 % Footer configuration
 \fancyfoot[L]{[[ EscapeLaTeX .Title ]]} % Escape title
-\fancyfoot[C]{Splitpoint Solutions} % Use your desired fixed text
+\fancyfoot[C]{[[ EscapeLaTeX .FooterText ]]} % Set by -footer-text
 \fancyfoot[R]{Page \thepage}
 
-% Set header height appropriately to fit the image
+% Header image, set by -header-image. The header height fits the image.
+[[with .HeaderImage]]
 \setlength\headheight{80pt} % Adjust based on image height and desired spacing
+\lhead{\includegraphics[width=0.9\paperwidth,height=2cm,keepaspectratio]{[[.]]}}
+[[end]]
 
-% Header configuration - adjust width for landscape mode
-% Ensure the path to the header image is correct and accessible by the LaTeX compiler
-\lhead{\includegraphics[width=0.9\paperwidth,height=2cm,keepaspectratio]{/home/sps/reporter-images-DO-NOT-DELETE/REPORT-HEADER-05.png}} % Check path carefully!
+\usepackage[hidelinks,bookmarks=true,bookmarksopen=true]{hyperref} % For the link to the dashboard and the PDF outline
+\hypersetup{pdftitle={[[ EscapeLaTeX .Title ]]}, pdfauthor={[[ EscapeLaTeX .Author ]]}, pdfsubject={[[ EscapeLaTeX .Subject ]]}, pdfkeywords={[[ EscapeLaTeX .Keywords ]]}} % PDF metadata
 
 % Tell LaTeX where to find images (relative to the .tex file)
 \graphicspath{ {[[.ImgDir]]/} }
 
 \begin{document}
+[[if .CoverPage]] % Cover page, set by -cover-page
+\thispagestyle{empty} % No header or footer on the cover
+\begin{center}
+\vspace*{3cm}
+[[with .CoverLogo]] \includegraphics[width=0.4\textwidth,height=4cm,keepaspectratio]{[[.]]} \par \vspace{2cm} [[end]] % Set by -cover-logo
+{\color{accent}\Huge\textbf{[[ EscapeLaTeX .Title ]]}} \par
+\vspace{1cm}
+{\Large [[ EscapeLaTeX .TimeRangeText ]]} \par
+[[with .QRCode]] \vspace{2cm} \href{[[ EscapeURL $.DashboardURL ]]}{\includegraphics[width=3cm]{[[.]]}} \par [[end]] % Set by -qr-code
+\vfill
+{\small Generated on [[ EscapeLaTeX .GeneratedAt ]]} \par
+\end{center}
+\newpage
+[[end]]
 % --- Simplified Title Block ---
-\title{[[ EscapeLaTeX .Title ]]}
-\date{Time Range: [[.FromFormatted]] to [[.ToFormatted]]} % Use explicit fields
+\title{\color{accent}[[ EscapeLaTeX .Title ]]}
+\date{Time Range: [[ EscapeLaTeX .TimeRangeText ]]} % Resolved in the report's timezone
 \author{Generated Report}
 \maketitle
 % --- End Title Block ---
@@ -124,14 +231,31 @@ const rowBasedTemplate = `
     \small [[ EscapeLaTeX .Description ]] % Display escaped description
     \par \vspace{4mm} % Add a paragraph break & space
  [[end]]
+ [[if .DashboardURL]] % Link to the dashboard on the public Grafana URL
+    \small \href{[[ EscapeURL .DashboardURL ]]}{\color{accent}Open this dashboard in Grafana}
+    \par \vspace{2mm}
+ [[end]]
+ [[if and .QRCode (not .CoverPage)]] % QR code of the link, set by -qr-code
+    \href{[[ EscapeURL .DashboardURL ]]}{\includegraphics[width=2.5cm]{[[.QRCode]]}}
+    \par \vspace{2mm}
+ [[end]]
 \end{center}
 % --- End Optional Variables/Description ---
 
 
 % Brief explanation of the report
+[[if .ShowRowIntro]]
 \begin{center}
 \large{The following pages contain sections from the Grafana dashboard}
 \end{center}
+[[end]]
+
+% Table of contents linking to the rows, filled in by the second LaTeX pass
+[[if .ShowTOC]]
+\newpage
+\renewcommand{\contentsname}{\color{accent}Contents}
+\tableofcontents
+[[end]]
 
 % Display dashboard rows - one per page - in order
 [[range .Rows]]
@@ -139,8 +263,11 @@ const rowBasedTemplate = `
 \thispagestyle{fancy} % Apply fancy style to subsequent pages
 
 % --- Row Header ---
+[[if $.ShowTOC]] \phantomsection \addcontentsline{toc}{section}{[[ EscapeLaTeX .Title ]]} % Register the row in the table of contents, which also bookmarks it
+[[else if .Title]] \pdfbookmark[1]{[[ EscapeLaTeX .Title ]]}{row[[.Id]]} [[end]] % PDF outline entry of the row
 \begin{center}
-\Large\textbf{[[ EscapeLaTeX .Title ]]} % Display row title (from GrafanaRow)
+{\color{accent}\Large\textbf{[[ EscapeLaTeX .Title ]]}} % Display row title (from GrafanaRow)
+\par {\color{accent}\rule{0.9\textwidth}{0.4pt}}
 \vspace{0.5cm}
 \end{center}
 % --- End Row Header ---
@@ -149,19 +276,77 @@ const rowBasedTemplate = `
 \begin{center} % Center the panel images
   % Loop through the ContentPanels associated with the current row
   [[range .ContentPanels]]
+    [[if .IsText]] % Text panels are written out instead of rendered
+    \par
+    \begin{minipage}{0.9\textwidth} \raggedright
+      [[ with .Title ]] {\large\textbf{[[ EscapeLaTeX . ]]}} \par \vspace{2mm} [[ end ]]
+      [[ PanelText . ]]
+    \end{minipage}
+    \par \vspace{0.5cm}
+    [[else]]
     % Basic layout: display each panel image centered on its own line
     \par % Ensure panels are below each other
-    \includegraphics[width=0.9\textwidth, keepaspectratio]{[[ PanelImagePath .Id ]]} % Include panel image
+    [[with index $.StatValues .Id]] {\Huge\textbf{[[ EscapeLaTeX . ]]}} \par \vspace{2mm} % Shown as text by -stat-as-text
+    [[else]] \includegraphics[width=0.9\textwidth, keepaspectratio]{[[ PanelImagePath .Id ]]} % Include panel image
+    [[end]]
     % *** CHANGE: Replace \caption* with simple text formatting ***
-    \par % Ensure title starts on new line below image
-    { \small [[ EscapeLaTeX .Title ]] } % Display title as small text, centered by parent environment
-    \par % Ensure space after title
+    [[ with PanelCaption . ]]
+    \par % Ensure caption starts on new line below image
+    [[ . ]] % Caption as chosen by -caption-source, centered by parent environment
+    \par % Ensure space after caption
+    [[ end ]]
+    [[ with ThresholdCaption . ]] { \footnotesize [[ . ]] } \par [[ end ]] % Threshold legend of stat-like panels
     \vspace{0.5cm} % Add space between panels
+    [[end]]
   [[end]] % End range .ContentPanels
 \end{center}
 % --- End Display Panels ---
 
 [[end]] % End range .Rows
 
+% Annotations, such as deploys and incidents, on the report's time range
+[[if .Timeline]] \newpage [[end]]
+[[with .Timeline]]
+\begin{center}
+{\small Annotations} \par \vspace{2mm}
+\begin{tikzpicture}[x=0.9\linewidth]
+\draw[->] (0,0) -- (1.02,0);
+\node[below,font=\tiny] at (0,0) {[[or $.FromResolved $.FromFormatted]]};
+\node[below,font=\tiny] at (1,0) {[[or $.ToResolved $.ToFormatted]]};
+[[range .]]\draw[accent,line width=2pt] ([[.Start]],0) -- ([[.End]],0);
+\draw[accent] ([[.Start]],0) -- ([[.Start]],0.3) node[anchor=south west,rotate=45,font=\tiny,inner sep=1pt] {[[ EscapeLaTeX .Label ]]};
+[[end]]
+\end{tikzpicture}
+\end{center}
+[[end]]
+
+[[if .Legend]]
+\newpage % The legend gets a page of its own
+\section*{\color{accent}Legend}
+\begin{tabular}{ll}
+[[range .Legend]] \textcolor[HTML]{[[.Color]]}{\rule{1em}{1em}} & [[ EscapeLaTeX .Meaning ]] \\
+[[end]]
+\end{tabular}
+[[end]]
+
+[[if .Variables]]
+\newpage % The variables get a page of their own
+\section*{\color{accent}Dashboard variables}
+\begin{tabular}{p{0.3\textwidth}p{0.65\textwidth}} % Long values wrap in the second column
+[[range .Variables]] \textbf{[[ EscapeLaTeX (VariableLabel .) ]]} & [[ EscapeLaTeX (VariableValue .) ]] \\
+[[end]]
+\end{tabular}
+[[end]]
+
+[[if .FailedPanels]]
+\newpage % Reviewers should see that the report is incomplete
+\section*{\color{accent}Failed panels}
+The following panels could not be rendered:
+\begin{itemize}
+[[range .FailedPanels]] \item Panel [[.Id]] ([[ EscapeLaTeX .Title ]]): [[ EscapeLaTeX .Err.Error ]]
+[[end]]
+\end{itemize}
+[[end]]
+
 \end{document}
 `
@@ -0,0 +1,83 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// TimelineMark is an annotation placed on the report's time axis. Start and End are
+// fractions of the time range, from 0 to 1.
+type TimelineMark struct {
+	Start float64
+	End   float64
+	Label string
+}
+
+const maxTimelineLabelLength = 40
+
+// fetchAnnotations gets the annotations for the timeline figure. Failing to get them only
+// leaves the figure out, as the rest of the report is still worth having.
+func (rep *report) fetchAnnotations(ctx context.Context, dashUID string) {
+	if !rep.opts.AnnotationTimeline {
+		return
+	}
+	annotations, err := rep.gClient.GetAnnotations(ctx, dashUID, rep.time)
+	if err != nil {
+		slog.Warn("Leaving out the annotation timeline", "error", err)
+		return
+	}
+	rep.annotations = annotations
+}
+
+// timelineMarks places the annotations on the time range, in time order
+func timelineMarks(annotations []grafana.Annotation, t grafana.TimeRange) []TimelineMark {
+	from, to, ok := t.Bounds()
+	if !ok || !to.After(from) {
+		return nil
+	}
+	span := float64(to.Sub(from))
+	position := func(ms int64) float64 {
+		p := float64(time.Unix(0, ms*int64(time.Millisecond)).Sub(from)) / span
+		return math.Round(math.Min(math.Max(p, 0), 1)*1000) / 1000
+	}
+
+	var marks []TimelineMark
+	for _, a := range annotations {
+		end := a.TimeEnd
+		if end < a.Time {
+			end = a.Time
+		}
+		label := time.Unix(0, a.Time*int64(time.Millisecond)).Format("Jan 2 15:04")
+		if text := strings.Join(strings.Fields(a.Text), " "); text != "" {
+			if runes := []rune(text); len(runes) > maxTimelineLabelLength {
+				text = string(runes[:maxTimelineLabelLength]) + "..."
+			}
+			label += " " + text
+		}
+		marks = append(marks, TimelineMark{Start: position(a.Time), End: position(end), Label: label})
+	}
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].Start < marks[j].Start })
+	return marks
+}
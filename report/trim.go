@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// trimImage crops the border of the PNG at path that has the color of its top left pixel, such
+// as the padding around Grafana's solo renders, and replaces the file with the cropped image.
+// Images of a single color are left as they are.
+func trimImage(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	img, err := png.Decode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("error decoding image %v: %v", path, err)
+	}
+
+	crop, ok := contentBounds(img)
+	if !ok || crop == img.Bounds() {
+		return nil
+	}
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return fmt.Errorf("cannot crop image %v of type %T", path, img)
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, sub.SubImage(crop)); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error encoding image %v: %v", path, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// contentBounds is the smallest rectangle holding all pixels that differ from the top left
+// pixel, or false if there are none
+func contentBounds(img image.Image) (image.Rectangle, bool) {
+	b := img.Bounds()
+	if b.Empty() {
+		return b, false
+	}
+	border := color.RGBA64Model.Convert(img.At(b.Min.X, b.Min.Y))
+	isBorder := func(x, y int) bool {
+		return color.RGBA64Model.Convert(img.At(x, y)) == border
+	}
+
+	crop := image.Rectangle{Min: b.Max, Max: b.Min}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if isBorder(x, y) {
+				continue
+			}
+			crop.Min.X, crop.Min.Y = min(crop.Min.X, x), min(crop.Min.Y, y)
+			crop.Max.X, crop.Max.Y = max(crop.Max.X, x+1), max(crop.Max.Y, y+1)
+		}
+	}
+	return crop, !crop.Empty()
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// ValidateTemplate parses a custom TeX template and executes it against sample report data,
+// for both the grid and the row layout. This catches most template errors without needing
+// a Grafana instance or a LaTeX installation.
+func ValidateTemplate(texTemplate string) error {
+	tmpl, err := parseTemplate("template", texTemplate, Options{CaptionSource: CaptionBoth})
+	if err != nil {
+		return fmt.Errorf("error parsing template: %v", err)
+	}
+
+	for _, useRowLayout := range []bool{false, true} {
+		err = tmpl.Execute(ioutil.Discard, sampleTemplData(useRowLayout))
+		if err != nil {
+			return fmt.Errorf("error executing template (row layout: %v): %v", useRowLayout, err)
+		}
+	}
+	return nil
+}
+
+// sampleTemplData fabricates report data covering the panel types and fields templates use
+func sampleTemplData(useRowLayout bool) templData {
+	threshold := 80.0
+	stat := grafana.Panel{Id: 1, Type: "stat", Title: "Uptime % & errors", GridPos: grafana.GridPos{H: 4, W: 6}}
+	stat.FieldConfig.Defaults.Thresholds = grafana.Thresholds{
+		Mode:  "absolute",
+		Steps: []grafana.ThresholdStep{{Color: "green"}, {Color: "red", Value: &threshold}},
+	}
+	singlestat := grafana.Panel{Id: 2, Type: "singlestat", Title: "Requests", GridPos: grafana.GridPos{H: 4, W: 6, X: 6}}
+	graph := grafana.Panel{Id: 3, Type: "graph", Title: "Latency_p99", Description: "99th percentile in ms, {lower} is better", GridPos: grafana.GridPos{H: 8, W: 24, Y: 4}}
+	table := grafana.Panel{Id: 4, Type: "table", Title: "Top #10 hosts", GridPos: grafana.GridPos{H: 8, W: 12, Y: 12}}
+	text := grafana.Panel{Id: 5, Type: "text", Title: "Notes", GridPos: grafana.GridPos{H: 8, W: 12, X: 12, Y: 12}}
+	text.Options.Content = "## On call\n\nCall **#ops** at 100% load:\n\n- web_01\n- `db`"
+
+	return templData{
+		Title:          "Sample dashboard",
+		Description:    "A dashboard fabricated to validate templates",
+		VariableValues: "host: web01, web02; env: prod",
+		ImgDir:         imgDir,
+		FromFormatted:  "now-24h",
+		ToFormatted:    "now",
+		FromResolved:   "1 Jan 2006 15:04 UTC",
+		ToResolved:     "2 Jan 2006 15:04 UTC",
+		TimeRangeText:  "1 Jan 2006 15:04 UTC to 2 Jan 2006 15:04 UTC (now-24h to now)",
+		UseRowLayout:   useRowLayout,
+		ShowRowIntro:   true,
+		ShowTOC:        true,
+		AccentColor:    "1F77B4",
+		PaperSize:      "a4paper",
+		Margin:         "2cm",
+		Watermark:      "CONFIDENTIAL & internal",
+		HeaderImage:    "/etc/grafana-reporter/header.png",
+		CoverPage:      true,
+		CoverLogo:      "/etc/grafana-reporter/logo.png",
+		GeneratedAt:    "2 January 2006 15:04 UTC",
+		FooterText:     "ACME Corp. & Operations #1",
+		Author:         "Ops team",
+		Subject:        "Grafana dashboard report from now-24h to now",
+		Keywords:       "prod, web_tier",
+		DashboardURL:   "https://grafana.example.com/d/sample?from=now-24h&to=now&var-host=web%2001#panel",
+		QRCode:         "qrcode.png",
+		Timeline:       []TimelineMark{{Start: 0.25, End: 0.25, Label: "Jan 2 15:04 Deploy v1.2 & #hotfix"}, {Start: 0.5, End: 0.75, Label: "Jan 2 18:00 Incident"}},
+		Legend:         []LegendEntry{{Color: "73BF69", Meaning: "Healthy & within target"}, {Color: "F2495C", Meaning: "Needs attention"}},
+		Variables: []grafana.TemplateVariable{
+			{Name: "host", Label: "Host_name", IncludeAll: true, Current: grafana.CurrentVal{Text: "All", Value: "$__all"},
+				Options: []grafana.OptionVal{{Text: "All", Value: "$__all"}, {Text: "web01", Value: "web01"}, {Text: "web#02", Value: "web#02"}}},
+			{Name: "env", Current: grafana.CurrentVal{Text: "prod & staging", Value: "prod"}},
+		},
+		FailedPanels: []PanelError{{Id: 6, Title: "Disk_IO", Err: fmt.Errorf("error rendering panel: 500 Internal Server Error")}},
+		Rows: []grafana.GrafanaRow{
+			{Id: 10, Title: "Overview", Showtitle: true, ContentPanels: []grafana.Panel{stat, singlestat, graph}},
+			{Id: 11, Title: "Details", Showtitle: false, ContentPanels: []grafana.Panel{table, text}},
+		},
+		Panels:    []grafana.Panel{stat, singlestat, graph, table, text},
+		RowStarts: map[int]*grafana.GrafanaRow{1: {Id: 10, Title: "Overview"}, 4: {Id: 11, Title: "Details"}},
+	}
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// appendixVariables are the variables listed in the variables appendix: all but hidden ones,
+// or none without the appendix
+func (rep *report) appendixVariables(dash grafana.Dashboard) []grafana.TemplateVariable {
+	if !rep.opts.VariablesAppendix {
+		return nil
+	}
+	var listed []grafana.TemplateVariable
+	for _, v := range dash.Templating.List {
+		if v.Hide != 2 {
+			listed = append(listed, v)
+		}
+	}
+	return listed
+}
+
+// appendixValue is the full selected value of the variable for the appendix. A selection of
+// All lists the values it stands for, if the dashboard lists them.
+func appendixValue(v grafana.TemplateVariable) string {
+	value := variableValue(v)
+	if value != "All" {
+		return value
+	}
+	var all []string
+	for _, o := range v.Options {
+		if o.Value != "$__all" {
+			all = append(all, o.Text)
+		}
+	}
+	if len(all) == 0 {
+		return value
+	}
+	return "All (" + strings.Join(all, ", ") + ")"
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// generateZIP archives the downloaded panel images instead of running LaTeX
+func (rep *report) generateZIP(dash grafana.Dashboard) (io.ReadCloser, error) {
+	err := rep.createZIP(dash)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zip file: %w (temp dir: %s)", err, rep.tmpDir)
+	}
+	rep.progress(StageDocument, 1, 1)
+	file, err := os.Open(rep.zipPath())
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip file '%s': %v", rep.zipPath(), err)
+	}
+	return file, nil
+}
+
+// createZIP writes the images of the report's panels, in report order, to a ZIP archive.
+// Entries are named by panel title; panels without an image are left out.
+func (rep *report) createZIP(dash grafana.Dashboard) error {
+	zipPath := rep.zipPath()
+	file, err := os.Create(zipPath)
+	if err != nil {
+		return fmt.Errorf("error creating zip file at %v : %v", zipPath, err)
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	names := map[string]bool{}
+	for _, p := range rep.reportPanels(dash) {
+		img, err := os.Open(rep.imgFilePath(p.Id))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		entry, err := w.Create(imageFileName(p, names))
+		if err == nil {
+			_, err = io.Copy(entry, img)
+		}
+		img.Close()
+		if err != nil {
+			return fmt.Errorf("error adding panel %d to zip file: %v", p.Id, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error writing zip file %v: %v", zipPath, err)
+	}
+	slog.Info("Created ZIP file", "path", zipPath)
+	return nil
+}
+
+// reportPanels are the panels of the report in the order of its layout
+func (rep *report) reportPanels(dash grafana.Dashboard) []grafana.Panel {
+	if !rep.useRowLayout {
+		return dash.GetGridPanels()
+	}
+	var panels []grafana.Panel
+	for _, row := range dash.GetRows() {
+		panels = append(panels, row.ContentPanels...)
+	}
+	return panels
+}
+
+var unsafeFileNameRegExp = regexp.MustCompile(`[^A-Za-z0-9 ._-]+`)
+
+// imageFileName names the image of a panel after its title. Untitled panels and panels with
+// a title already in names are told apart by their id.
+func imageFileName(p grafana.Panel, names map[string]bool) string {
+	name := strings.Trim(unsafeFileNameRegExp.ReplaceAllString(p.Title, "_"), " ._")
+	if name == "" {
+		name = "panel " + strconv.Itoa(p.Id)
+	} else if names[name] {
+		name += " " + strconv.Itoa(p.Id)
+	}
+	names[name] = true
+	return name + ".png"
+}